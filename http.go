@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+	"github.com/stojg/aunt/lib/compliance"
+	"github.com/stojg/aunt/lib/core"
+	"github.com/stojg/aunt/lib/cost"
+	"github.com/stojg/aunt/lib/database"
+	"github.com/stojg/aunt/lib/digest"
+	"github.com/stojg/aunt/lib/recommend"
+	"github.com/stojg/aunt/lib/schema"
+	"github.com/stojg/aunt/lib/waste"
+)
+
+var started = time.Now()
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>aunt</title></head>
+<body>
+<h1>aunt</h1>
+<p>Version {{.Version}}, compiled {{.Compiled}}, running since {{.Started.Format "2006-01-02 15:04:05 MST"}}</p>
+<p>Schema version {{.SchemaVersion}}</p>
+<h2>Records</h2>
+<table>
+{{range .Buckets}}<tr><td>{{.Name}}</td><td>{{.Keys}}</td></tr>
+{{end}}</table>
+{{with .Cost}}<h2>Estimated monthly cost</h2>
+<p>{{printf "$%.2f" .Total}} for {{.Estimates}} resources{{if .Unpriced}}, {{.Unpriced}} of them without a price{{end}}</p>
+<table>
+{{range $account, $monthly := .Accounts}}<tr><td>Account {{$account}}</td><td>{{printf "$%.2f" $monthly}}</td></tr>
+{{end}}{{range $region, $monthly := .Regions}}<tr><td>Region {{$region}}</td><td>{{printf "$%.2f" $monthly}}</td></tr>
+{{end}}{{range $tag, $monthly := .Tags}}<tr><td>Tag {{$tag}}</td><td>{{printf "$%.2f" $monthly}}</td></tr>
+{{end}}</table>
+{{end}}<h2>Reports</h2>
+<ul>
+<li><a href="/waste">Waste</a></li>
+<li><a href="/cost">Cost</a></li>
+<li><a href="/recommendations">Recommendations</a></li>
+<li><a href="/compliance">Tag compliance</a></li>
+<li><a href="/metrics">Metrics</a></li>
+<li><a href="/silences">Silences</a></li>
+<li><a href="/digest">Digest</a></li>
+</ul>
+<p><a href="/db/backup">Download a database backup</a></p>
+</body>
+</html>
+`))
+
+type bucketCount struct {
+	Name string
+	Keys int
+}
+
+func newHandler(db *storm.DB) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", indexHandler(db))
+	mux.HandleFunc("/db/backup", backupHandler(db))
+	mux.HandleFunc("/waste", wasteHandler(db))
+	mux.HandleFunc("/cost", costHandler(db))
+	mux.HandleFunc("/recommendations", recommendationsHandler(db))
+	mux.HandleFunc("/compliance", complianceHandler(db))
+	mux.HandleFunc("/metrics", metricsHandler(db))
+	mux.HandleFunc("/silences", silencesHandler(db))
+	mux.HandleFunc("/silences/", silenceHandler(db))
+	mux.HandleFunc("/digest", digestHandler(db))
+	return mux
+}
+
+func indexHandler(db *storm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		version, err := schema.Current(db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var buckets []bucketCount
+		err = db.Bolt.View(func(tx *bolt.Tx) error {
+			return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				buckets = append(buckets, bucketCount{Name: string(name), Keys: b.Stats().KeyN})
+				return nil
+			})
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+
+		_, summary, err := cost.Report(db, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if summary.Estimates == 0 {
+			summary = nil
+		}
+
+		data := map[string]interface{}{
+			"Version":       Version,
+			"Compiled":      Compiled,
+			"Started":       started,
+			"SchemaVersion": version,
+			"Buckets":       buckets,
+			"Cost":          summary,
+		}
+		if err := indexTemplate.Execute(w, data); err != nil {
+			fmt.Printf("error during index render: %v\n", err)
+		}
+	}
+}
+
+// backupHandler streams a consistent snapshot of the database, this is the only way to get a backup while the server
+// holds the lock on the database file
+func backupHandler(db *storm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="aunt-%s.db"`, time.Now().Format("20060102-150405")))
+		if _, err := database.Backup(db, w); err != nil {
+			fmt.Printf("error during backup: %v\n", err)
+		}
+	}
+}
+
+// digestHandler shows the digest as it would be sent right now
+func digestHandler(db *storm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := digest.Build(db)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, d)
+	}
+}
+
+func wasteHandler(db *storm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := core.ParseTagFilter(r.URL.Query()["tag"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		findings, err := waste.Report(db, filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, findings)
+	}
+}
+
+func costHandler(db *storm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := core.ParseTagFilter(r.URL.Query()["tag"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		estimates, summary, err := cost.Report(db, filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"Summary": summary, "Estimates": estimates})
+	}
+}
+
+func recommendationsHandler(db *storm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := core.ParseTagFilter(r.URL.Query()["tag"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		recommendations, err := recommend.Report(db, filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, recommendations)
+	}
+}
+
+// silencesHandler lists the active and upcoming silences on GET, add ?all=true to include expired silences, and adds a
+// silence from a JSON body on POST, e.g. {"AlertName": "BurstBalance", "End": "2017-08-21T10:00:00Z", "Comment": "..."}
+func silencesHandler(db *storm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			silences, err := core.Silences(db, r.URL.Query().Get("all") == "true")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, silences)
+		case http.MethodPost:
+			silence := &core.Silence{}
+			if err := json.NewDecoder(r.Body).Decode(silence); err != nil {
+				http.Error(w, fmt.Sprintf("invalid silence: %v", err), http.StatusBadRequest)
+				return
+			}
+			// the id and creation time are set by aunt
+			silence.ID = 0
+			if err := core.AddSilence(db, silence); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			writeJSON(w, silence)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// silenceHandler expires the silence with the id in the path on DELETE, e.g. DELETE /silences/3
+func silenceHandler(db *storm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/silences/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if err := core.ExpireSilence(db, id); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// complianceHandler lists the compliance of each account and the resources that doesn't follow the tag policy, add
+// ?account=123456 to only list one account
+func complianceHandler(db *storm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scores, violations, err := compliance.Report(db, r.URL.Query().Get("account"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"Accounts":   scores,
+			"Violations": violations,
+		})
+	}
+}
+
+// metricNameCleaner replaces characters that has a special meaning in graphite metric paths
+var metricNameCleaner = strings.NewReplacer(".", "_", " ", "_", "/", "_", "=", "_")
+
+// metricsHandler writes the cost totals and the tag compliance of each account in the graphite plaintext format, e.g.
+// aunt.cost.account.123456.MonthlyCost 1234.56 1500000000
+// aunt.compliance.account.123456.CompliancePercent 87.500000 1500000000
+// add ?tag=team=web to only total the resources with that tag, the compliance is left out when filtering by tag
+func metricsHandler(db *storm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := core.ParseTagFilter(r.URL.Query()["tag"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		totals, err := cost.Totals(db, filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		for _, t := range totals {
+			var names []string
+			for name := range t.Metrics {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if t.Metrics[name] == nil {
+					continue
+				}
+				path := fmt.Sprintf("aunt.cost.%s.%s.%s", t.Scope, metricNameCleaner.Replace(t.Key), name)
+				fmt.Fprintf(w, "%s %f %d\n", path, *t.Metrics[name], t.LastUpdated.Unix())
+			}
+		}
+		if len(filter) > 0 {
+			return
+		}
+		scores, _, err := compliance.Report(db, "")
+		if err != nil {
+			fmt.Printf("error during compliance metrics: %v\n", err)
+			return
+		}
+		for _, s := range scores {
+			var names []string
+			for name := range s.Metrics {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if s.Metrics[name] == nil {
+					continue
+				}
+				path := fmt.Sprintf("aunt.compliance.account.%s.%s", metricNameCleaner.Replace(s.Account), name)
+				fmt.Fprintf(w, "%s %f %d\n", path, *s.Metrics[name], s.LastUpdated.Unix())
+			}
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		fmt.Printf("error during json encode: %v\n", err)
+	}
+}
@@ -15,10 +15,13 @@ import (
 
 // AutoScalingGroup contains app specific data for auto scaling groups
 type AutoScalingGroup struct {
-	Name        string
-	ResourceID  string `storm:"id"`
-	Region      string
-	Account     string
+	Name       string
+	ResourceID string `storm:"id"`
+	Region     string
+	Account    string
+	// Instances are the ids of the instances in the group
+	Instances   []string
+	Tags        map[string]string
 	LastUpdated time.Time
 	Metrics     map[string]*float64
 }
@@ -74,10 +77,19 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 				ResourceID:  *data.AutoScalingGroupName,
 				Region:      region,
 				Account:     account,
+				Tags:        make(map[string]string),
 				LastUpdated: time.Now(),
 				Metrics:     make(map[string]*float64),
 			}
 
+			for _, tag := range data.Tags {
+				asg.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+
+			for _, instance := range data.Instances {
+				asg.Instances = append(asg.Instances, aws.StringValue(instance.InstanceId))
+			}
+
 			asg.Metrics[metricNumEvents] = aws.Float64(0)
 
 			since := time.Now().Add(-2 * time.Hour)
@@ -97,13 +109,34 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 			if err := db.Save(asg); err != nil {
 				fmt.Printf("%+v\n", err)
 			}
+			anomalies, checked, err := core.Anomalies(db, "AutoScalingGroup", asg.ResourceID, asg.Metrics)
+			if err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			if err := core.RecordMetrics(db, "AutoScalingGroup", asg.ResourceID, asg.Metrics); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			for _, alert := range anomalies {
+				alert.Message = fmt.Sprintf("%s for ASG %s", alert.Message, asg.Name)
+				alert.Details["account"] = asg.Account
+				alert.Details["region"] = asg.Region
+				alert.Details["resource_id"] = asg.ResourceID
+				alert.ResourceTags = asg.Tags
+				alert.Description = description
+				if err := alert.Save(db); err != nil {
+					fmt.Printf("%+v\n", err)
+				}
+			}
 
-			if *asg.Metrics[metricNumEvents] >= metricNumEventsThreshold {
+			// the number of scaling events depends on how busy the group is, so the baseline replaces the static
+			// threshold once the group has one
+			if !checked[metricNumEvents] && *asg.Metrics[metricNumEvents] >= metricNumEventsThreshold {
 				alert := core.NewAlert(metricNumEvents, asg.ResourceID)
 				alert.Message = fmt.Sprintf("ASG %s has %0.f unexpected events in the last 2 hours, threshold %.0f", asg.Name, *asg.Metrics[metricNumEvents], metricNumEventsThreshold)
 				alert.Details["account"] = asg.Account
 				alert.Details["region"] = asg.Region
 				alert.Details["resource_id"] = asg.ResourceID
+				alert.ResourceTags = asg.Tags
 				alert.Details["num_scaling_events"] = fmt.Sprintf("%.0f", *asg.Metrics[metricNumEvents])
 				alert.Description = description
 				if err := alert.Save(db); err != nil {
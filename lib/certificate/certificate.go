@@ -0,0 +1,266 @@
+package certificate
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stojg/aunt/lib/core"
+)
+
+// Certificate is an app specific representation of an ACM certificate or an IAM server certificate
+type Certificate struct {
+	Name string
+	// ResourceID is the certificate ARN
+	ResourceID              string `storm:"id"`
+	Source                  string
+	DomainName              string
+	SubjectAlternativeNames []string
+	// InUseBy are the ARNs of the resources using this certificate, this is only known for ACM certificates
+	InUseBy       []string
+	Type          string
+	Status        string
+	FailureReason string
+	NotAfter      *time.Time
+	LaunchTime    *time.Time
+	Region        string
+	Account       string
+	// Tags are only set for ACM certificates, IAM server certificates can't be tagged
+	Tags        map[string]string
+	LastUpdated time.Time
+	Metrics     map[string]*float64
+}
+
+const (
+	sourceACM = "acm"
+	sourceIAM = "iam"
+)
+
+// iamRegion is used for IAM certificates since they are global and not tied to a region
+const iamRegion = "global"
+
+const (
+	metricDaysToExpiry = "DaysToExpiry"
+)
+
+var expiryStages = []int{30, 14, 3}
+
+// failedStatuses are the ACM certificate statuses that will never result in a usable certificate
+var failedStatuses = map[string]bool{
+	acm.CertificateStatusFailed:             true,
+	acm.CertificateStatusValidationTimedOut: true,
+	acm.CertificateStatusRevoked:            true,
+}
+
+// SetExpiryStages sets the number of days before expiry when a certificate alert is raised. Each stage closer to the
+// expiry date raises a new alert with a higher priority.
+func SetExpiryStages(days []int) error {
+	for _, d := range days {
+		if d < 0 {
+			return fmt.Errorf("certificate expiry stage can't be negative: %d", d)
+		}
+	}
+	stages := append([]int{}, days...)
+	sort.Sort(sort.Reverse(sort.IntSlice(stages)))
+	expiryStages = stages
+	return nil
+}
+
+// Update will update the database with Certificate data
+func Update(db *storm.DB, roles map[string]string, regions []string) error {
+	// update all accounts in parallel to speed this up
+	core.UpdateAccounts(roles, func(account, role string) {
+		updateForRole(db, account, role, regions)
+	})
+	return nil
+}
+
+func updateForRole(db *storm.DB, account, role string, regions []string) {
+	for _, region := range regions {
+		sess, config := core.NewCredentials(region, role)
+		svc := acm.New(sess, config)
+
+		// without a status filter only a subset of the certificates are returned
+		input := &acm.ListCertificatesInput{
+			CertificateStatuses: aws.StringSlice([]string{
+				acm.CertificateStatusPendingValidation,
+				acm.CertificateStatusIssued,
+				acm.CertificateStatusInactive,
+				acm.CertificateStatusExpired,
+				acm.CertificateStatusValidationTimedOut,
+				acm.CertificateStatusRevoked,
+				acm.CertificateStatusFailed,
+			}),
+		}
+		var summaries []*acm.CertificateSummary
+		err := svc.ListCertificatesPages(input, func(page *acm.ListCertificatesOutput, lastPage bool) bool {
+			summaries = append(summaries, page.CertificateSummaryList...)
+			return true
+		})
+		if err != nil {
+			fmt.Printf("acm.ListCertificates %s %s %v\n", role, region, err)
+			continue
+		}
+
+		for _, summary := range summaries {
+			resp, err := svc.DescribeCertificate(&acm.DescribeCertificateInput{CertificateArn: summary.CertificateArn})
+			if err != nil {
+				fmt.Printf("acm.DescribeCertificate %s %s %v\n", role, region, err)
+				continue
+			}
+			data := resp.Certificate
+			cert := &Certificate{
+				Name:                    aws.StringValue(data.DomainName),
+				ResourceID:              *data.CertificateArn,
+				Source:                  sourceACM,
+				DomainName:              aws.StringValue(data.DomainName),
+				SubjectAlternativeNames: aws.StringValueSlice(data.SubjectAlternativeNames),
+				InUseBy:                 aws.StringValueSlice(data.InUseBy),
+				Type:                    aws.StringValue(data.Type),
+				Status:                  aws.StringValue(data.Status),
+				FailureReason:           aws.StringValue(data.FailureReason),
+				NotAfter:                data.NotAfter,
+				LaunchTime:              data.CreatedAt,
+				Region:                  region,
+				Account:                 account,
+				Tags:                    tags(svc, data.CertificateArn),
+			}
+			if cert.LaunchTime == nil {
+				cert.LaunchTime = data.ImportedAt
+			}
+			save(db, cert)
+		}
+	}
+
+	// IAM server certificates are global so they only needs to be fetched once per account
+	if len(regions) == 0 {
+		return
+	}
+	sess, config := core.NewCredentials(regions[0], role)
+	svc := iam.New(sess, config)
+	err := svc.ListServerCertificatesPages(&iam.ListServerCertificatesInput{}, func(page *iam.ListServerCertificatesOutput, lastPage bool) bool {
+		for _, data := range page.ServerCertificateMetadataList {
+			cert := &Certificate{
+				Name:       *data.ServerCertificateName,
+				ResourceID: *data.Arn,
+				Source:     sourceIAM,
+				Type:       "IMPORTED",
+				Status:     acm.CertificateStatusIssued,
+				NotAfter:   data.Expiration,
+				LaunchTime: data.UploadDate,
+				Region:     iamRegion,
+				Account:    account,
+			}
+			if cert.NotAfter != nil && cert.NotAfter.Before(time.Now()) {
+				cert.Status = acm.CertificateStatusExpired
+			}
+			save(db, cert)
+		}
+		return true
+	})
+	if err != nil {
+		fmt.Printf("iam.ListServerCertificates %s %v\n", role, err)
+	}
+}
+
+// tags returns the tags of an ACM certificate
+func tags(svc *acm.ACM, arn *string) map[string]string {
+	result := make(map[string]string)
+	resp, err := svc.ListTagsForCertificate(&acm.ListTagsForCertificateInput{CertificateArn: arn})
+	if err != nil {
+		fmt.Printf("acm.ListTagsForCertificate %s %v\n", aws.StringValue(arn), err)
+		return result
+	}
+	for _, tag := range resp.Tags {
+		result[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return result
+}
+
+func save(db *storm.DB, cert *Certificate) {
+	cert.LastUpdated = time.Now()
+	cert.Metrics = make(map[string]*float64)
+	if cert.NotAfter != nil {
+		cert.Metrics[metricDaysToExpiry] = aws.Float64(math.Floor(time.Until(*cert.NotAfter).Hours() / 24))
+	}
+	if err := db.Save(cert); err != nil {
+		fmt.Printf("%+v\n", err)
+	}
+
+	// check status
+	if failedStatuses[cert.Status] {
+		alert := newAlert("CertificateFailed", cert)
+		alert.Message = fmt.Sprintf("Certificate for %s has status %s", cert.Name, cert.Status)
+		if cert.FailureReason != "" {
+			alert.Details["failure_reason"] = cert.FailureReason
+		}
+		core.SaveAlert(db, alert)
+		return
+	}
+	if cert.Status == acm.CertificateStatusPendingValidation {
+		alert := newAlert("CertificatePendingValidation", cert)
+		alert.Message = fmt.Sprintf("Certificate for %s is pending validation", cert.Name)
+		alert.Priority = core.P3
+		core.SaveAlert(db, alert)
+		return
+	}
+
+	// ACM certificates that isn't in use are never served, so it doesn't matter that they expire
+	if cert.Source == sourceACM && len(cert.InUseBy) == 0 {
+		return
+	}
+	days := cert.Metrics[metricDaysToExpiry]
+	if days == nil {
+		return
+	}
+	stage := -1
+	for i, d := range expiryStages {
+		if *days <= float64(d) {
+			stage = i
+		}
+	}
+	if stage < 0 {
+		return
+	}
+	// each stage gets its own alert so that the priority is raised when the expiry date gets closer
+	alert := newAlert(fmt.Sprintf("CertificateExpiry%d", expiryStages[stage]), cert)
+	alert.Priority = stagePriority(stage, len(expiryStages))
+	if *days < 0 {
+		alert.Message = fmt.Sprintf("Certificate for %s expired %s", cert.Name, cert.NotAfter.Local().Format(time.RFC822))
+	} else {
+		alert.Message = fmt.Sprintf("Certificate for %s expires in %.0f days", cert.Name, *days)
+	}
+	core.SaveAlert(db, alert)
+}
+
+// stagePriority returns P1 for the last stage, P2 for the one before and so on
+func stagePriority(stage, stages int) string {
+	priorities := []string{core.P1, core.P2, core.P3, core.P4, core.P5}
+	idx := stages - 1 - stage
+	if idx >= len(priorities) {
+		idx = len(priorities) - 1
+	}
+	return priorities[idx]
+}
+
+func newAlert(name string, cert *Certificate) *core.Alert {
+	alert := core.ResourceAlert(name, cert.ResourceID, cert.Account, cert.Region, cert.Tags)
+	alert.Details["source"] = cert.Source
+	alert.Details["status"] = cert.Status
+	if cert.NotAfter != nil {
+		alert.Details["expires"] = cert.NotAfter.Format(time.RFC3339)
+	}
+	if len(cert.SubjectAlternativeNames) > 0 {
+		alert.Details["domains"] = strings.Join(cert.SubjectAlternativeNames, ", ")
+	}
+	if len(cert.InUseBy) > 0 {
+		alert.Details["in_use_by"] = strings.Join(cert.InUseBy, ", ")
+	}
+	return alert
+}
@@ -0,0 +1,353 @@
+package compliance
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stojg/aunt/lib/asg"
+	"github.com/stojg/aunt/lib/certificate"
+	"github.com/stojg/aunt/lib/core"
+	"github.com/stojg/aunt/lib/dynamodb"
+	"github.com/stojg/aunt/lib/ebs"
+	"github.com/stojg/aunt/lib/ec2"
+	"github.com/stojg/aunt/lib/ecs"
+	"github.com/stojg/aunt/lib/elasticache"
+	"github.com/stojg/aunt/lib/elb"
+	"github.com/stojg/aunt/lib/iam"
+	"github.com/stojg/aunt/lib/lambda"
+	"github.com/stojg/aunt/lib/rds"
+	"github.com/stojg/aunt/lib/sqs"
+)
+
+// Config is the tag policy that every resource is checked against
+type Config struct {
+	// Required are the tags that every resource must have, a key can also be a tag role like team or environment
+	Required []string
+	// Allowed are the values that a tag may have, e.g. {"environment": ["prod", "staging", "dev"]}, a resource without
+	// the tag only violates the policy if the tag is also required
+	Allowed map[string][]string
+	// Kinds limits the check to some kinds of resources, all kinds that can be tagged are checked when empty
+	Kinds []string
+	// Alerts raises an alert for every resource that violates the policy
+	Alerts bool
+	// Priority of the alerts, P5 when not set
+	Priority string
+}
+
+// Violation is a resource that doesn't follow the tag policy
+type Violation struct {
+	// ResourceID is the kind, account, region and id of the resource, e.g. Instance/production/us-east-1/i-123456, since
+	// some resources like DynamoDB tables are only identified by their name
+	ResourceID string `storm:"id"`
+	Kind       string `storm:"index"`
+	Name       string
+	Resource   string
+	// Missing are the required tags that the resource doesn't have
+	Missing []string
+	// Invalid are the tags that has a value that isn't allowed, keyed by the tag
+	Invalid     map[string]string
+	Region      string
+	Account     string
+	Tags        map[string]string
+	LastUpdated time.Time `storm:"index"`
+}
+
+// Score is how many of the resources in an account that follows the tag policy
+type Score struct {
+	// ResourceID is the account
+	ResourceID  string `storm:"id"`
+	Account     string
+	Resources   int
+	Compliant   int
+	LastUpdated time.Time `storm:"index"`
+	Metrics     map[string]*float64
+}
+
+const (
+	metricCompliancePercent = "CompliancePercent"
+	metricViolations        = "Violations"
+)
+
+// kinds returns a pointer to an empty slice of the stored resources of each kind that can be tagged
+var kinds = map[string]func() interface{}{
+	"AutoScalingGroup":     func() interface{} { return &[]asg.AutoScalingGroup{} },
+	"Certificate":          func() interface{} { return &[]certificate.Certificate{} },
+	"Table":                func() interface{} { return &[]dynamodb.Table{} },
+	"GlobalSecondaryIndex": func() interface{} { return &[]dynamodb.GlobalSecondaryIndex{} },
+	"Volume":               func() interface{} { return &[]ebs.Volume{} },
+	"Instance":             func() interface{} { return &[]ec2.Instance{} },
+	"ECSCluster":           func() interface{} { return &[]ecs.Cluster{} },
+	"ECSService":           func() interface{} { return &[]ecs.Service{} },
+	"ContainerInstance":    func() interface{} { return &[]ecs.ContainerInstance{} },
+	"CacheCluster":         func() interface{} { return &[]elasticache.CacheCluster{} },
+	"ReplicationGroup":     func() interface{} { return &[]elasticache.ReplicationGroup{} },
+	"LoadBalancer":         func() interface{} { return &[]elb.LoadBalancer{} },
+	"TargetGroup":          func() interface{} { return &[]elb.TargetGroup{} },
+	"IAMUser":              func() interface{} { return &[]iam.User{} },
+	"Function":             func() interface{} { return &[]lambda.Function{} },
+	"DBInstance":           func() interface{} { return &[]rds.DBInstance{} },
+	"DBCluster":            func() interface{} { return &[]rds.DBCluster{} },
+	"Queue":                func() interface{} { return &[]sqs.Queue{} },
+}
+
+var settings = Config{Priority: core.P5}
+
+// Configure validates and sets the tag policy
+func Configure(cfg Config) error {
+	for _, key := range cfg.Required {
+		if key == "" {
+			return fmt.Errorf("a required tag can't be empty")
+		}
+	}
+	for key, values := range cfg.Allowed {
+		if len(values) == 0 {
+			return fmt.Errorf("tag %s has no allowed values", key)
+		}
+	}
+	for _, kind := range cfg.Kinds {
+		if _, ok := kinds[kind]; !ok {
+			return fmt.Errorf("tag policy has an unknown kind %q, it should be one of %s", kind, strings.Join(Kinds(), ", "))
+		}
+	}
+	switch cfg.Priority {
+	case "":
+		cfg.Priority = core.P5
+	case core.P1, core.P2, core.P3, core.P4, core.P5:
+	default:
+		return fmt.Errorf("tag policy has an unknown priority %q", cfg.Priority)
+	}
+	settings = cfg
+	return nil
+}
+
+// Enabled returns true when there is a tag policy
+func Enabled() bool {
+	return len(settings.Required) > 0 || len(settings.Allowed) > 0
+}
+
+// Kinds returns the kinds of resources that can be checked
+func Kinds() []string {
+	var names []string
+	for kind := range kinds {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Update checks the stored resources against the tag policy and stores the violations and a score per account. It uses
+// the stored resources so it should run after those has been updated.
+func Update(db *storm.DB) error {
+	if !Enabled() {
+		return nil
+	}
+	started := time.Now()
+	checked := settings.Kinds
+	if len(checked) == 0 {
+		checked = Kinds()
+	}
+
+	scores := make(map[string]*Score)
+	for _, kind := range checked {
+		resources := kinds[kind]()
+		if err := db.Select(q.Gte("LastUpdated", started.Add(-core.MaxResourceAge))).Find(resources); err != nil && err != storm.ErrNotFound {
+			return err
+		}
+		list := reflect.ValueOf(resources).Elem()
+		for i := 0; i < list.Len(); i++ {
+			resource := list.Index(i)
+			// IAM server certificates and the root account can't be tagged
+			if kind == "Certificate" && field(resource, "Source") == "iam" {
+				continue
+			}
+			if kind == "IAMUser" && field(resource, "Name") == "<root_account>" {
+				continue
+			}
+			account := field(resource, "Account")
+			score, ok := scores[account]
+			if !ok {
+				score = &Score{ResourceID: account, Account: account}
+				scores[account] = score
+			}
+			score.Resources++
+
+			violation := check(kind, resource)
+			if violation == nil {
+				score.Compliant++
+				continue
+			}
+			violation.LastUpdated = started
+			if err := db.Save(violation); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			if settings.Alerts {
+				raise(db, violation)
+			}
+		}
+	}
+
+	for _, score := range scores {
+		score.LastUpdated = started
+		score.Metrics = map[string]*float64{
+			metricCompliancePercent: aws.Float64(score.Percent()),
+			metricViolations:        aws.Float64(float64(score.Resources - score.Compliant)),
+		}
+		if err := db.Save(score); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+	}
+
+	// anything that wasn't found during this update has been removed or tagged
+	var staleViolations []Violation
+	if err := db.Select(q.Lt("LastUpdated", started)).Find(&staleViolations); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for i := range staleViolations {
+		if err := db.DeleteStruct(&staleViolations[i]); err != nil {
+			fmt.Printf("compliance purge error: %v %s\n", err, staleViolations[i].ResourceID)
+		}
+	}
+	var staleScores []Score
+	if err := db.Select(q.Lt("LastUpdated", started)).Find(&staleScores); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for i := range staleScores {
+		if err := db.DeleteStruct(&staleScores[i]); err != nil {
+			fmt.Printf("compliance purge error: %v %s\n", err, staleScores[i].ResourceID)
+		}
+	}
+	return nil
+}
+
+// Report returns the scores and the violations for an account, or for all accounts when the account is empty. The
+// scores are sorted by account and the violations by account, region, kind and name.
+func Report(db *storm.DB, account string) ([]Score, []Violation, error) {
+	var scores []Score
+	var violations []Violation
+	if account == "" {
+		if err := db.All(&scores); err != nil && err != storm.ErrNotFound {
+			return nil, nil, err
+		}
+		if err := db.All(&violations); err != nil && err != storm.ErrNotFound {
+			return nil, nil, err
+		}
+	} else {
+		if err := db.Select(q.Eq("Account", account)).Find(&scores); err != nil && err != storm.ErrNotFound {
+			return nil, nil, err
+		}
+		if err := db.Select(q.Eq("Account", account)).Find(&violations); err != nil && err != storm.ErrNotFound {
+			return nil, nil, err
+		}
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Account < scores[j].Account })
+	sort.Slice(violations, func(i, j int) bool {
+		a, b := violations[i], violations[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return scores, violations, nil
+}
+
+// Percent returns how many percent of the resources in the account that follows the tag policy
+func (s *Score) Percent() float64 {
+	if s.Resources == 0 {
+		return 100
+	}
+	return float64(s.Compliant) / float64(s.Resources) * 100
+}
+
+// Problems returns the missing and invalid tags as text, e.g. missing owner, environment=qa isn't allowed
+func (v *Violation) Problems() string {
+	var problems []string
+	if len(v.Missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(v.Missing, ", "))
+	}
+	var keys []string
+	for key := range v.Invalid {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		problems = append(problems, fmt.Sprintf("%s=%s isn't allowed", key, v.Invalid[key]))
+	}
+	return strings.Join(problems, ", ")
+}
+
+// check returns the violation for a resource, it's nil when the resource follows the policy
+func check(kind string, resource reflect.Value) *Violation {
+	tags, _ := resource.FieldByName("Tags").Interface().(map[string]string)
+	account, region := field(resource, "Account"), field(resource, "Region")
+	violation := &Violation{
+		ResourceID: strings.Join([]string{kind, account, region, field(resource, "ResourceID")}, "/"),
+		Kind:       kind,
+		Name:       field(resource, "Name"),
+		Resource:   field(resource, "ResourceID"),
+		Invalid:    make(map[string]string),
+		Region:     region,
+		Account:    account,
+		Tags:       tags,
+	}
+	for _, key := range settings.Required {
+		if core.ResourceTag(tags, key) == "" {
+			violation.Missing = append(violation.Missing, key)
+		}
+	}
+	for key, allowed := range settings.Allowed {
+		value := core.ResourceTag(tags, key)
+		if value != "" && !contains(allowed, value) {
+			violation.Invalid[key] = value
+		}
+	}
+	if len(violation.Missing) == 0 && len(violation.Invalid) == 0 {
+		return nil
+	}
+	return violation
+}
+
+func raise(db *storm.DB, violation *Violation) {
+	// the violation id is unique across accounts, unlike the id of some resources
+	alert := core.NewAlert("TagCompliance", violation.ResourceID)
+	alert.Message = fmt.Sprintf("%s %s doesn't follow the tag policy: %s", violation.Kind, violation.Name, violation.Problems())
+	alert.Priority = settings.Priority
+	alert.Details["account"] = violation.Account
+	alert.Details["region"] = violation.Region
+	alert.Details["resource_id"] = violation.Resource
+	if len(violation.Missing) > 0 {
+		alert.Details["missing"] = strings.Join(violation.Missing, ", ")
+	}
+	alert.ResourceTags = violation.Tags
+	if err := alert.Save(db); err != nil {
+		fmt.Printf("%+v\n", err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func field(resource reflect.Value, name string) string {
+	v := resource.FieldByName(name)
+	if !v.IsValid() || v.Kind() != reflect.String {
+		return ""
+	}
+	return v.String()
+}
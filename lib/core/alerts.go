@@ -11,6 +11,24 @@ import (
 
 var alertCli *client.OpsGenieAlertV2Client
 
+// alertSource and alertTags are sent with every alert
+var (
+	alertSource = "aunt"
+	alertTags   = defaultAlertTags
+)
+
+// defaultAlertTags are sent with every alert when the tags aren't configured
+var defaultAlertTags = []string{"SSP"}
+
+// Alert priorities, P1 is the most urgent and P5 is informational
+const (
+	P1 = "P1"
+	P2 = "P2"
+	P3 = "P3"
+	P4 = "P4"
+	P5 = "P5"
+)
+
 // SetOpsGenieToken sets the api key and initialises an OpsGenie alert client
 func SetOpsGenieToken(apiKey string) error {
 	cli := &client.OpsGenieClient{}
@@ -20,12 +38,28 @@ func SetOpsGenieToken(apiKey string) error {
 	return err
 }
 
+// SetAlertDefaults sets the source and the tags that are sent with every alert, the source is aunt when empty and the
+// tags are SSP when nil
+func SetAlertDefaults(source string, tags []string) {
+	alertSource = "aunt"
+	if source != "" {
+		alertSource = source
+	}
+	// an empty list turns the default tags off
+	alertTags = defaultAlertTags
+	if tags != nil {
+		alertTags = tags
+	}
+}
+
 // NewAlert returns a new Alert
 func NewAlert(name, resourceID string) *Alert {
 	return &Alert{
 		ID:          fmt.Sprintf("aunt.%s.%s", name, resourceID),
+		Name:        name,
 		Entity:      resourceID,
 		Details:     make(map[string]string),
+		Priority:    P2,
 		LastUpdated: time.Now(),
 	}
 }
@@ -53,6 +87,8 @@ func Purge(db *storm.DB, olderThan time.Duration) error {
 type Alert struct {
 	// ID, The unique identifier for this alert
 	ID string
+	// Name of the alert, e.g. CPUCreditBalance, the same alert is raised for many resources
+	Name string
 	// Alert text, should not be more than 130 characters long
 	Message string
 	// The name of the entity that the alert is related to. For example, name of the application, server etc.
@@ -63,8 +99,32 @@ type Alert struct {
 	Description string
 	// User defined properties of this alert, e.g. IP addresses, limits, accounts and regions
 	Details map[string]string
+	// Priority of the alert, P1 to P5
+	Priority string
+	// ResourceTags are the AWS tags of the resource, they are used for matching silences and routing
+	ResourceTags map[string]string
+	// Tags are sent with the alert in addition to the default tags, e.g. to filter alerts in OpsGenie
+	Tags []string
+	// Team is the OpsGenie team that owns the resource, it's looked up from the team and owner resource tags when not set
+	Team string
+	// Responders are other OpsGenie teams that are notified about the alert
+	Responders []string
+	// Actions are the custom OpsGenie actions that can be run on the alert, e.g. Restart
+	Actions []string
+	// Silenced is the silence, maintenance window or parent alert that stopped the alert from being notified
+	Silenced string
+	// Parent is the ID of the alert on a parent resource that this alert is suppressed by or grouped under
+	Parent string
+	// Grouped is true when the alert is listed on the parent alert instead of being notified on its own
+	Grouped bool
+	// Digested is true when the alert is sent in the digest instead of being notified on its own
+	Digested bool
+	// Pending is true while the alert is waiting to be notified together with other alerts that shares its keys
+	Pending bool
+	// Notified is true when the alert has been sent to OpsGenie and not closed since
+	Notified bool
 	// LastUpdated represents the last time this alert was raised
-	LastUpdated time.Time
+	LastUpdated time.Time `storm:"index"`
 }
 
 // Returns a string representation of this alert
@@ -72,45 +132,134 @@ func (a *Alert) String() string {
 	return fmt.Sprintf("%s (%s), %s", a.Message, a.Entity, a.Details)
 }
 
-// Save this Alert to the database and sends an alert to OpsGenie
+// Save this Alert to the database and sends an alert to OpsGenie, unless the alert is silenced, sent in the digest or
+// waiting to be grouped with other alerts
 func (a *Alert) Save(db *storm.DB) error {
-	fmt.Printf("Creating: %s\n", a)
+	if a.Priority == "" {
+		a.Priority = P2
+	}
+	if a.Team == "" {
+		a.Team = teamFromTags(a.ResourceTags)
+	}
+	var previous Alert
+	if err := db.One("ID", a.ID, &previous); err != nil && err != storm.ErrNotFound {
+		fmt.Printf("error during alert lookup: %v\n", err)
+	}
+	a.Notified = previous.Notified
+	silenced, err := silencedBy(db, a)
+	if err != nil {
+		fmt.Printf("error during silence lookup: %v\n", err)
+	}
+	a.Silenced = silenced
+	a.Parent, a.Grouped, a.Digested = "", false, false
+	// the alert is correlated with the alerts on its parent resources once every alert of the update has been raised
+	a.Pending = a.Silenced == "" && len(correlationRules) > 0
+	if !a.Pending {
+		a.route()
+	}
+	switch {
+	case a.Silenced != "":
+		fmt.Printf("Silenced (%s): %s\n", a.Silenced, a)
+	case a.Digested:
+		fmt.Printf("Digest: %s\n", a)
+	default:
+		fmt.Printf("Creating: %s\n", a)
+	}
+	notifyErr := a.send()
 	if err := db.Save(a); err != nil {
 		return err
 	}
-	if alertCli == nil {
+	return notifyErr
+}
+
+// route decides if an alert that isn't silenced is sent in the digest, waits to be grouped or is notified on its own
+func (a *Alert) route() {
+	a.Digested = a.Silenced == "" && digestPriorities[a.Priority]
+	a.Pending = a.Silenced == "" && !a.Digested && len(grouping.By) > 0
+}
+
+// send notifies the alert unless it's silenced, digested or pending
+func (a *Alert) send() error {
+	switch {
+	case a.Pending:
+		// sent or closed by Notify
+		return nil
+	case a.Silenced != "" || a.Digested:
+		// an alert that was sent before it was silenced would otherwise stay open in OpsGenie until it expires
+		if a.Notified {
+			return a.close()
+		}
 		return nil
 	}
+	return a.notify()
+}
 
+// notify sends the alert to OpsGenie and marks it as notified
+func (a *Alert) notify() error {
+	if alertCli == nil {
+		a.Notified = true
+		return nil
+	}
 	if len(a.Description) > 5000 {
 		a.Description = a.Description[0:4999]
 	}
+	var teams []alertsv2.TeamRecipient
+	for _, name := range a.Teams() {
+		teams = append(teams, &alertsv2.Team{Name: name})
+	}
 	_, err := alertCli.Create(alertsv2.CreateAlertRequest{
 		Message:     a.Message,
 		Alias:       a.ID,
 		Details:     a.Details,
 		Description: a.Description,
 		Entity:      a.Entity,
-		Source:      "aunt ",
-		Priority:    alertsv2.P2,
-		Tags:        []string{"SSP"},
+		Source:      alertSource,
+		Priority:    alertsv2.Priority(a.Priority),
+		Tags:        a.NotifyTags(),
+		Teams:       teams,
+		Actions:     a.Actions,
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	a.Notified = true
+	return nil
 }
 
-// Delete this Alert and close the OpsGenie alert
+// close closes the OpsGenie alert and marks it as no longer notified
+func (a *Alert) close() error {
+	if alertCli != nil {
+		_, err := alertCli.Close(alertsv2.CloseRequest{
+			Identifier: &alertsv2.Identifier{
+				Alias: a.ID,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	a.Notified = false
+	return nil
+}
+
+// Teams returns the owning team followed by the responders, without duplicates
+func (a *Alert) Teams() []string {
+	return unique(append([]string{a.Team}, a.Responders...))
+}
+
+// NotifyTags returns the default tags followed by the tags of the alert, without duplicates
+func (a *Alert) NotifyTags() []string {
+	return unique(append(append([]string{}, alertTags...), a.Tags...))
+}
+
+// Delete this Alert and close the OpsGenie alert if it was sent, silenced and digested alerts were never created there
 func (a *Alert) Delete(db *storm.DB) error {
 	fmt.Printf("Closing: %s\n", a)
 	if err := db.DeleteStruct(a); err != nil {
 		return err
 	}
-	if alertCli == nil {
+	if !a.Notified {
 		return nil
 	}
-	_, err := alertCli.Close(alertsv2.CloseRequest{
-		Identifier: &alertsv2.Identifier{
-			Alias: a.ID,
-		},
-	})
-	return err
+	return a.close()
 }
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaveNotified(t *testing.T) {
+	db := openDB(t)
+	notified := func(id string) bool {
+		t.Helper()
+		var alert Alert
+		if err := db.One("ID", id, &alert); err != nil {
+			t.Fatal(err)
+		}
+		return alert.Notified
+	}
+
+	if err := NewAlert("CPUCreditBalance", "i-1").Save(db); err != nil {
+		t.Fatal(err)
+	}
+	if !notified("aunt.CPUCreditBalance.i-1") {
+		t.Error("expected the alert to be notified")
+	}
+
+	// the alert is closed once it's silenced and isn't notified again while the silence is active
+	silence := &Silence{Matcher: Matcher{ResourceID: "i-1"}, End: time.Now().Add(time.Hour)}
+	if err := AddSilence(db, silence); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := NewAlert("CPUCreditBalance", "i-1").Save(db); err != nil {
+			t.Fatal(err)
+		}
+		if notified("aunt.CPUCreditBalance.i-1") {
+			t.Errorf("save %d: expected the silenced alert to be closed", i)
+		}
+	}
+
+	// a digested alert is never notified
+	previous := digestPriorities
+	t.Cleanup(func() { digestPriorities = previous })
+	if err := SetDigestPriorities([]string{P5}); err != nil {
+		t.Fatal(err)
+	}
+	digested := NewAlert("Unused", "vol-1")
+	digested.Priority = P5
+	if err := digested.Save(db); err != nil {
+		t.Fatal(err)
+	}
+	if notified(digested.ID) {
+		t.Error("expected the digested alert not to be notified")
+	}
+}
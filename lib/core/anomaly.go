@@ -0,0 +1,195 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+)
+
+// AnomalyConfig holds the settings for the anomaly detection, it learns a baseline for each metric on each resource
+// from the metric history and alerts when a value is too far from it
+type AnomalyConfig struct {
+	// Enabled turns on the anomaly detection, it's off by default
+	Enabled bool
+	// Sigma is how many standard deviations from the baseline mean a value can be before it's an anomaly
+	Sigma float64
+	// WindowDays is how many days of history the baseline is learnt from
+	WindowDays int
+	// WarmupDays is how many days of history a metric needs before it's checked, it's at least 7 days with HourOfWeek
+	WarmupDays int
+	// MinSamples is how many samples the baseline needs before a metric is checked
+	MinSamples int
+	// HourOfWeek learns a separate baseline for every hour of the week, for metrics that follows a weekly pattern
+	HourOfWeek bool
+	// Metrics limits the detection to these metrics, all metrics except the forecasts are checked when empty
+	Metrics []string
+	// Priority of the anomaly alerts
+	Priority string
+}
+
+// anomalyBaseline is the mean and standard deviation that a metric value is compared with
+type anomalyBaseline struct {
+	Mean    float64
+	StdDev  float64
+	Samples int
+}
+
+var anomalySettings = AnomalyConfig{
+	Sigma:      3,
+	WindowDays: 14,
+	WarmupDays: 2,
+	MinSamples: 10,
+	Priority:   P3,
+}
+
+// ConfigureAnomalies overrides the anomaly detection settings, zero values keep the defaults
+func ConfigureAnomalies(cfg AnomalyConfig) error {
+	if cfg.Sigma < 0 || cfg.WindowDays < 0 || cfg.WarmupDays < 0 || cfg.MinSamples < 0 {
+		return fmt.Errorf("anomaly settings can't be negative: %+v", cfg)
+	}
+	if !validPriority(cfg.Priority) {
+		return fmt.Errorf("anomaly detection has an unknown priority %q", cfg.Priority)
+	}
+	anomalySettings.Enabled = cfg.Enabled
+	anomalySettings.HourOfWeek = cfg.HourOfWeek
+	anomalySettings.Metrics = cfg.Metrics
+	if cfg.Sigma > 0 {
+		anomalySettings.Sigma = cfg.Sigma
+	}
+	if cfg.WindowDays > 0 {
+		anomalySettings.WindowDays = cfg.WindowDays
+	}
+	if cfg.WarmupDays > 0 {
+		anomalySettings.WarmupDays = cfg.WarmupDays
+	}
+	if cfg.MinSamples > 0 {
+		anomalySettings.MinSamples = cfg.MinSamples
+	}
+	if cfg.Priority != "" {
+		anomalySettings.Priority = cfg.Priority
+	}
+	if anomalySettings.WarmupDays > anomalySettings.WindowDays {
+		return fmt.Errorf("anomaly WarmupDays (%d) is longer than WindowDays (%d)", anomalySettings.WarmupDays, anomalySettings.WindowDays)
+	}
+	return nil
+}
+
+// AnomalyDetection returns true when the anomaly detection is enabled
+func AnomalyDetection() bool {
+	return anomalySettings.Enabled
+}
+
+// AnomalyWindowDays returns how many days of metric history the anomaly detection needs
+func AnomalyWindowDays() int {
+	return anomalySettings.WindowDays
+}
+
+// Anomalies compares the metrics with their baselines and returns an alert for each metric that deviates more than
+// Sigma standard deviations. It must be called before the metrics are recorded, so that the values aren't part of
+// their own baseline. The caller adds the resource details to the alerts and saves them. It also returns the metrics
+// that had a baseline and was checked, metrics that are warming up aren't checked.
+func Anomalies(db *storm.DB, kind, resourceID string, metrics map[string]*float64) ([]*Alert, map[string]bool, error) {
+	checked := make(map[string]bool)
+	if !anomalySettings.Enabled {
+		return nil, checked, nil
+	}
+	var alerts []*Alert
+	for name, value := range metrics {
+		if value == nil || !checkAnomaly(name) {
+			continue
+		}
+		baseline, err := baselineFor(db, kind, resourceID, name, time.Now())
+		if err != nil {
+			return nil, checked, err
+		}
+		if baseline == nil {
+			continue
+		}
+		checked[name] = true
+		deviation := (*value - baseline.Mean) / baseline.StdDev
+		if math.Abs(deviation) <= anomalySettings.Sigma {
+			continue
+		}
+		direction := "above"
+		if deviation < 0 {
+			direction = "below"
+		}
+		alert := NewAlert("Anomaly."+name, resourceID)
+		alert.Message = fmt.Sprintf("%s (%.2f) is %.1f standard deviations %s the baseline of %.2f", name, *value, math.Abs(deviation), direction, baseline.Mean)
+		alert.Priority = anomalySettings.Priority
+		alert.Details["metric"] = name
+		alert.Details["value"] = fmt.Sprintf("%f", *value)
+		alert.Details["baseline_mean"] = fmt.Sprintf("%f", baseline.Mean)
+		alert.Details["baseline_stddev"] = fmt.Sprintf("%f", baseline.StdDev)
+		alert.Details["baseline_samples"] = fmt.Sprintf("%d", baseline.Samples)
+		alerts = append(alerts, alert)
+	}
+	return alerts, checked, nil
+}
+
+// baselineFor learns the baseline for a metric from its history, it's nil while the metric is warming up
+func baselineFor(db *storm.DB, kind, resourceID, metric string, now time.Time) (*anomalyBaseline, error) {
+	samples, err := History(db, kind, resourceID, metric, now.AddDate(0, 0, -anomalySettings.WindowDays))
+	if err != nil {
+		return nil, err
+	}
+	warmup := anomalySettings.WarmupDays
+	if anomalySettings.HourOfWeek && warmup < 7 {
+		warmup = 7
+	}
+	if len(samples) == 0 || samples[0].Time.After(now.AddDate(0, 0, -warmup)) {
+		return nil, nil
+	}
+
+	var values []float64
+	for _, s := range samples {
+		if anomalySettings.HourOfWeek && hourOfWeek(s.Time) != hourOfWeek(now) {
+			continue
+		}
+		values = append(values, s.Value)
+	}
+	if len(values) < anomalySettings.MinSamples {
+		return nil, nil
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	stddev := math.Sqrt(squares / float64(len(values)))
+	// a metric that barely moves would otherwise alert on the smallest change, and one that has always been the same
+	// value would have a zero deviation, so the deviation is at least a tenth of the mean or one unit
+	if min := math.Abs(mean) / 10; stddev < min {
+		stddev = min
+	}
+	if stddev == 0 {
+		stddev = 1
+	}
+	return &anomalyBaseline{Mean: mean, StdDev: stddev, Samples: len(values)}, nil
+}
+
+func checkAnomaly(metric string) bool {
+	if len(anomalySettings.Metrics) == 0 {
+		// forecasts jumps around as the drain rate changes, that isn't an anomaly in itself
+		return !strings.HasSuffix(metric, HoursToZeroMetric(""))
+	}
+	for _, m := range anomalySettings.Metrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+func hourOfWeek(t time.Time) int {
+	t = t.UTC()
+	return int(t.Weekday())*24 + t.Hour()
+}
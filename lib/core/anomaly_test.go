@@ -0,0 +1,161 @@
+package core
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// setAnomalySettings enables the anomaly detection with the default settings for the test, changed by update
+func setAnomalySettings(t *testing.T, update func(cfg *AnomalyConfig)) {
+	t.Helper()
+	previous := anomalySettings
+	t.Cleanup(func() { anomalySettings = previous })
+	anomalySettings = AnomalyConfig{Enabled: true, Sigma: 3, WindowDays: 14, WarmupDays: 2, MinSamples: 10, Priority: P3}
+	if update != nil {
+		update(&anomalySettings)
+	}
+}
+
+// repeat returns n values that cycles through values
+func repeat(n int, values ...float64) []float64 {
+	result := make([]float64, n)
+	for i := range result {
+		result[i] = values[i%len(values)]
+	}
+	return result
+}
+
+func TestBaseline(t *testing.T) {
+	tests := []struct {
+		name       string
+		update     func(cfg *AnomalyConfig)
+		step       time.Duration
+		values     []float64
+		mean       float64
+		stddev     float64
+		noBaseline bool
+	}{
+		{"warming up", nil, time.Hour, repeat(24, 10, 20), 0, 0, true},
+		{"too few samples", nil, 12 * time.Hour, repeat(6, 10, 20), 0, 0, true},
+		{"enough samples", nil, 12 * time.Hour, repeat(10, 10, 20), 15, 5, false},
+		{"min samples configured", func(cfg *AnomalyConfig) { cfg.MinSamples = 20 }, 12 * time.Hour, repeat(10, 10, 20), 0, 0, true},
+		// a flat metric gets a tenth of its mean as the deviation
+		{"flat", nil, time.Hour, repeat(72, 100), 100, 10, false},
+		{"barely moving", nil, time.Hour, repeat(72, 99, 101), 100, 10, false},
+		{"negative mean", nil, time.Hour, repeat(72, -50), -50, 5, false},
+		// a metric that is always zero gets a deviation of one
+		{"always zero", nil, time.Hour, repeat(72, 0), 0, 1, false},
+		{"hour of week warming up", func(cfg *AnomalyConfig) { cfg.HourOfWeek = true }, time.Hour, repeat(24*5, 10), 0, 0, true},
+		// two weeks of hourly samples only has three samples from the current hour of the week
+		{"hour of week too few samples", func(cfg *AnomalyConfig) { cfg.HourOfWeek = true }, time.Hour, repeat(24*14+1, 10), 0, 0, true},
+		{"hour of week", func(cfg *AnomalyConfig) { cfg.HourOfWeek = true; cfg.MinSamples = 3 }, time.Hour, append(repeat(24*14, 10, 20), 10), 10, 1, false},
+	}
+	for _, test := range tests {
+		setAnomalySettings(t, test.update)
+		db := openDB(t)
+		now := time.Now()
+		saveSamples(t, db, "Instance", "i-1", "CPUUtilization", now, test.step, test.values...)
+
+		baseline, err := baselineFor(db, "Instance", "i-1", "CPUUtilization", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.noBaseline {
+			if baseline != nil {
+				t.Errorf("%s: expected no baseline, got %+v", test.name, baseline)
+			}
+			continue
+		}
+		if baseline == nil {
+			t.Errorf("%s: expected a baseline", test.name)
+			continue
+		}
+		if math.Abs(baseline.Mean-test.mean) > 1e-9 || math.Abs(baseline.StdDev-test.stddev) > 1e-9 {
+			t.Errorf("%s: expected mean %v and stddev %v, got %+v", test.name, test.mean, test.stddev, baseline)
+		}
+	}
+}
+
+func TestAnomalies(t *testing.T) {
+	setAnomalySettings(t, nil)
+	db := openDB(t)
+	now := time.Now()
+	// CPU has a baseline of 50 ± 10, credits are warming up and the forecast is never checked
+	saveSamples(t, db, "Instance", "i-1", "CPUUtilization", now.Add(-time.Minute), time.Hour, repeat(72, 40, 60)...)
+	saveSamples(t, db, "Instance", "i-1", "CPUCreditBalance", now.Add(-time.Minute), time.Hour, repeat(24, 100)...)
+	saveSamples(t, db, "Instance", "i-1", HoursToZeroMetric("CPUCreditBalance"), now.Add(-time.Minute), time.Hour, repeat(72, 5)...)
+
+	tests := []struct {
+		cpu   float64
+		alert string
+	}{
+		{50, ""},
+		{80, ""},
+		{81, "above"},
+		{19, "below"},
+	}
+	for _, test := range tests {
+		metrics := map[string]*float64{
+			"CPUUtilization":                      aws.Float64(test.cpu),
+			"CPUCreditBalance":                    aws.Float64(0),
+			HoursToZeroMetric("CPUCreditBalance"): aws.Float64(500),
+			"NetworkIn":                           nil,
+		}
+		alerts, checked, err := Anomalies(db, "Instance", "i-1", metrics)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(checked) != 1 || !checked["CPUUtilization"] {
+			t.Errorf("expected only CPUUtilization to be checked, got %v", checked)
+		}
+		if test.alert == "" {
+			if len(alerts) != 0 {
+				t.Errorf("CPU %.0f: expected no alerts, got %+v", test.cpu, alerts[0])
+			}
+			continue
+		}
+		if len(alerts) != 1 {
+			t.Errorf("CPU %.0f: expected one alert, got %d", test.cpu, len(alerts))
+			continue
+		}
+		alert := alerts[0]
+		if alert.ID != "aunt.Anomaly.CPUUtilization.i-1" || alert.Priority != P3 || alert.Details["metric"] != "CPUUtilization" {
+			t.Errorf("CPU %.0f: unexpected alert %+v", test.cpu, alert)
+		}
+		if want := "standard deviations " + test.alert + " the baseline of 50.00"; !strings.Contains(alert.Message, want) {
+			t.Errorf("CPU %.0f: expected the message to contain %q, got %q", test.cpu, want, alert.Message)
+		}
+	}
+}
+
+func TestAnomaliesDisabled(t *testing.T) {
+	setAnomalySettings(t, func(cfg *AnomalyConfig) { cfg.Enabled = false })
+	db := openDB(t)
+	saveSamples(t, db, "Instance", "i-1", "CPUUtilization", time.Now().Add(-time.Minute), time.Hour, repeat(72, 50)...)
+	alerts, checked, err := Anomalies(db, "Instance", "i-1", map[string]*float64{"CPUUtilization": aws.Float64(1000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 0 || len(checked) != 0 {
+		t.Errorf("expected nothing to be checked when disabled, got %v %v", alerts, checked)
+	}
+}
+
+func TestAnomaliesMetrics(t *testing.T) {
+	setAnomalySettings(t, func(cfg *AnomalyConfig) { cfg.Metrics = []string{"NetworkIn"} })
+	db := openDB(t)
+	saveSamples(t, db, "Instance", "i-1", "CPUUtilization", time.Now().Add(-time.Minute), time.Hour, repeat(72, 50)...)
+	saveSamples(t, db, "Instance", "i-1", "NetworkIn", time.Now().Add(-time.Minute), time.Hour, repeat(72, 50)...)
+	metrics := map[string]*float64{"CPUUtilization": aws.Float64(1000), "NetworkIn": aws.Float64(50)}
+	alerts, checked, err := Anomalies(db, "Instance", "i-1", metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 0 || len(checked) != 1 || !checked["NetworkIn"] {
+		t.Errorf("expected only NetworkIn to be checked, got %v %v", alerts, checked)
+	}
+}
@@ -1,12 +1,21 @@
 package core
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
+// MaxResourceAge is how old a stored resource can be before it's ignored, every collector updates its resources more
+// often than this so older records are for resources that have been removed
+const MaxResourceAge = time.Hour
+
 // NewCredentials returns a AWS session and and aws.Config ready for use when setting up a new aws service
 func NewCredentials(region, roleARN string) (*session.Session, *aws.Config) {
 	regionPtr := aws.String(region)
@@ -15,6 +24,38 @@ func NewCredentials(region, roleARN string) (*session.Session, *aws.Config) {
 	return sess, config
 }
 
+// UpdateAccounts calls update for every account and role in parallel and returns when all of them are done
+func UpdateAccounts(roles map[string]string, update func(account, role string)) {
+	var wg sync.WaitGroup
+	wg.Add(len(roles))
+	for account, role := range roles {
+		go func(account, role string) {
+			update(account, role)
+			wg.Done()
+		}(account, role)
+	}
+	wg.Wait()
+}
+
+// ResourceAlert returns a new alert for a resource with the account, region and resource id details and the tags of the
+// resource
+func ResourceAlert(name, resourceID, account, region string, tags map[string]string) *Alert {
+	alert := NewAlert(name, resourceID)
+	alert.Details["account"] = account
+	alert.Details["region"] = region
+	alert.Details["resource_id"] = resourceID
+	alert.ResourceTags = tags
+	return alert
+}
+
+// SaveAlert saves the alert and prints the error if it can't be saved, so that a collector can carry on with the next
+// resource
+func SaveAlert(db *storm.DB, alert *Alert) {
+	if err := alert.Save(db); err != nil {
+		fmt.Printf("%+v\n", err)
+	}
+}
+
 // TagValue returns the value of a tag with the name in key from a list of EG2 tags
 func TagValue(key string, tags []*ec2.Tag) string {
 	for _, tag := range tags {
@@ -24,3 +65,12 @@ func TagValue(key string, tags []*ec2.Tag) string {
 	}
 	return ""
 }
+
+// Tags returns a list of EC2 tags as a map of key to value
+func Tags(tags []*ec2.Tag) map[string]string {
+	result := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		result[*tag.Key] = *tag.Value
+	}
+	return result
+}
@@ -0,0 +1,223 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/opsgenie/opsgenie-go-sdk/alertsv2"
+)
+
+// AlertExpiry is how long an alert stays open after it was last raised, alerts that hasn't been raised again within
+// this time are purged and closed
+const AlertExpiry = 15 * time.Minute
+
+// Relation links a resource to the resource that it belongs to, e.g. a volume to the instance it's attached to
+type Relation struct {
+	ResourceID  string `storm:"id"`
+	Kind        string
+	Parent      string `storm:"index"`
+	ParentKind  string
+	LastUpdated time.Time `storm:"index"`
+}
+
+// Actions for alerts on a child resource when the parent resource is alerting
+const (
+	// ActionSuppress records the child alert without notifying it
+	ActionSuppress = "suppress"
+	// ActionGroup lists the child alert on the parent alert instead of notifying it on its own
+	ActionGroup = "group"
+)
+
+// CorrelationRule decides what happens to alerts on a child resource while one of its parent resources is alerting,
+// e.g. {"Parent": "NumScalingEvents", "Child": "*", "Action": "group"}
+type CorrelationRule struct {
+	// Parent is the name of the alert on the parent, a trailing * matches all alerts with that prefix
+	Parent string
+	// Child is the name of the alert on the child, a trailing * matches all alerts with that prefix
+	Child string
+	// Action is suppress or group
+	Action string
+}
+
+// AlertGroup holds the alerts that has been grouped under a parent alert the last time the group was notified
+type AlertGroup struct {
+	// ID is the ID of the parent alert
+	ID          string `storm:"id"`
+	Alerts      []string
+	LastUpdated time.Time
+}
+
+// maxRelationDepth stops the walk up the relations if they would ever form a loop
+const maxRelationDepth = 10
+
+var correlationRules []CorrelationRule
+
+// ConfigureCorrelation sets the rules for suppressing and grouping alerts on child resources
+func ConfigureCorrelation(rules []CorrelationRule) error {
+	for _, rule := range rules {
+		if rule.Parent == "" || rule.Child == "" {
+			return fmt.Errorf("correlation rule needs a Parent and a Child alert name: %+v", rule)
+		}
+		if rule.Action != ActionSuppress && rule.Action != ActionGroup {
+			return fmt.Errorf("correlation rule has an unknown action %q, it should be %s or %s", rule.Action, ActionSuppress, ActionGroup)
+		}
+	}
+	correlationRules = rules
+	return nil
+}
+
+// Parents returns the resources that a resource belongs to, the closest parent first
+func Parents(db *storm.DB, resourceID string) ([]Relation, error) {
+	var parents []Relation
+	for i := 0; i < maxRelationDepth; i++ {
+		var relation Relation
+		if err := db.One("ResourceID", resourceID, &relation); err != nil {
+			if err == storm.ErrNotFound {
+				break
+			}
+			return nil, err
+		}
+		parents = append(parents, relation)
+		resourceID = relation.Parent
+	}
+	return parents, nil
+}
+
+// correlatePending suppresses or groups the pending alerts under the alerts on their parent resources, the rest are
+// sent in the digest, left waiting to be grouped or notified
+func correlatePending(db *storm.DB, since time.Time) error {
+	if len(correlationRules) == 0 {
+		return nil
+	}
+	var pending []Alert
+	if err := db.Select(q.Eq("Pending", true)).Find(&pending); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for i := range pending {
+		alert := &pending[i]
+		parent, action, err := correlate(db, alert, since)
+		if err != nil {
+			fmt.Printf("error during alert correlation: %v\n", err)
+		}
+		alert.Pending = false
+		if parent != nil {
+			alert.Parent = parent.ID
+			alert.Grouped = action == ActionGroup
+			if alert.Grouped {
+				alert.Silenced = fmt.Sprintf("grouped under %s", parent.ID)
+			} else {
+				alert.Silenced = fmt.Sprintf("suppressed by %s", parent.ID)
+			}
+			fmt.Printf("Silenced (%s): %s\n", alert.Silenced, alert)
+		} else {
+			alert.route()
+		}
+		if err := alert.send(); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+		if err := db.Save(alert); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+	}
+	return nil
+}
+
+// correlate finds the furthest parent alert raised since the update started that the alert should be suppressed by or
+// grouped under, it returns nil when the alert should be notified on its own
+func correlate(db *storm.DB, alert *Alert, since time.Time) (*Alert, string, error) {
+	if len(correlationRules) == 0 {
+		return nil, "", nil
+	}
+	parents, err := Parents(db, alert.Entity)
+	if err != nil {
+		return nil, "", err
+	}
+	var match *Alert
+	var action string
+	for _, relation := range parents {
+		var candidates []Alert
+		query := db.Select(q.Eq("Entity", relation.Parent), q.Gte("LastUpdated", since))
+		if err := query.Find(&candidates); err != nil && err != storm.ErrNotFound {
+			return nil, "", err
+		}
+		for i := range candidates {
+			for _, rule := range correlationRules {
+				if nameMatches(rule.Parent, candidates[i].Name) && nameMatches(rule.Child, alert.Name) {
+					match, action = &candidates[i], rule.Action
+					break
+				}
+			}
+		}
+	}
+	return match, action, nil
+}
+
+// notifyParentGroups adds a note to each parent alert when the alerts grouped under it during the update has changed
+func notifyParentGroups(db *storm.DB, since time.Time) error {
+	var grouped []Alert
+	query := db.Select(q.Eq("Grouped", true), q.Gte("LastUpdated", since))
+	if err := query.Find(&grouped); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	children := make(map[string][]Alert)
+	for _, alert := range grouped {
+		children[alert.Parent] = append(children[alert.Parent], alert)
+	}
+
+	var groups []AlertGroup
+	if err := db.All(&groups); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for i := range groups {
+		if _, ok := children[groups[i].ID]; !ok {
+			if err := db.DeleteStruct(&groups[i]); err != nil {
+				fmt.Printf("alert group purge error: %v %s\n", err, groups[i].ID)
+			}
+		}
+	}
+
+	for parent, alerts := range children {
+		sort.Slice(alerts, func(i, j int) bool { return alerts[i].ID < alerts[j].ID })
+		group := &AlertGroup{ID: parent, LastUpdated: time.Now()}
+		var lines []string
+		for _, alert := range alerts {
+			group.Alerts = append(group.Alerts, alert.ID)
+			lines = append(lines, fmt.Sprintf("- %s (%s)", alert.Message, alert.Entity))
+		}
+		var previous AlertGroup
+		if err := db.One("ID", parent, &previous); err != nil && err != storm.ErrNotFound {
+			return err
+		}
+		if strings.Join(previous.Alerts, ",") == strings.Join(group.Alerts, ",") {
+			continue
+		}
+		if err := db.Save(group); err != nil {
+			return err
+		}
+		note := fmt.Sprintf("%d alerts on related resources:\n%s", len(alerts), strings.Join(lines, "\n"))
+		fmt.Printf("Grouped under %s: %s\n", parent, note)
+		if alertCli == nil {
+			continue
+		}
+		_, err := alertCli.AddNote(alertsv2.AddNoteRequest{
+			Identifier: &alertsv2.Identifier{Alias: parent},
+			Note:       note,
+		})
+		if err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+	}
+	return nil
+}
+
+// nameMatches returns true if the name is the same as the pattern or has the prefix of a pattern with a trailing *
+func nameMatches(pattern, name string) bool {
+	if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+		return strings.HasPrefix(name, prefix)
+	}
+	return pattern == name
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotifyCorrelation(t *testing.T) {
+	previous := correlationRules
+	t.Cleanup(func() { correlationRules = previous })
+	err := ConfigureCorrelation([]CorrelationRule{
+		{Parent: "StatusCheckFailed", Child: "BurstBalance", Action: ActionSuppress},
+		{Parent: "NumScalingEvents", Child: "*", Action: ActionGroup},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// parent is the alert on the parent resource, it's raised before the update started when stale is true
+		parent   string
+		stale    bool
+		child    string
+		silenced string
+		grouped  bool
+	}{
+		{"suppressed", "StatusCheckFailed", false, "BurstBalance", "suppressed by aunt.StatusCheckFailed.i-1", false},
+		{"grouped", "NumScalingEvents", false, "BurstBalance", "grouped under aunt.NumScalingEvents.asg-1", true},
+		{"no rule", "StatusCheckFailed", false, "VolumeQueueLength", "", false},
+		{"cleared parent", "StatusCheckFailed", true, "BurstBalance", "", false},
+		{"no parent alert", "", false, "BurstBalance", "", false},
+	}
+	for _, test := range tests {
+		db := openDB(t)
+		since := time.Now()
+		parentID := "i-1"
+		if test.parent == "NumScalingEvents" {
+			parentID = "asg-1"
+		}
+		if test.parent != "" {
+			parent := NewAlert(test.parent, parentID)
+			if test.stale {
+				parent.LastUpdated = since.Add(-time.Minute)
+			}
+			if err := parent.Save(db); err != nil {
+				t.Fatal(err)
+			}
+		}
+		child := NewAlert(test.child, "vol-1")
+		if err := child.Save(db); err != nil {
+			t.Fatal(err)
+		}
+		// the relations are rebuilt after the alerts are raised
+		for _, relation := range []*Relation{
+			{ResourceID: "vol-1", Kind: "Volume", Parent: "i-1", ParentKind: "Instance", LastUpdated: time.Now()},
+			{ResourceID: "i-1", Kind: "Instance", Parent: "asg-1", ParentKind: "AutoScalingGroup", LastUpdated: time.Now()},
+		} {
+			if err := db.Save(relation); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := Notify(db, since); err != nil {
+			t.Fatal(err)
+		}
+
+		var alert Alert
+		if err := db.One("ID", child.ID, &alert); err != nil {
+			t.Fatal(err)
+		}
+		if alert.Silenced != test.silenced || alert.Grouped != test.grouped || alert.Pending {
+			t.Errorf("%s: unexpected alert %+v", test.name, alert)
+		}
+		if notified := test.silenced == ""; alert.Notified != notified {
+			t.Errorf("%s: expected notified to be %v, got %v", test.name, notified, alert.Notified)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// ForecastRule raises an alert when a draining metric is forecast to reach zero within a number of hours
+type ForecastRule struct {
+	Metric      string
+	WithinHours float64
+	// Priority of the alert, P2 when not set
+	Priority string
+}
+
+// ForecastConfig holds the forecast window and the rules for alerting on forecasts
+type ForecastConfig struct {
+	// WindowHours is how many hours of history the drain rate is fitted over
+	WindowHours float64
+	Rules       []ForecastRule
+}
+
+// forecastMinHistory is how much history that is needed before a forecast is made, a few samples close together
+// gives a very noisy drain rate
+const forecastMinHistory = 30 * time.Minute
+
+var forecastSettings = ForecastConfig{
+	WindowHours: 3,
+	Rules: []ForecastRule{
+		{Metric: "CPUCreditBalance", WithinHours: 2, Priority: P2},
+		{Metric: "BurstBalance", WithinHours: 2, Priority: P2},
+	},
+}
+
+// ConfigureForecast overrides the forecast window and the rules, rules replaces the default rules when set
+func ConfigureForecast(cfg ForecastConfig) error {
+	if cfg.WindowHours < 0 {
+		return fmt.Errorf("forecast window can't be negative: %.1f", cfg.WindowHours)
+	}
+	for _, rule := range cfg.Rules {
+		if rule.Metric == "" || rule.WithinHours <= 0 {
+			return fmt.Errorf("forecast rule needs a metric and WithinHours above zero: %+v", rule)
+		}
+		if !validPriority(rule.Priority) {
+			return fmt.Errorf("forecast rule for %s has an unknown priority %q", rule.Metric, rule.Priority)
+		}
+	}
+	if cfg.WindowHours > 0 {
+		forecastSettings.WindowHours = cfg.WindowHours
+	}
+	if len(cfg.Rules) > 0 {
+		forecastSettings.Rules = cfg.Rules
+	}
+	return nil
+}
+
+// HoursToZeroMetric returns the name of the derived metric that holds the forecast for a metric
+func HoursToZeroMetric(metric string) string {
+	return metric + "HoursToZero"
+}
+
+// Forecast fits the drain rate of a metric from its recent history and the current value and sets the estimated hours
+// until it reaches zero as a derived metric. Nothing is set if the metric isn't draining. It should be called before
+// the metrics are recorded.
+func Forecast(db *storm.DB, kind, resourceID, metric string, metrics map[string]*float64) error {
+	current := metrics[metric]
+	if current == nil {
+		return nil
+	}
+	window := time.Duration(forecastSettings.WindowHours * float64(time.Hour))
+	samples, err := History(db, kind, resourceID, metric, time.Now().Add(-window))
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 || time.Since(samples[0].Time) < forecastMinHistory {
+		return nil
+	}
+	samples = append(samples, Sample{Time: time.Now(), Value: *current})
+	slope, ok := Slope(samples)
+	if !ok || slope >= 0 {
+		return nil
+	}
+	metrics[HoursToZeroMetric(metric)] = aws.Float64(*current / -slope / 3600)
+	return nil
+}
+
+// ForecastAlerts returns an alert for each forecast rule where the metric will reach zero within the rule's hours. The
+// caller adds the resource details to the alerts and saves them.
+func ForecastAlerts(resourceID string, metrics map[string]*float64) []*Alert {
+	var alerts []*Alert
+	for _, rule := range forecastSettings.Rules {
+		hours := metrics[HoursToZeroMetric(rule.Metric)]
+		if hours == nil || *hours > rule.WithinHours {
+			continue
+		}
+		alert := NewAlert(rule.Metric+"Exhausted", resourceID)
+		alert.Message = fmt.Sprintf("%s will be exhausted in %.1f hours", rule.Metric, *hours)
+		if rule.Priority != "" {
+			alert.Priority = rule.Priority
+		}
+		alert.Details["hours_to_zero"] = fmt.Sprintf("%.1f", *hours)
+		if current := metrics[rule.Metric]; current != nil {
+			alert.Details["current"] = fmt.Sprintf("%.1f", *current)
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+func validPriority(priority string) bool {
+	switch priority {
+	case "", P1, P2, P3, P4, P5:
+		return true
+	}
+	return false
+}
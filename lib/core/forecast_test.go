@@ -0,0 +1,147 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// openDB opens an empty database in a temporary directory that is removed after the test
+func openDB(t *testing.T) *storm.DB {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "aunt-core")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	db, err := storm.Open(filepath.Join(dir, "aunt.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// saveSamples stores the values as the history of a metric, the first value is the oldest and each value is step
+// after the one before, the last one at end
+func saveSamples(t *testing.T, db *storm.DB, kind, resourceID, metric string, end time.Time, step time.Duration, values ...float64) {
+	t.Helper()
+	series := SeriesName(kind, resourceID, metric)
+	for i, value := range values {
+		at := end.Add(-time.Duration(len(values)-1-i) * step)
+		sample := &Sample{ID: fmt.Sprintf("%s@%d", series, at.UnixNano()), Series: series, Time: at, Value: value}
+		if err := db.Save(sample); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSlope(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		samples []Sample
+		slope   float64
+		ok      bool
+	}{
+		{"no samples", nil, 0, false},
+		{"one sample", []Sample{{Time: now, Value: 1}}, 0, false},
+		{"same time", []Sample{{Time: now, Value: 1}, {Time: now, Value: 2}}, 0, false},
+		{"flat", []Sample{{Time: now, Value: 5}, {Time: now.Add(time.Second), Value: 5}, {Time: now.Add(2 * time.Second), Value: 5}}, 0, true},
+		{"rising", []Sample{{Time: now, Value: 0}, {Time: now.Add(10 * time.Second), Value: 20}}, 2, true},
+		{"falling", []Sample{{Time: now, Value: 10}, {Time: now.Add(time.Second), Value: 9}, {Time: now.Add(2 * time.Second), Value: 8}}, -1, true},
+		// least squares through (0,0) (1,2) (2,1) (3,3) is 0.8
+		{"noisy", []Sample{{Time: now, Value: 0}, {Time: now.Add(time.Second), Value: 2}, {Time: now.Add(2 * time.Second), Value: 1}, {Time: now.Add(3 * time.Second), Value: 3}}, 0.8, true},
+	}
+	for _, test := range tests {
+		slope, ok := Slope(test.samples)
+		if ok != test.ok || math.Abs(slope-test.slope) > 1e-9 {
+			t.Errorf("%s: Slope() = %v, %v, expected %v, %v", test.name, slope, ok, test.slope, test.ok)
+		}
+	}
+}
+
+func TestForecast(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    time.Duration
+		history []float64
+		current float64
+		// hours is the expected hours to zero, it's not set when it's negative
+		hours float64
+	}{
+		{"flat", 15 * time.Minute, []float64{50, 50, 50, 50, 50}, 50, -1},
+		{"rising", 15 * time.Minute, []float64{10, 20, 30, 40, 50}, 60, -1},
+		// draining 10 per 15 minutes is 40 an hour, so 60 lasts for 1.5 hours
+		{"falling", 15 * time.Minute, []float64{100, 90, 80, 70}, 60, 1.5},
+		{"too little history", 5 * time.Minute, []float64{100, 90, 80, 70}, 60, -1},
+		{"no history", time.Minute, nil, 60, -1},
+	}
+	for _, test := range tests {
+		db := openDB(t)
+		now := time.Now()
+		if len(test.history) > 0 {
+			// the last sample in the history is one step before the current value
+			saveSamples(t, db, "Instance", "i-1", "CPUCreditBalance", now.Add(-test.step), test.step, test.history...)
+		}
+		metrics := map[string]*float64{"CPUCreditBalance": aws.Float64(test.current)}
+		if err := Forecast(db, "Instance", "i-1", "CPUCreditBalance", metrics); err != nil {
+			t.Fatal(err)
+		}
+		hours := metrics[HoursToZeroMetric("CPUCreditBalance")]
+		if test.hours < 0 {
+			if hours != nil {
+				t.Errorf("%s: expected no forecast, got %.2f hours", test.name, *hours)
+			}
+			continue
+		}
+		if hours == nil || math.Abs(*hours-test.hours) > 0.01 {
+			t.Errorf("%s: expected %.2f hours to zero, got %v", test.name, test.hours, hours)
+		}
+	}
+}
+
+func TestForecastWithoutCurrentValue(t *testing.T) {
+	db := openDB(t)
+	saveSamples(t, db, "Volume", "vol-1", "BurstBalance", time.Now(), 15*time.Minute, 100, 90, 80, 70)
+	metrics := map[string]*float64{"BurstBalance": nil}
+	if err := Forecast(db, "Volume", "vol-1", "BurstBalance", metrics); err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 {
+		t.Errorf("expected no forecast without a current value, got %v", metrics)
+	}
+}
+
+func TestForecastAlerts(t *testing.T) {
+	tests := []struct {
+		hours  *float64
+		alerts int
+	}{
+		{nil, 0},
+		{aws.Float64(5), 0},
+		{aws.Float64(2), 1},
+		{aws.Float64(0.5), 1},
+	}
+	for _, test := range tests {
+		metrics := map[string]*float64{
+			"CPUCreditBalance":                    aws.Float64(10),
+			HoursToZeroMetric("CPUCreditBalance"): test.hours,
+		}
+		alerts := ForecastAlerts("i-1", metrics)
+		if len(alerts) != test.alerts {
+			t.Errorf("expected %d alerts for %v hours, got %d", test.alerts, test.hours, len(alerts))
+			continue
+		}
+		if len(alerts) == 1 && (alerts[0].ID != "aunt.CPUCreditBalanceExhausted.i-1" || alerts[0].Priority != P2) {
+			t.Errorf("unexpected alert %+v", alerts[0])
+		}
+	}
+}
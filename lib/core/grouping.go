@@ -0,0 +1,203 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+// GroupingConfig groups alerts that are raised in the same update and share the same keys into one notification
+type GroupingConfig struct {
+	// By are the keys that alerts are grouped by, any of account, region and name
+	By []string
+	// MinAlerts is how many alerts that must share the keys before they are grouped, 3 when not set
+	MinAlerts int
+}
+
+// Keys that alerts can be grouped by
+const (
+	GroupByAccount = "account"
+	GroupByRegion  = "region"
+	GroupByName    = "name"
+)
+
+var grouping = GroupingConfig{MinAlerts: 3}
+
+// digestPriorities are the priorities of the alerts that are sent in the digest instead of being notified
+var digestPriorities = make(map[string]bool)
+
+// ConfigureGrouping sets the keys that alerts are grouped by, alerts are notified one by one when there are no keys
+func ConfigureGrouping(cfg GroupingConfig) error {
+	for _, key := range cfg.By {
+		if key != GroupByAccount && key != GroupByRegion && key != GroupByName {
+			return fmt.Errorf("alerts can't be grouped by %q, use %s, %s or %s", key, GroupByAccount, GroupByRegion, GroupByName)
+		}
+	}
+	if cfg.MinAlerts < 0 {
+		return fmt.Errorf("grouping MinAlerts can't be negative: %d", cfg.MinAlerts)
+	}
+	grouping.By = cfg.By
+	if cfg.MinAlerts > 0 {
+		grouping.MinAlerts = cfg.MinAlerts
+	}
+	return nil
+}
+
+// SetDigestPriorities sets the priorities of the alerts that are sent in a digest instead of being notified
+func SetDigestPriorities(priorities []string) error {
+	digested := make(map[string]bool)
+	for _, p := range priorities {
+		if p == "" || !validPriority(p) {
+			return fmt.Errorf("unknown digest priority %q", p)
+		}
+		digested[p] = true
+	}
+	digestPriorities = digested
+	return nil
+}
+
+// Notify correlates the alerts raised since the update started with the alerts on their parent resources and sends the
+// alerts that are waiting to be grouped, alerts that shares the grouping keys with at least MinAlerts other alerts are
+// sent as one alert that lists them. It also adds notes to the parent alerts that has child alerts grouped under them.
+// It should run after all alerts has been raised and the relations has been rebuilt.
+func Notify(db *storm.DB, since time.Time) error {
+	if err := correlatePending(db, since); err != nil {
+		return err
+	}
+	if err := notifyPending(db); err != nil {
+		return err
+	}
+	return notifyParentGroups(db, since)
+}
+
+func notifyPending(db *storm.DB) error {
+	var pending []Alert
+	if err := db.Select(q.Eq("Pending", true)).Find(&pending); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	groups := make(map[string][]Alert)
+	for _, alert := range pending {
+		key := groupKey(&alert)
+		groups[key] = append(groups[key], alert)
+	}
+
+	var keys []string
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		alerts := groups[key]
+		if len(alerts) >= grouping.MinAlerts {
+			if err := saveGroup(db, groupAlert(key, alerts)); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+		}
+		for i := range alerts {
+			alert := &alerts[i]
+			alert.Pending = false
+			if len(alerts) >= grouping.MinAlerts {
+				alert.Silenced = fmt.Sprintf("grouped in %s", groupAlertID(key))
+				// the group alert lists it now, so it's no longer open on its own
+				if alert.Notified {
+					if err := alert.close(); err != nil {
+						fmt.Printf("%+v\n", err)
+					}
+				}
+			} else if err := alert.notify(); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			if err := db.Save(alert); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+		}
+	}
+	return nil
+}
+
+// groupAlert returns one alert that lists all the alerts in the group, it has the highest priority of the alerts
+func groupAlert(key string, alerts []Alert) *Alert {
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].ID < alerts[j].ID })
+	first := alerts[0]
+	group := &Alert{
+		ID:          groupAlertID(key),
+		Name:        "Group",
+		Entity:      key,
+		Details:     make(map[string]string),
+		Priority:    first.Priority,
+		LastUpdated: time.Now(),
+	}
+	// e.g. 12 CPUCreditBalance alerts in account 123456789012 in us-east-1
+	message := fmt.Sprintf("%d alerts", len(alerts))
+	for _, by := range grouping.By {
+		value := groupValue(&first, by)
+		group.Details[by] = value
+		if by == GroupByName {
+			message = fmt.Sprintf("%d %s alerts", len(alerts), value)
+		}
+	}
+	for _, by := range grouping.By {
+		switch by {
+		case GroupByAccount:
+			message += " in account " + group.Details[by]
+		case GroupByRegion:
+			message += " in " + group.Details[by]
+		}
+	}
+	group.Message = message
+	group.Details["alerts"] = fmt.Sprintf("%d", len(alerts))
+	var lines []string
+	var teams []string
+	for _, alert := range alerts {
+		if alert.Priority < group.Priority {
+			group.Priority = alert.Priority
+		}
+		group.Tags = append(group.Tags, alert.Tags...)
+		teams = append(teams, alert.Teams()...)
+		lines = append(lines, fmt.Sprintf("- %s (%s)", alert.Message, alert.Entity))
+	}
+	group.Description = strings.Join(lines, "\n")
+	// every team that owns one of the alerts is notified about the group
+	group.Tags = unique(group.Tags)
+	teams = unique(teams)
+	if len(teams) > 0 {
+		group.Team, group.Responders = teams[0], teams[1:]
+	}
+	return group
+}
+
+// saveGroup sends the group alert and stores it, the group is closed by the purge when it's no longer raised
+func saveGroup(db *storm.DB, group *Alert) error {
+	fmt.Printf("Creating group: %s\n", group)
+	notifyErr := group.notify()
+	if err := db.Save(group); err != nil {
+		return err
+	}
+	return notifyErr
+}
+
+func groupKey(alert *Alert) string {
+	var parts []string
+	for _, by := range grouping.By {
+		parts = append(parts, by+"="+groupValue(alert, by))
+	}
+	return strings.Join(parts, ",")
+}
+
+func groupAlertID(key string) string {
+	return "aunt.Group." + key
+}
+
+func groupValue(alert *Alert, by string) string {
+	switch by {
+	case GroupByAccount:
+		return alert.Details["account"]
+	case GroupByRegion:
+		return alert.Details["region"]
+	}
+	return alert.Name
+}
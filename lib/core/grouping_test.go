@@ -0,0 +1,145 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+// groupedAlert returns an alert in the account and region that is owned by the team
+func groupedAlert(name, resourceID, account, region, priority, team string) Alert {
+	alert := NewAlert(name, resourceID)
+	alert.Message = name + " is low"
+	alert.Details["account"] = account
+	alert.Details["region"] = region
+	alert.Priority = priority
+	alert.Team = team
+	return *alert
+}
+
+func setGrouping(t *testing.T, by ...string) {
+	t.Helper()
+	previous := grouping
+	t.Cleanup(func() { grouping = previous })
+	grouping = GroupingConfig{By: by, MinAlerts: 3}
+}
+
+func TestGroupKey(t *testing.T) {
+	alert := groupedAlert("CPUCreditBalance", "i-1", "prod", "us-east-1", P2, "web")
+	tests := []struct {
+		by  []string
+		key string
+	}{
+		{nil, ""},
+		{[]string{GroupByAccount}, "account=prod"},
+		{[]string{GroupByRegion}, "region=us-east-1"},
+		{[]string{GroupByName}, "name=CPUCreditBalance"},
+		{[]string{GroupByName, GroupByAccount}, "name=CPUCreditBalance,account=prod"},
+		{[]string{GroupByAccount, GroupByRegion, GroupByName}, "account=prod,region=us-east-1,name=CPUCreditBalance"},
+	}
+	for _, test := range tests {
+		setGrouping(t, test.by...)
+		if key := groupKey(&alert); key != test.key {
+			t.Errorf("groupKey() by %v = %q, expected %q", test.by, key, test.key)
+		}
+	}
+}
+
+func TestGroupAlert(t *testing.T) {
+	alerts := func() []Alert {
+		return []Alert{
+			groupedAlert("CPUCreditBalance", "i-3", "prod", "us-east-1", P3, "web"),
+			groupedAlert("CPUCreditBalance", "i-1", "prod", "us-east-1", P2, "ops"),
+			groupedAlert("CPUCreditBalance", "i-2", "prod", "us-east-1", P4, "web"),
+		}
+	}
+	tests := []struct {
+		by      []string
+		id      string
+		message string
+	}{
+		{[]string{GroupByAccount}, "aunt.Group.account=prod", "3 alerts in account prod"},
+		{[]string{GroupByRegion}, "aunt.Group.region=us-east-1", "3 alerts in us-east-1"},
+		{[]string{GroupByName}, "aunt.Group.name=CPUCreditBalance", "3 CPUCreditBalance alerts"},
+		{[]string{GroupByRegion, GroupByAccount}, "aunt.Group.region=us-east-1,account=prod", "3 alerts in us-east-1 in account prod"},
+		{
+			[]string{GroupByName, GroupByAccount, GroupByRegion},
+			"aunt.Group.name=CPUCreditBalance,account=prod,region=us-east-1",
+			"3 CPUCreditBalance alerts in account prod in us-east-1",
+		},
+	}
+	for _, test := range tests {
+		setGrouping(t, test.by...)
+		key := groupKey(&alerts()[0])
+		group := groupAlert(key, alerts())
+		if group.ID != test.id || group.Message != test.message {
+			t.Errorf("groupAlert() by %v = %q %q, expected %q %q", test.by, group.ID, group.Message, test.id, test.message)
+		}
+		if group.Details["alerts"] != "3" {
+			t.Errorf("by %v: expected 3 alerts in the details, got %v", test.by, group.Details)
+		}
+		for _, by := range test.by {
+			if group.Details[by] == "" {
+				t.Errorf("by %v: expected %s in the details, got %v", test.by, by, group.Details)
+			}
+		}
+	}
+
+	// the group has the highest priority, lists the alerts by ID and notifies every team that owns one of them
+	setGrouping(t, GroupByName)
+	group := groupAlert("name=CPUCreditBalance", alerts())
+	if group.Priority != P2 {
+		t.Errorf("expected the group to have priority %s, got %s", P2, group.Priority)
+	}
+	description := "- CPUCreditBalance is low (i-1)\n- CPUCreditBalance is low (i-2)\n- CPUCreditBalance is low (i-3)"
+	if group.Description != description {
+		t.Errorf("expected the description %q, got %q", description, group.Description)
+	}
+	if group.Team != "ops" || !reflect.DeepEqual(group.Responders, []string{"web"}) {
+		t.Errorf("expected team ops and responders [web], got %q %v", group.Team, group.Responders)
+	}
+}
+
+func TestNotifyPending(t *testing.T) {
+	setGrouping(t, GroupByName)
+	db := openDB(t)
+	for _, id := range []string{"i-1", "i-2", "i-3"} {
+		alert := groupedAlert("CPUCreditBalance", id, "prod", "us-east-1", P2, "web")
+		if err := alert.Save(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alone := groupedAlert("StatusCheckFailed", "i-1", "prod", "us-east-1", P2, "web")
+	if err := alone.Save(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifyPending(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var group Alert
+	if err := db.One("ID", "aunt.Group.name=CPUCreditBalance", &group); err != nil {
+		t.Fatal(err)
+	}
+	if !group.Notified {
+		t.Error("expected the group alert to be notified")
+	}
+	var alerts []Alert
+	if err := db.All(&alerts); err != nil {
+		t.Fatal(err)
+	}
+	for _, alert := range alerts {
+		if alert.Pending {
+			t.Errorf("%s: expected the alert to be sent", alert.ID)
+		}
+		switch alert.Name {
+		case "CPUCreditBalance":
+			if alert.Notified || alert.Silenced != "grouped in aunt.Group.name=CPUCreditBalance" {
+				t.Errorf("%s: expected the alert to only be listed in the group, got %+v", alert.ID, alert)
+			}
+		case "StatusCheckFailed":
+			if !alert.Notified || alert.Silenced != "" {
+				t.Errorf("%s: expected the alert to be notified on its own, got %+v", alert.ID, alert)
+			}
+		}
+	}
+}
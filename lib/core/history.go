@@ -0,0 +1,127 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/asdine/storm"
+)
+
+// Sample is the value of a metric at a point in time. Resources only keep the latest value of their metrics, samples
+// are kept for a while so that the metrics can be analysed over days and weeks.
+type Sample struct {
+	// ID is the series and the time of the sample
+	ID string `storm:"id"`
+	// Series identifies the resource and the metric, e.g. Instance/i-123456/CPUUtilization
+	Series string    `storm:"index"`
+	Time   time.Time `storm:"index"`
+	Value  float64
+}
+
+// historyRetention is how long samples are kept before they are purged
+var historyRetention = 15 * 24 * time.Hour
+
+// SetHistoryRetention sets how many days samples are kept before they are purged
+func SetHistoryRetention(days int) error {
+	if days < 1 {
+		return fmt.Errorf("history retention must be at least one day: %d", days)
+	}
+	historyRetention = time.Duration(days) * 24 * time.Hour
+	return nil
+}
+
+// HistoryRetention returns how long samples are kept before they are purged
+func HistoryRetention() time.Duration {
+	return historyRetention
+}
+
+// SeriesName returns the name of the series for a metric on a resource, kind is the type of resource, e.g. Instance
+func SeriesName(kind, resourceID, metric string) string {
+	return kind + "/" + resourceID + "/" + metric
+}
+
+// RecordMetrics stores a sample for each metric that has a value
+func RecordMetrics(db *storm.DB, kind, resourceID string, metrics map[string]*float64) error {
+	now := time.Now()
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for name, value := range metrics {
+		if value == nil {
+			continue
+		}
+		series := SeriesName(kind, resourceID, name)
+		sample := &Sample{
+			ID:     fmt.Sprintf("%s@%d", series, now.UnixNano()),
+			Series: series,
+			Time:   now,
+			Value:  *value,
+		}
+		if err := tx.Save(sample); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// History returns the samples of a metric on a resource since a point in time, oldest first
+func History(db *storm.DB, kind, resourceID, metric string, since time.Time) ([]Sample, error) {
+	var all []Sample
+	if err := db.Find("Series", SeriesName(kind, resourceID, metric), &all); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	var samples []Sample
+	for _, s := range all {
+		if !s.Time.Before(since) {
+			samples = append(samples, s)
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, nil
+}
+
+// PurgeHistory removes samples that are older than the history retention
+func PurgeHistory(db *storm.DB) error {
+	var samples []Sample
+	if err := db.Range("Time", time.Time{}, time.Now().Add(-historyRetention), &samples); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for i := range samples {
+		if err := db.DeleteStruct(&samples[i]); err != nil {
+			fmt.Printf("history purge error: %v %s\n", err, samples[i].ID)
+		}
+	}
+	return nil
+}
+
+// Slope returns the rate of change per second of the samples, fitted with least squares. It's false when there are
+// too few samples or they are all from the same time.
+func Slope(samples []Sample) (float64, bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+	origin := samples[0].Time
+	var meanX, meanY float64
+	for _, s := range samples {
+		meanX += s.Time.Sub(origin).Seconds()
+		meanY += s.Value
+	}
+	n := float64(len(samples))
+	meanX /= n
+	meanY /= n
+	// the sums are taken around the means, the textbook n*sumXY - sumX*sumY cancels out large numbers and gives a small
+	// slope instead of zero for a flat series
+	var covariance, variance float64
+	for _, s := range samples {
+		dx := s.Time.Sub(origin).Seconds() - meanX
+		covariance += dx * (s.Value - meanY)
+		variance += dx * dx
+	}
+	if variance == 0 {
+		return 0, false
+	}
+	return covariance / variance, true
+}
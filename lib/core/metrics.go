@@ -0,0 +1,64 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// MetricWindow is how far back in time metrics are fetched and the period of the returned value, it matches the
+// interval between updates
+const MetricWindow = 15 * time.Minute
+
+// Metric returns the latest value of a CloudWatch metric over the last 15 minutes. The statistic is one of the standard
+// statistics, e.g. Average, Sum, Maximum or a percentile like p99.
+func Metric(cw *cloudwatch.CloudWatch, namespace string, dimensions []*cloudwatch.Dimension, metricName, statistic string) *float64 {
+	input := &cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String(namespace),
+		MetricName: aws.String(metricName),
+		Dimensions: dimensions,
+		StartTime:  aws.Time(time.Now().Add(-MetricWindow)),
+		EndTime:    aws.Time(time.Now()),
+		Period:     aws.Int64(int64(MetricWindow.Seconds())),
+	}
+	percentile := strings.HasPrefix(statistic, "p")
+	if percentile {
+		input.ExtendedStatistics = []*string{aws.String(statistic)}
+	} else {
+		input.Statistics = []*string{aws.String(statistic)}
+	}
+
+	result, err := cw.GetMetricStatistics(input)
+	if err != nil {
+		fmt.Printf("core.Metric %s %s %v\n", namespace, metricName, err)
+		return nil
+	}
+
+	var latest *cloudwatch.Datapoint
+	for _, dp := range result.Datapoints {
+		if latest == nil || dp.Timestamp.After(*latest.Timestamp) {
+			latest = dp
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+
+	if percentile {
+		return latest.ExtendedStatistics[statistic]
+	}
+	switch statistic {
+	case cloudwatch.StatisticSum:
+		return latest.Sum
+	case cloudwatch.StatisticMaximum:
+		return latest.Maximum
+	case cloudwatch.StatisticMinimum:
+		return latest.Minimum
+	case cloudwatch.StatisticSampleCount:
+		return latest.SampleCount
+	}
+	return latest.Average
+}
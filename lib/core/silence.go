@@ -0,0 +1,230 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+)
+
+// Matcher selects alerts by the account, region and resource they are for, their name and the tags of the resource.
+// Every field that is set must match and a matcher without any fields set matches nothing.
+type Matcher struct {
+	Account    string
+	Region     string
+	ResourceID string
+	// AlertName is the name of the alert, e.g. CPUCreditBalance, a trailing * matches all alerts with that prefix
+	AlertName string
+	// Tags are the AWS tags that the resource must have, e.g. {"Environment": "staging"}, a key can also be a tag role
+	// like team or environment
+	Tags map[string]string
+}
+
+// Silence stops matching alerts from being notified between Start and End, they are still recorded
+type Silence struct {
+	ID int `storm:"id,increment"`
+	Matcher
+	Start     time.Time
+	End       time.Time
+	CreatedBy string
+	Comment   string
+	Created   time.Time
+}
+
+// MaintenanceWindow is a recurring silence, e.g. every Sunday from 02:00 for 4 hours
+type MaintenanceWindow struct {
+	Name string
+	Matcher
+	// Days are the weekdays that the window starts on, e.g. ["Saturday", "Sunday"], it's every day when empty
+	Days []string
+	// Start is the time of day that the window starts, e.g. 22:00
+	Start string
+	// Duration is how long the window is open, e.g. 4h
+	Duration string
+	// TimeZone is the location of the start time, e.g. Pacific/Auckland, it's UTC when empty
+	TimeZone string
+}
+
+type maintenanceWindow struct {
+	MaintenanceWindow
+	days     map[time.Weekday]bool
+	start    time.Duration
+	duration time.Duration
+	location *time.Location
+}
+
+var maintenanceWindows []maintenanceWindow
+
+// ConfigureMaintenanceWindows parses and replaces the maintenance windows
+func ConfigureMaintenanceWindows(windows []MaintenanceWindow) error {
+	var parsed []maintenanceWindow
+	for _, w := range windows {
+		if w.Name == "" {
+			return fmt.Errorf("maintenance window needs a name: %+v", w)
+		}
+		if w.Matcher.empty() {
+			return fmt.Errorf("maintenance window %s doesn't match any alerts, set at least one of Account, Region, ResourceID, AlertName or Tags", w.Name)
+		}
+		mw := maintenanceWindow{MaintenanceWindow: w, days: make(map[time.Weekday]bool)}
+		for _, day := range w.Days {
+			weekday, ok := parseWeekday(day)
+			if !ok {
+				return fmt.Errorf("maintenance window %s has an unknown day %q", w.Name, day)
+			}
+			mw.days[weekday] = true
+		}
+		start, err := time.Parse("15:04", w.Start)
+		if err != nil {
+			return fmt.Errorf("maintenance window %s has an invalid start %q, it should be like 22:00", w.Name, w.Start)
+		}
+		mw.start = time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute
+		mw.duration, err = time.ParseDuration(w.Duration)
+		if err != nil || mw.duration <= 0 {
+			return fmt.Errorf("maintenance window %s has an invalid duration %q, it should be like 4h", w.Name, w.Duration)
+		}
+		mw.location, err = time.LoadLocation(w.TimeZone)
+		if err != nil {
+			return fmt.Errorf("maintenance window %s has an unknown time zone: %v", w.Name, err)
+		}
+		parsed = append(parsed, mw)
+	}
+	maintenanceWindows = parsed
+	return nil
+}
+
+// AddSilence validates and stores a new silence, it starts now if the start isn't set
+func AddSilence(db *storm.DB, silence *Silence) error {
+	if silence.Matcher.empty() {
+		return fmt.Errorf("a silence must match on at least one of account, region, resource id, alert name or tag")
+	}
+	silence.Created = time.Now()
+	if silence.Start.IsZero() {
+		silence.Start = silence.Created
+	}
+	if !silence.End.After(silence.Start) {
+		return fmt.Errorf("a silence must end after it starts")
+	}
+	if !silence.End.After(silence.Created) {
+		return fmt.Errorf("a silence can't end in the past")
+	}
+	return db.Save(silence)
+}
+
+// Silences returns the active and upcoming silences sorted by start, expired silences are included when all is true
+func Silences(db *storm.DB, all bool) ([]Silence, error) {
+	var silences []Silence
+	query := db.Select()
+	if !all {
+		query = db.Select(q.Gt("End", time.Now()))
+	}
+	if err := query.Find(&silences); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	sort.Slice(silences, func(i, j int) bool { return silences[i].Start.Before(silences[j].Start) })
+	return silences, nil
+}
+
+// ExpireSilence ends a silence now, the silence is kept so that it shows up in the list of all silences
+func ExpireSilence(db *storm.DB, id int) error {
+	var silence Silence
+	if err := db.One("ID", id, &silence); err != nil {
+		if err == storm.ErrNotFound {
+			return fmt.Errorf("there is no silence with id %d", id)
+		}
+		return err
+	}
+	now := time.Now()
+	if !silence.End.After(now) {
+		return fmt.Errorf("silence %d has already expired", id)
+	}
+	silence.End = now
+	if silence.Start.After(now) {
+		silence.Start = now
+	}
+	return db.Save(&silence)
+}
+
+// Active returns true when the silence applies at the time
+func (s *Silence) Active(t time.Time) bool {
+	return !t.Before(s.Start) && t.Before(s.End)
+}
+
+// String returns a short description of the silence, e.g. silence 3 by ops until 2017-08-21 10:52
+func (s *Silence) String() string {
+	return fmt.Sprintf("silence %d by %s until %s", s.ID, s.CreatedBy, s.End.Format("2006-01-02 15:04 MST"))
+}
+
+// silencedBy returns a description of the silence or maintenance window that applies to the alert right now, it's
+// empty when the alert isn't silenced
+func silencedBy(db *storm.DB, alert *Alert) (string, error) {
+	now := time.Now()
+	for _, w := range maintenanceWindows {
+		if w.open(now) && w.Matcher.matches(alert) {
+			return fmt.Sprintf("maintenance window %s", w.Name), nil
+		}
+	}
+	silences, err := Silences(db, false)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range silences {
+		if s.Active(now) && s.Matcher.matches(alert) {
+			return s.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// open returns true if the window is open at the time, a window can start on an earlier day and still be open
+func (w *maintenanceWindow) open(t time.Time) bool {
+	t = t.In(w.location)
+	// a window that ends after midnight is checked against the day before, and more days when it's longer than a day
+	for days := 0; time.Duration(days)*24*time.Hour < w.start+w.duration; days++ {
+		day := t.AddDate(0, 0, -days)
+		if len(w.days) > 0 && !w.days[day.Weekday()] {
+			continue
+		}
+		midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, w.location)
+		start := midnight.Add(w.start)
+		if !t.Before(start) && t.Before(start.Add(w.duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matcher) empty() bool {
+	return m.Account == "" && m.Region == "" && m.ResourceID == "" && m.AlertName == "" && len(m.Tags) == 0
+}
+
+func (m *Matcher) matches(alert *Alert) bool {
+	if m.empty() {
+		return false
+	}
+	if m.Account != "" && m.Account != alert.Details["account"] {
+		return false
+	}
+	if m.Region != "" && m.Region != alert.Details["region"] {
+		return false
+	}
+	if m.ResourceID != "" && m.ResourceID != alert.Entity {
+		return false
+	}
+	if m.AlertName != "" && !nameMatches(m.AlertName, alert.Name) {
+		return false
+	}
+	return TagFilter(m.Tags).Matches(alert.ResourceTags)
+}
+
+func parseWeekday(day string) (time.Weekday, bool) {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := weekday.String()
+		if strings.EqualFold(day, name) || strings.EqualFold(day, name[:3]) {
+			return weekday, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWeekday(t *testing.T) {
+	tests := []struct {
+		day     string
+		weekday time.Weekday
+		ok      bool
+	}{
+		{"Sunday", time.Sunday, true},
+		{"sun", time.Sunday, true},
+		{"SATURDAY", time.Saturday, true},
+		{"Sat", time.Saturday, true},
+		{"wednesday", time.Wednesday, true},
+		{"Thurs", 0, false},
+		{"Su", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		weekday, ok := parseWeekday(test.day)
+		if weekday != test.weekday || ok != test.ok {
+			t.Errorf("parseWeekday(%q) = %v, %v, expected %v, %v", test.day, weekday, ok, test.weekday, test.ok)
+		}
+	}
+}
+
+func TestMaintenanceWindowOpen(t *testing.T) {
+	previous := maintenanceWindows
+	t.Cleanup(func() { maintenanceWindows = previous })
+
+	// 2017-08-20 is a Sunday
+	at := func(dayAndTime string) time.Time {
+		at, err := time.Parse("2006-01-02 15:04", "2017-08-"+dayAndTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+	tests := []struct {
+		name   string
+		window MaintenanceWindow
+		at     time.Time
+		open   bool
+	}{
+		{"before start", MaintenanceWindow{Days: []string{"Sunday"}, Start: "02:00", Duration: "4h"}, at("20 01:59"), false},
+		{"at start", MaintenanceWindow{Days: []string{"Sunday"}, Start: "02:00", Duration: "4h"}, at("20 02:00"), true},
+		{"before end", MaintenanceWindow{Days: []string{"Sunday"}, Start: "02:00", Duration: "4h"}, at("20 05:59"), true},
+		{"at end", MaintenanceWindow{Days: []string{"Sunday"}, Start: "02:00", Duration: "4h"}, at("20 06:00"), false},
+		{"other day", MaintenanceWindow{Days: []string{"Sunday"}, Start: "02:00", Duration: "4h"}, at("21 03:00"), false},
+		// a window that starts late on Saturday is still open early on Sunday, but not late on Sunday
+		{"wraps before midnight", MaintenanceWindow{Days: []string{"Sat"}, Start: "22:00", Duration: "4h"}, at("19 23:00"), true},
+		{"wraps after midnight", MaintenanceWindow{Days: []string{"Sat"}, Start: "22:00", Duration: "4h"}, at("20 01:59"), true},
+		{"wraps at end", MaintenanceWindow{Days: []string{"Sat"}, Start: "22:00", Duration: "4h"}, at("20 02:00"), false},
+		{"wraps on the next start day", MaintenanceWindow{Days: []string{"Sat"}, Start: "22:00", Duration: "4h"}, at("20 23:00"), false},
+		{"wraps from the wrong day", MaintenanceWindow{Days: []string{"Sat"}, Start: "22:00", Duration: "4h"}, at("19 01:00"), false},
+		{"several days", MaintenanceWindow{Days: []string{"Friday", "Saturday"}, Start: "22:00", Duration: "4h"}, at("19 01:00"), true},
+		{"every day after midnight", MaintenanceWindow{Start: "23:00", Duration: "2h"}, at("23 00:30"), true},
+		{"every day at end", MaintenanceWindow{Start: "23:00", Duration: "2h"}, at("23 01:00"), false},
+		// a window that is open for longer than a day is checked against every day it can have started on
+		{"longer than a day", MaintenanceWindow{Days: []string{"Saturday"}, Start: "22:00", Duration: "30h"}, at("21 03:59"), true},
+		{"longer than a day at end", MaintenanceWindow{Days: []string{"Saturday"}, Start: "22:00", Duration: "30h"}, at("21 04:00"), false},
+		// 02:00 on Sunday in Auckland is 14:00 on Saturday in UTC
+		{"time zone", MaintenanceWindow{Days: []string{"Sunday"}, Start: "02:00", Duration: "2h", TimeZone: "Pacific/Auckland"}, at("19 14:30"), true},
+		{"time zone in UTC", MaintenanceWindow{Days: []string{"Sunday"}, Start: "02:00", Duration: "2h", TimeZone: "Pacific/Auckland"}, at("20 02:30"), false},
+	}
+	for _, test := range tests {
+		test.window.Name = test.name
+		test.window.AlertName = "*"
+		if err := ConfigureMaintenanceWindows([]MaintenanceWindow{test.window}); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if open := maintenanceWindows[0].open(test.at); open != test.open {
+			t.Errorf("%s: open(%s) = %v, expected %v", test.name, test.at.Format(time.RFC1123), open, test.open)
+		}
+	}
+}
+
+func TestMatcherMatches(t *testing.T) {
+	alert := NewAlert("CPUCreditBalance", "i-1")
+	alert.Details["account"] = "prod"
+	alert.Details["region"] = "us-east-1"
+	alert.ResourceTags = map[string]string{"Team": "web", "env": "production", "Service": "api"}
+
+	tests := []struct {
+		name    string
+		matcher Matcher
+		matches bool
+	}{
+		{"empty", Matcher{}, false},
+		{"account", Matcher{Account: "prod"}, true},
+		{"other account", Matcher{Account: "staging"}, false},
+		{"region", Matcher{Region: "us-east-1"}, true},
+		{"other region", Matcher{Region: "eu-west-1"}, false},
+		{"resource", Matcher{ResourceID: "i-1"}, true},
+		{"other resource", Matcher{ResourceID: "i-2"}, false},
+		{"alert name", Matcher{AlertName: "CPUCreditBalance"}, true},
+		{"alert name prefix", Matcher{AlertName: "CPU*"}, true},
+		{"alert name is not a prefix", Matcher{AlertName: "CPU"}, false},
+		{"every alert", Matcher{AlertName: "*"}, true},
+		{"tag", Matcher{Tags: map[string]string{"Service": "api"}}, true},
+		{"tag key in another case", Matcher{Tags: map[string]string{"service": "api"}}, true},
+		{"tag value in another case", Matcher{Tags: map[string]string{"Service": "API"}}, false},
+		{"tag role", Matcher{Tags: map[string]string{"environment": "production"}}, true},
+		{"missing tag", Matcher{Tags: map[string]string{"Owner": "ops"}}, false},
+		{"all tags", Matcher{Tags: map[string]string{"team": "web", "Service": "api"}}, true},
+		{"one tag differs", Matcher{Tags: map[string]string{"team": "web", "Service": "db"}}, false},
+		{"all fields", Matcher{Account: "prod", Region: "us-east-1", ResourceID: "i-1", AlertName: "CPU*", Tags: map[string]string{"team": "web"}}, true},
+		{"one field differs", Matcher{Account: "prod", Region: "us-east-1", ResourceID: "i-1", AlertName: "Status*"}, false},
+	}
+	for _, test := range tests {
+		if matches := test.matcher.matches(alert); matches != test.matches {
+			t.Errorf("%s: matches() = %v, expected %v", test.name, matches, test.matches)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TagKeysConfig maps the roles that resource tags have to the tag keys used for them, the first key that a resource
+// has is used, e.g. {"Team": ["team", "squad"]}. Keys are matched case insensitively.
+type TagKeysConfig struct {
+	Owner       []string
+	Team        []string
+	Environment []string
+	CostCenter  []string
+}
+
+// Tag roles, a tag filter can use these instead of the tag keys
+const (
+	TagOwner       = "owner"
+	TagTeam        = "team"
+	TagEnvironment = "environment"
+	TagCostCenter  = "cost-center"
+)
+
+var tagKeys = map[string][]string{
+	TagOwner:       {"owner"},
+	TagTeam:        {"team"},
+	TagEnvironment: {"environment", "env"},
+	TagCostCenter:  {"cost-center", "costcenter"},
+}
+
+// ConfigureTagKeys overrides the tag keys for the roles that are set
+func ConfigureTagKeys(cfg TagKeysConfig) {
+	for role, keys := range map[string][]string{
+		TagOwner:       cfg.Owner,
+		TagTeam:        cfg.Team,
+		TagEnvironment: cfg.Environment,
+		TagCostCenter:  cfg.CostCenter,
+	} {
+		if len(keys) > 0 {
+			tagKeys[role] = keys
+		}
+	}
+}
+
+var accountTags = make(map[string]map[string]string)
+
+// ConfigureAccountTags sets the tags of each account, keyed by the account names in the roles
+func ConfigureAccountTags(tags map[string]map[string]string) {
+	accountTags = make(map[string]map[string]string)
+	for account, t := range tags {
+		accountTags[account] = t
+	}
+}
+
+// AccountTags returns the tags of an account, they are used as the resource tags of alerts on the account itself, like
+// service limits and root access keys
+func AccountTags(account string) map[string]string {
+	return accountTags[account]
+}
+
+// ResourceTag returns the value of a tag, the key is either a role like team or environment or the key of a tag
+func ResourceTag(tags map[string]string, key string) string {
+	keys, ok := tagKeys[strings.ToLower(key)]
+	if !ok {
+		keys = []string{key}
+	}
+	for _, key := range keys {
+		for k, v := range tags {
+			if strings.EqualFold(k, key) && v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// TagFilter selects resources that has all the tags, a key is either a role like team or environment or the key of a
+// tag, e.g. {"team": "web", "Service": "api"}
+type TagFilter map[string]string
+
+// ParseTagFilter parses key=value pairs into a filter
+func ParseTagFilter(pairs []string) (TagFilter, error) {
+	filter := make(TagFilter)
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("tag filter should be key=value: %q", pair)
+		}
+		filter[parts[0]] = parts[1]
+	}
+	return filter, nil
+}
+
+// Matches returns true if the tags has all the tags in the filter, an empty filter matches everything
+func (f TagFilter) Matches(tags map[string]string) bool {
+	for key, value := range f {
+		if ResourceTag(tags, key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the filter as sorted key=value pairs
+func (f TagFilter) String() string {
+	var pairs []string
+	for key, value := range f {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// teamFromTags returns the team that owns a resource with the tags, it's the owner when there is no team tag
+func teamFromTags(tags map[string]string) string {
+	if team := ResourceTag(tags, TagTeam); team != "" {
+		return team
+	}
+	return ResourceTag(tags, TagOwner)
+}
+
+// unique returns the non empty values in the order they first appear
+func unique(values []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, v := range values {
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		result = append(result, v)
+	}
+	return result
+}
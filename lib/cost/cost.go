@@ -0,0 +1,372 @@
+package cost
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stojg/aunt/lib/core"
+	"github.com/stojg/aunt/lib/dynamodb"
+	"github.com/stojg/aunt/lib/ebs"
+	"github.com/stojg/aunt/lib/ec2"
+	"github.com/stojg/aunt/lib/rds"
+)
+
+// Estimate is the estimated monthly cost of a resource
+type Estimate struct {
+	// ResourceID is the kind and the id of the resource, e.g. Volume/vol-123456
+	ResourceID string `storm:"id"`
+	Kind       string `storm:"index"`
+	Name       string
+	Resource   string
+	// Basis describes what the estimate is based on, e.g. the instance type or the volume size and type
+	Basis string
+	// Priced is false when the price list doesn't have a price for the resource, the Monthly cost is zero then
+	Priced      bool
+	Monthly     float64
+	Tags        map[string]string
+	Region      string
+	Account     string
+	LastUpdated time.Time `storm:"index"`
+}
+
+// Total is the estimated monthly cost for all resources in an account, region or with a tag
+type Total struct {
+	// ResourceID is the scope and the key, e.g. account/123456, region/us-east-1 or tag/Team=ops
+	ResourceID  string `storm:"id"`
+	Scope       string `storm:"index"`
+	Key         string
+	LastUpdated time.Time `storm:"index"`
+	Metrics     map[string]*float64
+}
+
+// Config holds the location of the price list and the tags to total the costs by
+type Config struct {
+	// PriceFile is a .json or .csv price list, see LoadPrices
+	PriceFile string
+	// TagKeys are the tag keys that costs are totalled by
+	TagKeys []string
+}
+
+// Kinds of estimated resources
+const (
+	KindInstance   = "Instance"
+	KindDBInstance = "DBInstance"
+	KindVolume     = "Volume"
+	KindTable      = "Table"
+)
+
+// Scopes of the totals
+const (
+	ScopeAccount = "account"
+	ScopeRegion  = "region"
+	ScopeTag     = "tag"
+)
+
+const metricMonthlyCost = "MonthlyCost"
+
+var settings = Config{
+	PriceFile: "prices.json",
+}
+
+// Configure sets the price list location and the tag keys, zero values keep the defaults
+func Configure(cfg Config) {
+	if cfg.PriceFile != "" {
+		settings.PriceFile = cfg.PriceFile
+	}
+	settings.TagKeys = cfg.TagKeys
+}
+
+// PriceFile returns the configured price list location
+func PriceFile() string {
+	return settings.PriceFile
+}
+
+// Update will update the database with Estimate and Total data. It uses the stored resources so it should run after
+// those has been updated, and it does nothing until a price list has been downloaded.
+func Update(db *storm.DB) error {
+	prices, err := LoadPrices(settings.PriceFile)
+	if os.IsNotExist(err) {
+		fmt.Printf("cost: no price list at %s, run 'aunt cost refresh' to download it\n", settings.PriceFile)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	since := started.Add(-core.MaxResourceAge)
+	var estimates []*Estimate
+
+	var instances []ec2.Instance
+	if err := db.Select(q.Gte("LastUpdated", since)).Find(&instances); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, i := range instances {
+		// stopped instances are only paying for their volumes
+		if i.State != "running" {
+			continue
+		}
+		e := newEstimate(KindInstance, i.ResourceID, i.Name, i.Account, i.Region, i.Tags)
+		e.Basis = i.InstanceType
+		if monthly, ok := prices.InstanceMonthly(i.Region, i.InstanceType); ok {
+			e.price(monthly)
+		}
+		estimates = append(estimates, e)
+	}
+
+	var dbInstances []rds.DBInstance
+	if err := db.Select(q.Gte("LastUpdated", since)).Find(&dbInstances); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, i := range dbInstances {
+		if i.State == "stopped" {
+			continue
+		}
+		e := newEstimate(KindDBInstance, i.ResourceID, i.Name, i.Account, i.Region, i.Tags)
+		e.Basis = fmt.Sprintf("%s %s %d GiB", i.InstanceType, i.Engine, i.AllocatedStorage)
+		if monthly, ok := prices.DBInstanceMonthly(i.Region, i.InstanceType); ok {
+			monthly += float64(i.AllocatedStorage) * prices.Regions[i.Region].RDSStorage
+			// a multi AZ deployment is a standby copy of the instance and storage
+			if i.MultiAZ {
+				e.Basis += " multi-AZ"
+				monthly *= 2
+			}
+			e.price(monthly)
+		}
+		estimates = append(estimates, e)
+	}
+
+	var volumes []ebs.Volume
+	if err := db.Select(q.Gte("LastUpdated", since)).Find(&volumes); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, v := range volumes {
+		e := newEstimate(KindVolume, v.ResourceID, v.Name, v.Account, v.Region, v.Tags)
+		e.Basis = fmt.Sprintf("%d GiB %s", v.Size, v.VolumeType)
+		if rp := prices.Regions[v.Region]; rp != nil {
+			if perGiB, ok := rp.EBS[v.VolumeType]; ok {
+				monthly := float64(v.Size) * perGiB
+				// only provisioned IOPS volumes are charged for the IOPS, other volume types has a zero price
+				if perIOPS, ok := rp.EBSIOPS[v.VolumeType]; ok && v.IOPS != nil {
+					e.Basis += fmt.Sprintf(" %d IOPS", *v.IOPS)
+					monthly += float64(*v.IOPS) * perIOPS
+				}
+				e.price(monthly)
+			}
+		}
+		estimates = append(estimates, e)
+	}
+
+	var tables []dynamodb.Table
+	if err := db.Select(q.Gte("LastUpdated", since)).Find(&tables); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, t := range tables {
+		e := newEstimate(KindTable, t.ResourceID, t.Name, t.Account, t.Region, t.Tags)
+		// on demand tables are charged per request, which isn't in the price list
+		if t.BillingMode == dynamodb.BillingModePayPerRequest {
+			e.Basis = "on demand"
+			estimates = append(estimates, e)
+			continue
+		}
+		// global secondary indexes has their own provisioned capacity
+		read, write := t.ReadCapacity, t.WriteCapacity
+		var indexes []dynamodb.GlobalSecondaryIndex
+		if err := db.Find("TableName", t.Name, &indexes); err != nil && err != storm.ErrNotFound {
+			return err
+		}
+		for _, idx := range indexes {
+			if idx.Account == t.Account && idx.Region == t.Region && !idx.LastUpdated.Before(since) {
+				read += idx.ReadCapacity
+				write += idx.WriteCapacity
+			}
+		}
+		e.Basis = fmt.Sprintf("%d RCU %d WCU", read, write)
+		if monthly, ok := prices.CapacityMonthly(t.Region, read, write); ok {
+			e.price(monthly)
+		}
+		estimates = append(estimates, e)
+	}
+
+	for _, e := range estimates {
+		e.LastUpdated = started
+		if err := db.Save(e); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+	}
+	for _, t := range totals(estimates, started) {
+		if err := db.Save(t); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+	}
+
+	// anything that wasn't estimated during this update has been removed
+	var staleEstimates []Estimate
+	if err := db.Select(q.Lt("LastUpdated", started)).Find(&staleEstimates); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for i := range staleEstimates {
+		if err := db.DeleteStruct(&staleEstimates[i]); err != nil {
+			fmt.Printf("cost purge error: %v %s\n", err, staleEstimates[i].ResourceID)
+		}
+	}
+	var staleTotals []Total
+	if err := db.Select(q.Lt("LastUpdated", started)).Find(&staleTotals); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for i := range staleTotals {
+		if err := db.DeleteStruct(&staleTotals[i]); err != nil {
+			fmt.Printf("cost purge error: %v %s\n", err, staleTotals[i].ResourceID)
+		}
+	}
+	return nil
+}
+
+func newEstimate(kind, resource, name, account, region string, tags map[string]string) *Estimate {
+	return &Estimate{
+		ResourceID: kind + "/" + resource,
+		Kind:       kind,
+		Name:       name,
+		Resource:   resource,
+		Tags:       tags,
+		Region:     region,
+		Account:    account,
+	}
+}
+
+func (e *Estimate) price(monthly float64) {
+	e.Priced = true
+	e.Monthly = monthly
+}
+
+func totals(estimates []*Estimate, now time.Time) []*Total {
+	sums := make(map[string]*Total)
+	add := func(scope, key string, monthly float64) {
+		id := scope + "/" + key
+		t, ok := sums[id]
+		if !ok {
+			t = &Total{
+				ResourceID:  id,
+				Scope:       scope,
+				Key:         key,
+				LastUpdated: now,
+				Metrics:     map[string]*float64{metricMonthlyCost: aws.Float64(0)},
+			}
+			sums[id] = t
+		}
+		*t.Metrics[metricMonthlyCost] += monthly
+	}
+	for _, e := range estimates {
+		add(ScopeAccount, e.Account, e.Monthly)
+		add(ScopeRegion, e.Region, e.Monthly)
+		for _, key := range settings.TagKeys {
+			if value := core.ResourceTag(e.Tags, key); value != "" {
+				add(ScopeTag, key+"="+value, e.Monthly)
+			}
+		}
+	}
+	var result []*Total
+	for _, t := range sums {
+		result = append(result, t)
+	}
+	return result
+}
+
+// Summary is the estimated monthly cost in total and per account, region and tag
+type Summary struct {
+	Total     float64
+	Accounts  map[string]float64
+	Regions   map[string]float64
+	Tags      map[string]float64
+	Unpriced  int
+	Estimates int
+}
+
+// Report returns the current estimates for resources that matches the tag filter, most expensive first, and a summary
+// of their totals
+func Report(db *storm.DB, filter core.TagFilter) ([]Estimate, *Summary, error) {
+	estimates, err := filtered(db, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(estimates, func(i, j int) bool {
+		if estimates[i].Monthly != estimates[j].Monthly {
+			return estimates[i].Monthly > estimates[j].Monthly
+		}
+		return estimates[i].ResourceID < estimates[j].ResourceID
+	})
+
+	totals, err := Totals(db, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	summary := &Summary{
+		Accounts:  make(map[string]float64),
+		Regions:   make(map[string]float64),
+		Tags:      make(map[string]float64),
+		Estimates: len(estimates),
+	}
+	for _, e := range estimates {
+		summary.Total += e.Monthly
+		if !e.Priced {
+			summary.Unpriced++
+		}
+	}
+	for _, t := range totals {
+		monthly := aws.Float64Value(t.Metrics[metricMonthlyCost])
+		switch t.Scope {
+		case ScopeAccount:
+			summary.Accounts[t.Key] = monthly
+		case ScopeRegion:
+			summary.Regions[t.Key] = monthly
+		case ScopeTag:
+			summary.Tags[t.Key] = monthly
+		}
+	}
+	return estimates, summary, nil
+}
+
+// Totals returns the totals sorted by id, they are the stored totals unless there is a tag filter, then they are
+// totalled from the estimates for the resources that matches it
+func Totals(db *storm.DB, filter core.TagFilter) ([]Total, error) {
+	var result []Total
+	if len(filter) == 0 {
+		if err := db.All(&result); err != nil && err != storm.ErrNotFound {
+			return nil, err
+		}
+	} else {
+		estimates, err := filtered(db, filter)
+		if err != nil {
+			return nil, err
+		}
+		var matching []*Estimate
+		for i := range estimates {
+			matching = append(matching, &estimates[i])
+		}
+		for _, t := range totals(matching, time.Now()) {
+			result = append(result, *t)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ResourceID < result[j].ResourceID })
+	return result, nil
+}
+
+// filtered returns the stored estimates for the resources that matches the tag filter
+func filtered(db *storm.DB, filter core.TagFilter) ([]Estimate, error) {
+	var all []Estimate
+	if err := db.All(&all); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	var estimates []Estimate
+	for _, e := range all {
+		if filter.Matches(e.Tags) {
+			estimates = append(estimates, e)
+		}
+	}
+	return estimates, nil
+}
@@ -0,0 +1,230 @@
+package cost
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prices is an offline copy of the AWS on demand prices that are needed for the estimates
+type Prices struct {
+	Updated time.Time
+	Regions map[string]*RegionPrices
+}
+
+// RegionPrices are the prices in USD for one region
+type RegionPrices struct {
+	// EC2 is the hourly price for a Linux instance with shared tenancy, keyed by instance type
+	EC2 map[string]float64
+	// RDS is the hourly price for a single AZ MySQL DB instance, keyed by instance class
+	RDS map[string]float64
+	// RDSStorage is the monthly price per GiB of single AZ general purpose storage
+	RDSStorage float64
+	// EBS is the monthly price per GiB, keyed by volume type
+	EBS map[string]float64
+	// EBSIOPS is the monthly price per provisioned IOPS, keyed by volume type
+	EBSIOPS map[string]float64
+	// DynamoDBRead and DynamoDBWrite are the hourly prices per provisioned capacity unit
+	DynamoDBRead  float64
+	DynamoDBWrite float64
+}
+
+// HoursPerMonth is the average number of hours in a month that AWS uses for monthly estimates
+const HoursPerMonth = 730
+
+// services are the names used in the first column of a CSV price list
+const (
+	serviceEC2           = "ec2"
+	serviceRDS           = "rds"
+	serviceRDSStorage    = "rds-storage"
+	serviceEBS           = "ebs"
+	serviceEBSIOPS       = "ebs-iops"
+	serviceDynamoDBRead  = "dynamodb-read"
+	serviceDynamoDBWrite = "dynamodb-write"
+)
+
+// InstanceMonthly returns the monthly price of an EC2 instance type in a region
+func (p *Prices) InstanceMonthly(region, instanceType string) (float64, bool) {
+	rp, ok := p.Regions[region]
+	if !ok {
+		return 0, false
+	}
+	hourly, ok := rp.EC2[instanceType]
+	return hourly * HoursPerMonth, ok
+}
+
+// DBInstanceMonthly returns the monthly price of a single AZ RDS instance class in a region, without storage
+func (p *Prices) DBInstanceMonthly(region, instanceClass string) (float64, bool) {
+	rp, ok := p.Regions[region]
+	if !ok {
+		return 0, false
+	}
+	hourly, ok := rp.RDS[instanceClass]
+	return hourly * HoursPerMonth, ok
+}
+
+// CapacityMonthly returns the monthly price of provisioned DynamoDB read and write capacity in a region
+func (p *Prices) CapacityMonthly(region string, read, write int64) (float64, bool) {
+	rp, ok := p.Regions[region]
+	if !ok || rp.DynamoDBRead == 0 || rp.DynamoDBWrite == 0 {
+		return 0, false
+	}
+	return (float64(read)*rp.DynamoDBRead + float64(write)*rp.DynamoDBWrite) * HoursPerMonth, true
+}
+
+func newRegionPrices() *RegionPrices {
+	return &RegionPrices{
+		EC2:     make(map[string]float64),
+		RDS:     make(map[string]float64),
+		EBS:     make(map[string]float64),
+		EBSIOPS: make(map[string]float64),
+	}
+}
+
+func (p *Prices) region(name string) *RegionPrices {
+	if p.Regions == nil {
+		p.Regions = make(map[string]*RegionPrices)
+	}
+	if _, ok := p.Regions[name]; !ok {
+		p.Regions[name] = newRegionPrices()
+	}
+	return p.Regions[name]
+}
+
+// LoadPrices reads a price list from a .json or .csv file. The CSV format has one price per row with the columns
+// region, service, key and price, where service is one of ec2, rds, rds-storage, ebs, ebs-iops, dynamodb-read or
+// dynamodb-write and key is the instance type, instance class or volume type.
+func LoadPrices(path string) (*Prices, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		prices, err := readCSV(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading price list %s: %v", path, err)
+		}
+		if stat, err := f.Stat(); err == nil {
+			prices.Updated = stat.ModTime()
+		}
+		return prices, nil
+	}
+
+	prices := &Prices{}
+	if err := json.NewDecoder(f).Decode(prices); err != nil {
+		return nil, fmt.Errorf("error reading price list %s: %v", path, err)
+	}
+	return prices, nil
+}
+
+// SavePrices writes the price list to path, the format is chosen from the file extension like in LoadPrices
+func SavePrices(prices *Prices, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = writeCSV(f, prices)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(prices)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readCSV(r io.Reader) (*Prices, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 4
+	prices := &Prices{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return prices, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// allow a header row
+		if record[0] == "region" {
+			continue
+		}
+		price, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price %q for %s %s", record[3], record[1], record[2])
+		}
+		rp := prices.region(record[0])
+		switch record[1] {
+		case serviceEC2:
+			rp.EC2[record[2]] = price
+		case serviceRDS:
+			rp.RDS[record[2]] = price
+		case serviceRDSStorage:
+			rp.RDSStorage = price
+		case serviceEBS:
+			rp.EBS[record[2]] = price
+		case serviceEBSIOPS:
+			rp.EBSIOPS[record[2]] = price
+		case serviceDynamoDBRead:
+			rp.DynamoDBRead = price
+		case serviceDynamoDBWrite:
+			rp.DynamoDBWrite = price
+		default:
+			return nil, fmt.Errorf("unknown service %q", record[1])
+		}
+	}
+}
+
+func writeCSV(w io.Writer, prices *Prices) error {
+	writer := csv.NewWriter(w)
+	format := func(price float64) string {
+		return strconv.FormatFloat(price, 'f', -1, 64)
+	}
+	writeMap := func(region, service string, values map[string]float64) {
+		var keys []string
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writer.Write([]string{region, service, key, format(values[key])})
+		}
+	}
+
+	writer.Write([]string{"region", "service", "key", "price"})
+	var regions []string
+	for region := range prices.Regions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		rp := prices.Regions[region]
+		writeMap(region, serviceEC2, rp.EC2)
+		writeMap(region, serviceRDS, rp.RDS)
+		writer.Write([]string{region, serviceRDSStorage, "", format(rp.RDSStorage)})
+		writeMap(region, serviceEBS, rp.EBS)
+		writeMap(region, serviceEBSIOPS, rp.EBSIOPS)
+		writer.Write([]string{region, serviceDynamoDBRead, "", format(rp.DynamoDBRead)})
+		writer.Write([]string{region, serviceDynamoDBWrite, "", format(rp.DynamoDBWrite)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
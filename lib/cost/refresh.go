@@ -0,0 +1,185 @@
+package cost
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// offerURL is the regional offer file in the AWS Price List API, these are large so only the CSV version is used and
+// it's read as a stream
+const offerURL = "https://pricing.us-east-1.amazonaws.com/offers/v1.0/aws/%s/current/%s/index.csv"
+
+// volumeTypes maps the EBS volume type names in the offer files to the API names, newer offer files has a
+// "Volume API Name" column that is used instead when it's available
+var volumeTypes = map[string]string{
+	"General Purpose":          "gp2",
+	"Provisioned IOPS":         "io1",
+	"Throughput Optimized HDD": "st1",
+	"Cold HDD":                 "sc1",
+	"Magnetic":                 "standard",
+}
+
+// Refresh downloads the current on demand prices for the regions from the AWS Price List API
+func Refresh(regions []string) (*Prices, error) {
+	prices := &Prices{Updated: time.Now()}
+	for _, region := range regions {
+		rp := prices.region(region)
+		if err := readOffer("AmazonEC2", region, rp.addEC2); err != nil {
+			return nil, err
+		}
+		if err := readOffer("AmazonRDS", region, rp.addRDS); err != nil {
+			return nil, err
+		}
+		if err := readOffer("AmazonDynamoDB", region, rp.addDynamoDB); err != nil {
+			return nil, err
+		}
+	}
+	return prices, nil
+}
+
+// offerRow gives access to the columns in a row of an offer file by name
+type offerRow func(column string) string
+
+func (r offerRow) price() (float64, bool) {
+	if r("TermType") != "OnDemand" {
+		return 0, false
+	}
+	price, err := strconv.ParseFloat(r("PricePerUnit"), 64)
+	if err != nil {
+		return 0, false
+	}
+	return price, true
+}
+
+func (rp *RegionPrices) addEC2(row offerRow) {
+	price, ok := row.price()
+	if !ok {
+		return
+	}
+	switch row("Product Family") {
+	case "Compute Instance":
+		if row("Operating System") != "Linux" || row("Tenancy") != "Shared" || row("Pre Installed S/W") != "NA" || row("Unit") != "Hrs" {
+			return
+		}
+		// reservations and dedicated capacity are listed with the same instance type
+		if status := row("CapacityStatus"); status != "" && status != "Used" {
+			return
+		}
+		rp.EC2[row("Instance Type")] = price
+	case "Storage":
+		if row("Unit") != "GB-Mo" {
+			return
+		}
+		if volumeType := volumeType(row); volumeType != "" {
+			rp.EBS[volumeType] = price
+		}
+	case "System Operation":
+		if row("Group") != "EBS IOPS" || row("Unit") != "IOPS-Mo" {
+			return
+		}
+		volumeType := volumeType(row)
+		if volumeType == "" {
+			volumeType = "io1"
+		}
+		rp.EBSIOPS[volumeType] = price
+	}
+}
+
+func (rp *RegionPrices) addRDS(row offerRow) {
+	price, ok := row.price()
+	if !ok || row("Deployment Option") != "Single-AZ" {
+		return
+	}
+	switch row("Product Family") {
+	case "Database Instance":
+		if row("Database Engine") != "MySQL" || row("Unit") != "Hrs" {
+			return
+		}
+		rp.RDS[row("Instance Type")] = price
+	case "Database Storage":
+		if row("Volume Type") != "General Purpose" || row("Unit") != "GB-Mo" {
+			return
+		}
+		switch row("Database Engine") {
+		case "", "Any", "MySQL":
+			rp.RDSStorage = price
+		}
+	}
+}
+
+func (rp *RegionPrices) addDynamoDB(row offerRow) {
+	price, ok := row.price()
+	if !ok || row("Product Family") != "Provisioned IOPS" {
+		return
+	}
+	// the free tier is listed as a separate zero priced row, keep the highest price
+	switch row("Group") {
+	case "DDB-ReadUnits":
+		if price > rp.DynamoDBRead {
+			rp.DynamoDBRead = price
+		}
+	case "DDB-WriteUnits":
+		if price > rp.DynamoDBWrite {
+			rp.DynamoDBWrite = price
+		}
+	}
+}
+
+func volumeType(row offerRow) string {
+	if name := row("Volume API Name"); name != "" {
+		return name
+	}
+	return volumeTypes[row("Volume Type")]
+}
+
+// readOffer streams the offer file for a service in a region and calls fn for each price row
+func readOffer(service, region string, fn func(row offerRow)) error {
+	url := fmt.Sprintf(offerURL, service, region)
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching %s: %s", url, resp.Status)
+	}
+
+	reader := csv.NewReader(resp.Body)
+	// the file starts with a few lines of metadata that has a different number of columns
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	var header map[string]int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", url, err)
+		}
+		if header == nil {
+			if len(record) > 0 && record[0] == "SKU" {
+				header = make(map[string]int, len(record))
+				for i, name := range record {
+					header[name] = i
+				}
+			}
+			continue
+		}
+		fn(func(column string) string {
+			if i, ok := header[column]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		})
+	}
+	if header == nil {
+		return fmt.Errorf("no prices found in %s", url)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"io"
+	"os"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+)
+
+// Backup writes a consistent snapshot of the database to w. It runs in a read transaction so it's safe to call while
+// the database is being updated.
+func Backup(db *storm.DB, w io.Writer) (int64, error) {
+	var written int64
+	err := db.Bolt.View(func(tx *bolt.Tx) error {
+		var err error
+		written, err = tx.WriteTo(w)
+		return err
+	})
+	return written, err
+}
+
+// BackupFile writes a snapshot of the database to the file at path. The snapshot is written to a temporary file first so
+// that a failed backup never leaves a half written file behind.
+func BackupFile(db *storm.DB, path string) (int64, error) {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	written, err := Backup(db, f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	return written, os.Rename(tmp, path)
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"os"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+)
+
+// Compact copies all buckets and keys into a new database file at dst with fully packed pages, this reclaims the space
+// bolt keeps in the file after records has been deleted.
+func Compact(db *storm.DB, dst string) error {
+	out, err := bolt.Open(dst, 0600, nil)
+	if err != nil {
+		return err
+	}
+	err = db.Bolt.View(func(src *bolt.Tx) error {
+		return out.Update(func(dstTx *bolt.Tx) error {
+			return src.ForEach(func(name []byte, b *bolt.Bucket) error {
+				nb, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(b, nb)
+			})
+		})
+	})
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+	}
+	return err
+}
+
+func copyBucket(src, dst *bolt.Bucket) error {
+	dst.FillPercent = 1.0
+	return src.ForEach(func(k, v []byte) error {
+		// a nil value means that the key is a nested bucket
+		if v == nil {
+			nb, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(src.Bucket(k), nb)
+		}
+		return dst.Put(k, v)
+	})
+}
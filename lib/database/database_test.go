@@ -0,0 +1,241 @@
+package database
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+	"github.com/stojg/aunt/lib/core"
+)
+
+// openFixture opens a copy of the database in the testdata directory. It has alerts indexed by LastUpdated, a silence
+// with an auto incremented binary key, a bucket with a value that isn't JSON and an empty bucket.
+func openFixture(t *testing.T) *storm.DB {
+	t.Helper()
+	dir := tempDir(t)
+	src, err := os.Open(filepath.Join("testdata", "aunt.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	path := filepath.Join(dir, "aunt.db")
+	dst, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return open(t, path)
+}
+
+func open(t *testing.T, path string) *storm.DB {
+	t.Helper()
+	db, err := storm.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "aunt-database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// dump returns every key and value in the database keyed by the bucket path and the key, an empty bucket is an entry
+// with an empty key
+func dump(t *testing.T, db *bolt.DB) map[string]string {
+	t.Helper()
+	result := make(map[string]string)
+	var walk func(path string, b *bolt.Bucket) error
+	walk = func(path string, b *bolt.Bucket) error {
+		result[path+"|"] = ""
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return walk(path+"/"+string(k), b.Bucket(k))
+			}
+			result[path+"|"+string(k)] = string(v)
+			return nil
+		})
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return walk(string(name), b)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestExportImport(t *testing.T) {
+	src := openFixture(t)
+	var export bytes.Buffer
+	count, err := Export(src, &export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dump(t, src.Bolt)
+
+	// the target has an alert that isn't in the export and a bucket that isn't exported at all
+	dst := open(t, filepath.Join(tempDir(t), "target.db"))
+	stale := core.NewAlert("StatusCheckFailed", "i-2")
+	if err := dst.Save(stale); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Set("Other", "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := Import(dst, &export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != count {
+		t.Errorf("exported %d records but imported %d", count, imported)
+	}
+
+	got := dump(t, dst.Bolt)
+	var other string
+	if err := dst.Get("Other", "key", &other); err != nil || other != "value" {
+		t.Errorf("a bucket that isn't in the export should be kept, got %q %v", other, err)
+	}
+	for key := range got {
+		if strings.HasPrefix(key, "Other") {
+			delete(got, key)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the imported database isn't a copy of the exported one\ngot  %v\nwant %v", got, want)
+	}
+
+	// the alert that was only in the target is removed from both the data and the index
+	var alert core.Alert
+	if err := dst.One("ID", stale.ID, &alert); err != storm.ErrNotFound {
+		t.Errorf("expected the alert that isn't in the export to be removed, got %v", err)
+	}
+	var alerts []core.Alert
+	if err := dst.AllByIndex("LastUpdated", &alerts); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 3 {
+		t.Errorf("expected the 3 exported alerts in the LastUpdated index, got %d", len(alerts))
+	}
+	var silences []core.Silence
+	if err := dst.All(&silences); err != nil || len(silences) != 1 || silences[0].Comment != "planned work" {
+		t.Errorf("expected the silence to be imported, got %+v %v", silences, err)
+	}
+}
+
+func TestImportBatches(t *testing.T) {
+	src := open(t, filepath.Join(tempDir(t), "source.db"))
+	for i := 0; i < importBatchSize*2+1; i++ {
+		if err := src.Save(core.NewAlert("CPUCreditBalance", strings.Repeat("i", i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var export bytes.Buffer
+	if _, err := Export(src, &export); err != nil {
+		t.Fatal(err)
+	}
+	// the bucket is only cleared once, so the records from the earlier batches are kept
+	dst := open(t, filepath.Join(tempDir(t), "target.db"))
+	if _, err := Import(dst, &export); err != nil {
+		t.Fatal(err)
+	}
+	var alerts []core.Alert
+	if err := dst.All(&alerts); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != importBatchSize*2+1 {
+		t.Errorf("expected %d alerts, got %d", importBatchSize*2+1, len(alerts))
+	}
+}
+
+func TestExportBuckets(t *testing.T) {
+	db := openFixture(t)
+	var export bytes.Buffer
+	count, err := Export(db, &export, "Silence", "Empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(export.String()), "\n")
+	if count == 0 || count >= len(lines) {
+		t.Errorf("expected the silence with its storm metadata and the bucket records, got %d records in %d lines", count, len(lines))
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, `{"bucket":["Silence"`) && !strings.HasPrefix(line, `{"bucket":["Empty"`) {
+			t.Errorf("unexpected record %s", line)
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"not json\n", "line 1"},
+		{`{"bucket":["Alert"]}` + "\n\n" + `{"key":"a"}` + "\n", "line 3: record has no bucket"},
+	}
+	for _, test := range tests {
+		db := open(t, filepath.Join(tempDir(t), "import.db"))
+		_, err := Import(db, strings.NewReader(test.input))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Import(%q) = %v, expected an error containing %q", test.input, err, test.err)
+		}
+	}
+}
+
+func TestBackup(t *testing.T) {
+	db := openFixture(t)
+	path := filepath.Join(tempDir(t), "backup.db")
+	written, err := BackupFile(db, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != written {
+		t.Errorf("expected %d bytes in the backup, got %d", written, info.Size())
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file should be removed, got %v", err)
+	}
+	backup := open(t, path)
+	if got, want := dump(t, backup.Bolt), dump(t, db.Bolt); !reflect.DeepEqual(got, want) {
+		t.Errorf("the backup isn't a copy of the database\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	db := openFixture(t)
+	path := filepath.Join(tempDir(t), "compact.db")
+	if err := Compact(db, path); err != nil {
+		t.Fatal(err)
+	}
+	compacted := open(t, path)
+	if got, want := dump(t, compacted.Bolt), dump(t, db.Bolt); !reflect.DeepEqual(got, want) {
+		t.Errorf("the compacted database isn't a copy of the database\ngot  %v\nwant %v", got, want)
+	}
+}
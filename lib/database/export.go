@@ -0,0 +1,185 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+)
+
+// importBatchSize is the number of records written in each transaction during an import
+const importBatchSize = 1000
+
+// Record is one line in an export. A record without a key only declares that the bucket exists, which keeps empty
+// buckets intact through an export and import.
+type Record struct {
+	// Bucket is the path to the bucket from the root, nested buckets such as storm indexes have more than one element
+	Bucket []string `json:"bucket"`
+	// Key is set when the key is printable text, which is the case for most resource IDs
+	Key *string `json:"key,omitempty"`
+	// KeyBytes is set instead of Key for binary keys, such as auto incremented IDs
+	KeyBytes []byte `json:"key_bytes,omitempty"`
+	// Value is set when the stored value is valid JSON, which is the case for everything saved via storm
+	Value json.RawMessage `json:"value,omitempty"`
+	// ValueBytes is set instead of Value for any value that isn't JSON
+	ValueBytes []byte `json:"value_bytes,omitempty"`
+}
+
+// Export writes every bucket and key in the database as JSON lines to w. If buckets are given, only those root buckets
+// and their nested buckets are exported.
+func Export(db *storm.DB, w io.Writer, buckets ...string) (int, error) {
+	include := make(map[string]bool)
+	for _, name := range buckets {
+		include[name] = true
+	}
+
+	enc := json.NewEncoder(w)
+	count := 0
+	err := db.Bolt.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if len(include) > 0 && !include[string(name)] {
+				return nil
+			}
+			return exportBucket(enc, []string{string(name)}, b, &count)
+		})
+	})
+	return count, err
+}
+
+func exportBucket(enc *json.Encoder, path []string, b *bolt.Bucket, count *int) error {
+	if err := enc.Encode(&Record{Bucket: path}); err != nil {
+		return err
+	}
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested := make([]string, len(path), len(path)+1)
+			copy(nested, path)
+			return exportBucket(enc, append(nested, string(k)), b.Bucket(k), count)
+		}
+		r := &Record{Bucket: path}
+		if printable(k) {
+			key := string(k)
+			r.Key = &key
+		} else {
+			r.KeyBytes = k
+		}
+		if json.Valid(v) {
+			r.Value = v
+		} else {
+			r.ValueBytes = v
+		}
+		*count++
+		return enc.Encode(r)
+	})
+}
+
+// Import reads JSON lines as written by Export from r and stores them in the database. Every root bucket in the export
+// is cleared before it's written, so the records and the storm indexes in it are an exact copy of the exported ones.
+// Root buckets that aren't in the export are left as they are.
+func Import(db *storm.DB, r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	// storm values can be a lot larger than the default 64kb line limit
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	count := 0
+	line := 0
+	cleared := make(map[string]bool)
+	var batch []*Record
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		rec := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			return count, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(rec.Bucket) == 0 {
+			return count, fmt.Errorf("line %d: record has no bucket", line)
+		}
+		batch = append(batch, rec)
+		if len(batch) >= importBatchSize {
+			n, err := importBatch(db, batch, cleared)
+			count += n
+			if err != nil {
+				return count, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+	n, err := importBatch(db, batch, cleared)
+	return count + n, err
+}
+
+// importBatch writes the records in one transaction, a root bucket is deleted the first time it's seen in the import
+// and is added to cleared
+func importBatch(db *storm.DB, batch []*Record, cleared map[string]bool) (int, error) {
+	count := 0
+	deleted := make(map[string]bool)
+	err := db.Bolt.Update(func(tx *bolt.Tx) error {
+		for _, rec := range batch {
+			if name := rec.Bucket[0]; !cleared[name] && !deleted[name] {
+				if err := tx.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+				deleted[name] = true
+			}
+			b, err := tx.CreateBucketIfNotExists([]byte(rec.Bucket[0]))
+			if err != nil {
+				return err
+			}
+			for _, name := range rec.Bucket[1:] {
+				if b, err = b.CreateBucketIfNotExists([]byte(name)); err != nil {
+					return err
+				}
+			}
+			key := rec.KeyBytes
+			if rec.Key != nil {
+				key = []byte(*rec.Key)
+			}
+			if key == nil {
+				continue
+			}
+			value := []byte{}
+			if rec.Value != nil {
+				value = rec.Value
+			} else if rec.ValueBytes != nil {
+				value = rec.ValueBytes
+			}
+			if err := b.Put(key, value); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// the buckets are only cleared once the transaction that deleted them is committed
+	for name := range deleted {
+		cleared[name] = true
+	}
+	return count, nil
+}
+
+// printable returns true if the key can be represented as a JSON string without losing any bytes
+func printable(k []byte) bool {
+	if len(k) == 0 || !utf8.Valid(k) {
+		return false
+	}
+	for _, r := range string(k) {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,326 @@
+package digest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/stojg/aunt/lib/certificate"
+	"github.com/stojg/aunt/lib/core"
+	"github.com/stojg/aunt/lib/waste"
+)
+
+// Config holds when the digest is sent, what is in it and where it's sent
+type Config struct {
+	// Hour is the hour of the day in UTC that the digest is sent
+	Hour int
+	// Priorities are the priorities of the alerts that are sent in the digest instead of being notified, P4 and P5
+	// when not set
+	Priorities []string
+	// CertificateDays is how many days before expiry a certificate is listed in the digest, 30 when not set
+	CertificateDays int
+	Email           EmailConfig
+	Slack           SlackConfig
+}
+
+// EmailConfig holds the SMTP server and the addresses that the digest is emailed to
+type EmailConfig struct {
+	Host string
+	// Port is 587 when not set
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// SlackConfig holds the incoming webhook that the digest is posted to
+type SlackConfig struct {
+	WebhookURL string
+}
+
+// Digest is a summary of the low priority findings
+type Digest struct {
+	Created      time.Time
+	Certificates []certificate.Certificate
+	Waste        []waste.Finding
+	Alerts       []core.Alert
+}
+
+// State remembers when the digest was last sent
+type State struct {
+	ID int `storm:"id"`
+	// LastSent is when the digest was last sent to any channel
+	LastSent time.Time
+	// Channels are when the digest was last sent by email and to Slack, so that a channel that failed can be retried
+	// without sending it again to the others
+	Channels map[string]time.Time
+}
+
+// stateID is the ID of the only State record
+const stateID = 1
+
+// channels that the digest is sent to
+const (
+	channelEmail = "email"
+	channelSlack = "slack"
+)
+
+var settings = Config{
+	Priorities:      []string{core.P4, core.P5},
+	CertificateDays: 30,
+	Email:           EmailConfig{Port: 587},
+}
+
+// Configure overrides the default settings, the digest alert priorities are only used when the digest is sent
+// somewhere, otherwise those alerts are notified as usual
+func Configure(cfg Config) error {
+	if cfg.Hour < 0 || cfg.Hour > 23 {
+		return fmt.Errorf("digest hour must be between 0 and 23: %d", cfg.Hour)
+	}
+	if cfg.Email.Host != "" && (cfg.Email.From == "" || len(cfg.Email.To) == 0) {
+		return fmt.Errorf("digest email needs From and To addresses")
+	}
+	settings.Hour = cfg.Hour
+	if len(cfg.Priorities) > 0 {
+		settings.Priorities = cfg.Priorities
+	}
+	if cfg.CertificateDays > 0 {
+		settings.CertificateDays = cfg.CertificateDays
+	}
+	port := settings.Email.Port
+	settings.Email = cfg.Email
+	if settings.Email.Port == 0 {
+		settings.Email.Port = port
+	}
+	settings.Slack = cfg.Slack
+	if !Enabled() {
+		return core.SetDigestPriorities(nil)
+	}
+	return core.SetDigestPriorities(settings.Priorities)
+}
+
+// Enabled returns true when the digest is sent by email or to Slack
+func Enabled() bool {
+	return settings.Email.Host != "" || settings.Slack.WebhookURL != ""
+}
+
+// Build collects the certificates that are about to expire, the waste findings and the alerts for the digest
+func Build(db *storm.DB) (*Digest, error) {
+	now := time.Now()
+	d := &Digest{Created: now}
+
+	var certificates []certificate.Certificate
+	if err := db.Select(q.Gte("LastUpdated", now.Add(-core.MaxResourceAge))).Find(&certificates); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	limit := now.AddDate(0, 0, settings.CertificateDays)
+	for _, cert := range certificates {
+		if cert.NotAfter != nil && cert.NotAfter.Before(limit) {
+			d.Certificates = append(d.Certificates, cert)
+		}
+	}
+	sort.Slice(d.Certificates, func(i, j int) bool { return d.Certificates[i].NotAfter.Before(*d.Certificates[j].NotAfter) })
+
+	findings, err := waste.Report(db, nil)
+	if err != nil {
+		return nil, err
+	}
+	d.Waste = findings
+
+	var alerts []core.Alert
+	query := db.Select(q.Eq("Digested", true), q.Gte("LastUpdated", now.Add(-core.AlertExpiry)))
+	if err := query.Find(&alerts); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	for _, alert := range alerts {
+		// these are already listed as waste and certificates
+		if strings.HasPrefix(alert.Name, "Waste.") || strings.HasPrefix(alert.Name, "CertificateExpiry") {
+			continue
+		}
+		d.Alerts = append(d.Alerts, alert)
+	}
+	sort.Slice(d.Alerts, func(i, j int) bool {
+		a, b := d.Alerts[i], d.Alerts[j]
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.ID < b.ID
+	})
+	return d, nil
+}
+
+// String returns the digest as plain text
+func (d *Digest) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "aunt digest for %s\n", d.Created.UTC().Format("2006-01-02"))
+	if len(d.Certificates) > 0 {
+		fmt.Fprintf(&b, "\nCertificates expiring within %d days (%d)\n", settings.CertificateDays, len(d.Certificates))
+		for _, c := range d.Certificates {
+			fmt.Fprintf(&b, "- %s expires %s (%s %s)\n", c.Name, c.NotAfter.Format("2006-01-02"), c.Account, c.Region)
+		}
+	}
+	if len(d.Waste) > 0 {
+		fmt.Fprintf(&b, "\nWaste (%d)\n", len(d.Waste))
+		for _, f := range d.Waste {
+			fmt.Fprintf(&b, "- %s %s: %s (%s %s)\n", f.Kind, f.Name, f.Reason, f.Account, f.Region)
+		}
+	}
+	if len(d.Alerts) > 0 {
+		fmt.Fprintf(&b, "\nLow priority alerts (%d)\n", len(d.Alerts))
+		for _, a := range d.Alerts {
+			fmt.Fprintf(&b, "- [%s] %s (%s)", a.Priority, a.Message, a.Entity)
+			if teams := a.Teams(); len(teams) > 0 {
+				fmt.Fprintf(&b, " for %s", strings.Join(teams, ", "))
+			}
+			if tags := a.NotifyTags(); len(tags) > 0 {
+				fmt.Fprintf(&b, " [%s]", strings.Join(tags, ", "))
+			}
+			b.WriteString("\n")
+		}
+	}
+	if len(d.Certificates) == 0 && len(d.Waste) == 0 && len(d.Alerts) == 0 {
+		b.WriteString("\nNothing to report\n")
+	}
+	return b.String()
+}
+
+// Update sends the digest to each channel that hasn't been sent it since the last scheduled time, a channel that fails
+// is retried on the next update
+func Update(db *storm.DB) error {
+	if !Enabled() {
+		return nil
+	}
+	state, err := loadState(db)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	scheduled := time.Date(now.Year(), now.Month(), now.Day(), settings.Hour, 0, 0, 0, time.UTC)
+	if now.Before(scheduled) {
+		scheduled = scheduled.AddDate(0, 0, -1)
+	}
+	var due []string
+	for _, channel := range channels() {
+		if !state.sent(channel).After(scheduled) {
+			due = append(due, channel)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	return send(db, state, due)
+}
+
+// Send builds and sends the digest to all channels now
+func Send(db *storm.DB) error {
+	if !Enabled() {
+		return fmt.Errorf("the digest isn't configured to be sent by email or to Slack")
+	}
+	state, err := loadState(db)
+	if err != nil {
+		return err
+	}
+	return send(db, state, channels())
+}
+
+// send sends the digest to the channels and records when it was sent to each channel that succeeded
+func send(db *storm.DB, state *State, to []string) error {
+	d, err := Build(db)
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("aunt digest for %s", d.Created.UTC().Format("2006-01-02"))
+	body := d.String()
+	var failed []string
+	for _, channel := range to {
+		var err error
+		switch channel {
+		case channelEmail:
+			err = sendEmail(subject, body)
+		case channelSlack:
+			err = sendSlack(body)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", channel, err))
+			continue
+		}
+		fmt.Printf("Sent %s by %s\n", subject, channel)
+		state.Channels[channel] = d.Created
+		state.LastSent = d.Created
+	}
+	if err := db.Save(state); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("error during digest send: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// channels returns the channels that the digest is configured to be sent to
+func channels() []string {
+	var enabled []string
+	if settings.Email.Host != "" {
+		enabled = append(enabled, channelEmail)
+	}
+	if settings.Slack.WebhookURL != "" {
+		enabled = append(enabled, channelSlack)
+	}
+	return enabled
+}
+
+func loadState(db *storm.DB) (*State, error) {
+	state := &State{ID: stateID}
+	if err := db.One("ID", stateID, state); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	if state.Channels == nil {
+		state.Channels = make(map[string]time.Time)
+	}
+	return state, nil
+}
+
+// sent returns when the digest was last sent to the channel, states that were saved before the channels were recorded
+// were only saved when all channels succeeded
+func (s *State) sent(channel string) time.Time {
+	if len(s.Channels) == 0 {
+		return s.LastSent
+	}
+	return s.Channels[channel]
+}
+
+func sendEmail(subject, body string) error {
+	cfg := settings.Email
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		cfg.From, strings.Join(cfg.To, ", "), subject, strings.Replace(body, "\n", "\r\n", -1))
+	return smtp.SendMail(fmt.Sprintf("%s:%d", cfg.Host, cfg.Port), auth, cfg.From, cfg.To, []byte(msg))
+}
+
+func sendSlack(body string) error {
+	payload, err := json.Marshal(map[string]string{"text": "```" + body + "```"})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(settings.Slack.WebhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
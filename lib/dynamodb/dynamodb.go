@@ -7,6 +7,7 @@ import (
 
 	"github.com/asdine/storm"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/stojg/aunt/lib/core"
@@ -14,26 +15,75 @@ import (
 
 // Table is a app specific representation of a dynamodb table
 type Table struct {
-	Name          string
-	ResourceID    string `storm:"id"`
-	LaunchTime    *time.Time
-	Region        string
-	Account       string
-	LastUpdated   time.Time
-	Metrics       map[string]*float64
-	Entries       int64
+	Name        string
+	ResourceID  string `storm:"id"`
+	LaunchTime  *time.Time
+	Region      string
+	Account     string
+	LastUpdated time.Time
+	Metrics     map[string]*float64
+	Entries     int64
+	// BillingMode is PROVISIONED or PAY_PER_REQUEST, on demand tables has no provisioned capacity
+	BillingMode   string
 	WriteCapacity int64
 	ReadCapacity  int64
+	// ReadAutoScaling and WriteAutoScaling are set when the capacity is managed by Application Auto Scaling
+	ReadAutoScaling  *AutoScaling
+	WriteAutoScaling *AutoScaling
+	// Indexes are the names of the global secondary indexes
+	Indexes []string
+	Tags    map[string]string
 }
 
+// GlobalSecondaryIndex is a app specific representation of a global secondary index on a dynamodb table, it has its own
+// provisioned capacity and can be throttled independently of the table
+type GlobalSecondaryIndex struct {
+	Name string
+	// ResourceID is the ARN of the index, index names are only unique within a table and table names within an account
+	// and region
+	ResourceID       string `storm:"id"`
+	TableName        string `storm:"index"`
+	Status           string
+	Region           string
+	Account          string
+	LastUpdated      time.Time
+	Metrics          map[string]*float64
+	Entries          int64
+	WriteCapacity    int64
+	ReadCapacity     int64
+	ReadAutoScaling  *AutoScaling
+	WriteAutoScaling *AutoScaling
+	// Tags are the tags of the table, indexes can't be tagged
+	Tags map[string]string
+}
+
+// AutoScaling is the capacity range of an Application Auto Scaling target
+type AutoScaling struct {
+	MinCapacity int64
+	MaxCapacity int64
+}
+
+// Billing modes, the SDK doesn't know about on demand tables yet so these are reported with no provisioned capacity
+const (
+	BillingModeProvisioned   = "PROVISIONED"
+	BillingModePayPerRequest = "PAY_PER_REQUEST"
+)
+
 const (
 	readThrottleEvents  = "ReadThrottleEvents"
 	writeThrottleEvents = "WriteThrottleEvents"
+	consumedRead        = "ConsumedReadCapacityUnits"
+	consumedWrite       = "ConsumedWriteCapacityUnits"
+	readUtilization     = "ReadCapacityUtilization"
+	writeUtilization    = "WriteCapacityUtilization"
 )
 
 const (
 	readThrottleEventsThreshold  float64 = 10
 	writeThrottleEventsThreshold float64 = 10
+	// utilizationThreshold is the consumed capacity in percent of the provisioned capacity, or of the auto scaling max
+	// capacity, where an alert is raised
+	utilizationThreshold float64 = 80
 )
 
 var metrics = []string{readThrottleEvents, writeThrottleEvents}
@@ -66,6 +116,11 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 			return
 		}
 
+		targets, err := scalableTargets(applicationautoscaling.New(sess, config))
+		if err != nil {
+			fmt.Printf("applicationautoscaling.DescribeScalableTargets %s %s %v\n", role, region, err)
+		}
+
 		for _, tableName := range resp.TableNames {
 			data, err := svc.DescribeTable(&dynamodb.DescribeTableInput{TableName: tableName})
 			if err != nil {
@@ -74,16 +129,22 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 			}
 
 			table := &Table{
-				Name:          *tableName,
-				ResourceID:    *tableName,
-				LaunchTime:    data.Table.CreationDateTime,
-				Entries:       *data.Table.ItemCount,
-				Region:        *config.Region,
-				Account:       account,
-				LastUpdated:   time.Now(),
-				Metrics:       make(map[string]*float64),
-				WriteCapacity: *data.Table.ProvisionedThroughput.WriteCapacityUnits,
-				ReadCapacity:  *data.Table.ProvisionedThroughput.ReadCapacityUnits,
+				Name:             *tableName,
+				ResourceID:       *tableName,
+				LaunchTime:       data.Table.CreationDateTime,
+				Entries:          aws.Int64Value(data.Table.ItemCount),
+				Region:           *config.Region,
+				Account:          account,
+				LastUpdated:      time.Now(),
+				Metrics:          make(map[string]*float64),
+				BillingMode:      billingMode(data.Table.ProvisionedThroughput),
+				ReadAutoScaling:  targets["table/"+*tableName+applicationautoscaling.ScalableDimensionDynamodbTableReadCapacityUnits],
+				WriteAutoScaling: targets["table/"+*tableName+applicationautoscaling.ScalableDimensionDynamodbTableWriteCapacityUnits],
+				Tags:             tags(svc, data.Table.TableArn),
+			}
+			if throughput := data.Table.ProvisionedThroughput; throughput != nil {
+				table.ReadCapacity = aws.Int64Value(throughput.ReadCapacityUnits)
+				table.WriteCapacity = aws.Int64Value(throughput.WriteCapacityUnits)
 			}
 
 			dimensions := []*cloudwatch.Dimension{{Name: aws.String("TableName"), Value: tableName}}
@@ -91,34 +152,233 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 			for _, name := range metrics {
 				table.Metrics[name] = metric("AWS/DynamoDB", dimensions, name, cw)
 			}
+			consumption(cw, dimensions, table.Metrics, table.ReadCapacity, table.WriteCapacity)
+			for _, gsi := range data.Table.GlobalSecondaryIndexes {
+				table.Indexes = append(table.Indexes, aws.StringValue(gsi.IndexName))
+			}
 			if err := db.Save(table); err != nil {
 				fmt.Printf("%+v\n", err)
 			}
+			anomalies, _, err := core.Anomalies(db, "Table", table.ResourceID, table.Metrics)
+			if err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			if err := core.RecordMetrics(db, "Table", table.ResourceID, table.Metrics); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			for _, alert := range anomalies {
+				alert.Message = fmt.Sprintf("%s for %s", alert.Message, table.ResourceID)
+				alert.Details["account"] = table.Account
+				alert.Details["region"] = table.Region
+				alert.Details["resource_id"] = table.ResourceID
+				alert.ResourceTags = table.Tags
+				saveAlert(db, alert)
+			}
 
 			// check metrics
 			throttledReads := table.Metrics[readThrottleEvents]
 			if throttledReads != nil && *throttledReads > readThrottleEventsThreshold {
-				alert := core.NewAlert(readThrottleEvents, table.ResourceID)
+				alert := newAlert(readThrottleEvents, table.ResourceID, table.Account, table.Region, table.Tags)
 				alert.Message = fmt.Sprintf("Throttled reads (%.1f) is above %.1f for %s", *throttledReads, readThrottleEventsThreshold, table.ResourceID)
-				alert.Details["account"] = table.Account
-				alert.Details["region"] = table.Region
-				alert.Details["resource_id"] = table.ResourceID
-				if err := alert.Save(db); err != nil {
-					fmt.Printf("%+v\n", err)
-				}
+				throttleAlert(db, alert, table.ReadCapacity, table.ReadAutoScaling)
 			}
 			throttledWrites := table.Metrics[writeThrottleEvents]
 			if throttledWrites != nil && *throttledWrites > writeThrottleEventsThreshold {
-				alert := core.NewAlert(writeThrottleEvents, table.ResourceID)
+				alert := newAlert(writeThrottleEvents, table.ResourceID, table.Account, table.Region, table.Tags)
 				alert.Message = fmt.Sprintf("Throttled writes (%.1f) is above %.1f for %s", *throttledWrites, writeThrottleEventsThreshold, table.ResourceID)
-				alert.Details["account"] = table.Account
-				alert.Details["region"] = table.Region
-				alert.Details["resource_id"] = table.ResourceID
-				if err := alert.Save(db); err != nil {
-					fmt.Printf("%+v\n", err)
-				}
+				throttleAlert(db, alert, table.WriteCapacity, table.WriteAutoScaling)
+			}
+			checkUtilization(db, table.ResourceID, table.Name, table.Account, table.Region, table.Tags, table.Metrics, table.ReadCapacity, table.WriteCapacity, table.ReadAutoScaling, table.WriteAutoScaling)
+
+			for _, data := range data.Table.GlobalSecondaryIndexes {
+				updateIndex(db, cw, table, data, targets)
+			}
+		}
+	}
+}
+
+func updateIndex(db *storm.DB, cw *cloudwatch.CloudWatch, table *Table, data *dynamodb.GlobalSecondaryIndexDescription, targets map[string]*AutoScaling) {
+	resource := "table/" + table.Name + "/index/" + *data.IndexName
+	index := &GlobalSecondaryIndex{
+		Name:             *data.IndexName,
+		ResourceID:       aws.StringValue(data.IndexArn),
+		TableName:        table.Name,
+		Status:           aws.StringValue(data.IndexStatus),
+		Region:           table.Region,
+		Account:          table.Account,
+		Tags:             table.Tags,
+		LastUpdated:      time.Now(),
+		Metrics:          make(map[string]*float64),
+		Entries:          aws.Int64Value(data.ItemCount),
+		ReadAutoScaling:  targets[resource+applicationautoscaling.ScalableDimensionDynamodbIndexReadCapacityUnits],
+		WriteAutoScaling: targets[resource+applicationautoscaling.ScalableDimensionDynamodbIndexWriteCapacityUnits],
+	}
+	if index.ResourceID == "" {
+		index.ResourceID = fmt.Sprintf("%s/%s/%s", table.Account, table.Region, resource)
+	}
+	if throughput := data.ProvisionedThroughput; throughput != nil {
+		index.ReadCapacity = aws.Int64Value(throughput.ReadCapacityUnits)
+		index.WriteCapacity = aws.Int64Value(throughput.WriteCapacityUnits)
+	}
+
+	dimensions := []*cloudwatch.Dimension{
+		{Name: aws.String("TableName"), Value: aws.String(table.Name)},
+		{Name: aws.String("GlobalSecondaryIndexName"), Value: data.IndexName},
+	}
+	for _, name := range metrics {
+		index.Metrics[name] = metric("AWS/DynamoDB", dimensions, name, cw)
+	}
+	consumption(cw, dimensions, index.Metrics, index.ReadCapacity, index.WriteCapacity)
+	if err := db.Save(index); err != nil {
+		fmt.Printf("%+v\n", err)
+	}
+	if err := core.RecordMetrics(db, "GlobalSecondaryIndex", index.ResourceID, index.Metrics); err != nil {
+		fmt.Printf("%+v\n", err)
+	}
+
+	// throttled writes on an index also throttles the writes to the table
+	throttledReads := index.Metrics[readThrottleEvents]
+	if throttledReads != nil && *throttledReads > readThrottleEventsThreshold {
+		alert := newAlert(readThrottleEvents, index.ResourceID, index.Account, index.Region, index.Tags)
+		alert.Message = fmt.Sprintf("Throttled reads (%.1f) is above %.1f for index %s", *throttledReads, readThrottleEventsThreshold, index.Label())
+		throttleAlert(db, alert, index.ReadCapacity, index.ReadAutoScaling)
+	}
+	throttledWrites := index.Metrics[writeThrottleEvents]
+	if throttledWrites != nil && *throttledWrites > writeThrottleEventsThreshold {
+		alert := newAlert(writeThrottleEvents, index.ResourceID, index.Account, index.Region, index.Tags)
+		alert.Message = fmt.Sprintf("Throttled writes (%.1f) is above %.1f for index %s", *throttledWrites, writeThrottleEventsThreshold, index.Label())
+		throttleAlert(db, alert, index.WriteCapacity, index.WriteAutoScaling)
+	}
+	checkUtilization(db, index.ResourceID, index.Label(), index.Account, index.Region, index.Tags, index.Metrics, index.ReadCapacity, index.WriteCapacity, index.ReadAutoScaling, index.WriteAutoScaling)
+}
+
+// Label returns the table name and the index name, e.g. orders/by-customer
+func (i *GlobalSecondaryIndex) Label() string {
+	return i.TableName + "/" + i.Name
+}
+
+// billingMode guesses the billing mode from the provisioned throughput, since the SDK doesn't return it
+func billingMode(throughput *dynamodb.ProvisionedThroughputDescription) string {
+	if throughput == nil || (aws.Int64Value(throughput.ReadCapacityUnits) == 0 && aws.Int64Value(throughput.WriteCapacityUnits) == 0) {
+		return BillingModePayPerRequest
+	}
+	return BillingModeProvisioned
+}
+
+// consumption sets the consumed capacity and the utilization of the provisioned capacity
+func consumption(cw *cloudwatch.CloudWatch, dimensions []*cloudwatch.Dimension, values map[string]*float64, read, write int64) {
+	// consumed capacity is a sum over the period, convert it to units per second like the provisioned capacity
+	for _, name := range []string{consumedRead, consumedWrite} {
+		if sum := core.Metric(cw, "AWS/DynamoDB", dimensions, name, cloudwatch.StatisticSum); sum != nil {
+			values[name] = aws.Float64(*sum / core.MetricWindow.Seconds())
+		}
+	}
+	if consumed := values[consumedRead]; consumed != nil && read > 0 {
+		values[readUtilization] = aws.Float64(*consumed / float64(read) * 100)
+	}
+	if consumed := values[consumedWrite]; consumed != nil && write > 0 {
+		values[writeUtilization] = aws.Float64(*consumed / float64(write) * 100)
+	}
+}
+
+// checkUtilization alerts when the consumed capacity gets close to what can be provisioned. For auto scaled capacity
+// that is the max capacity, since auto scaling will raise the provisioned capacity until then.
+func checkUtilization(db *storm.DB, resourceID, label, account, region string, tags map[string]string, values map[string]*float64, read, write int64, readScaling, writeScaling *AutoScaling) {
+	check := func(name, consumedName, kind string, provisioned int64, scaling *AutoScaling) {
+		consumed := values[consumedName]
+		ceiling := provisioned
+		if scaling != nil {
+			ceiling = scaling.MaxCapacity
+		}
+		if consumed == nil || ceiling <= 0 {
+			return
+		}
+		percent := *consumed / float64(ceiling) * 100
+		if percent <= utilizationThreshold {
+			return
+		}
+		alert := newAlert(name, resourceID, account, region, tags)
+		if scaling != nil {
+			alert.Message = fmt.Sprintf("Consumed %s capacity (%.0f%%) is close to the auto scaling max of %d for %s", kind, percent, ceiling, label)
+			alert.Details["auto_scaling_max"] = fmt.Sprintf("%d", ceiling)
+		} else {
+			alert.Message = fmt.Sprintf("Consumed %s capacity (%.0f%%) is close to the provisioned %d for %s", kind, percent, ceiling, label)
+		}
+		alert.Details["provisioned"] = fmt.Sprintf("%d", provisioned)
+		alert.Details["consumed"] = fmt.Sprintf("%.1f", *consumed)
+		saveAlert(db, alert)
+	}
+	check(readUtilization, consumedRead, "read", read, readScaling)
+	check(writeUtilization, consumedWrite, "write", write, writeScaling)
+}
+
+// throttleAlert lowers the priority of a throttle alert when auto scaling still can raise the capacity, the throttling
+// should then go away by itself
+func throttleAlert(db *storm.DB, alert *core.Alert, provisioned int64, scaling *AutoScaling) {
+	if scaling != nil {
+		alert.Details["auto_scaling_max"] = fmt.Sprintf("%d", scaling.MaxCapacity)
+		if provisioned < scaling.MaxCapacity {
+			alert.Priority = core.P4
+			alert.Description = fmt.Sprintf("Auto scaling can raise the capacity from %d to %d", provisioned, scaling.MaxCapacity)
+		}
+	}
+	alert.Details["provisioned"] = fmt.Sprintf("%d", provisioned)
+	saveAlert(db, alert)
+}
+
+// scalableTargets returns the DynamoDB auto scaling targets keyed by the resource id and the scalable dimension
+func scalableTargets(svc *applicationautoscaling.ApplicationAutoScaling) (map[string]*AutoScaling, error) {
+	targets := make(map[string]*AutoScaling)
+	input := &applicationautoscaling.DescribeScalableTargetsInput{
+		ServiceNamespace: aws.String(applicationautoscaling.ServiceNamespaceDynamodb),
+	}
+	err := svc.DescribeScalableTargetsPages(input, func(page *applicationautoscaling.DescribeScalableTargetsOutput, lastPage bool) bool {
+		for _, target := range page.ScalableTargets {
+			targets[*target.ResourceId+*target.ScalableDimension] = &AutoScaling{
+				MinCapacity: aws.Int64Value(target.MinCapacity),
+				MaxCapacity: aws.Int64Value(target.MaxCapacity),
 			}
 		}
+		return true
+	})
+	return targets, err
+}
+
+func newAlert(name, resourceID, account, region string, tags map[string]string) *core.Alert {
+	alert := core.NewAlert(name, resourceID)
+	alert.Details["account"] = account
+	alert.Details["region"] = region
+	alert.Details["resource_id"] = resourceID
+	alert.ResourceTags = tags
+	return alert
+}
+
+// tags returns the tags of a table
+func tags(svc *dynamodb.DynamoDB, arn *string) map[string]string {
+	result := make(map[string]string)
+	if arn == nil {
+		return result
+	}
+	input := &dynamodb.ListTagsOfResourceInput{ResourceArn: arn}
+	for {
+		resp, err := svc.ListTagsOfResource(input)
+		if err != nil {
+			fmt.Printf("dynamodb.ListTagsOfResource %s %v\n", *arn, err)
+			return result
+		}
+		for _, tag := range resp.Tags {
+			result[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		if resp.NextToken == nil {
+			return result
+		}
+		input.NextToken = resp.NextToken
+	}
+}
+
+func saveAlert(db *storm.DB, alert *core.Alert) {
+	if err := alert.Save(db); err != nil {
+		fmt.Printf("%+v\n", err)
 	}
 }
 
@@ -15,18 +15,22 @@ import (
 
 // Volume is an app specific representation of a EBS volume
 type Volume struct {
-	Name        string
-	ResourceID  string `storm:"id"`
-	InstanceID  string
-	LaunchTime  *time.Time
-	Region      string
-	Account     string
-	Size        int64
-	IOPS        *int64
-	Attached    bool
-	State       string
-	LastUpdated time.Time
-	Metrics     map[string]*float64
+	Name       string
+	ResourceID string `storm:"id"`
+	InstanceID string
+	LaunchTime *time.Time
+	Region     string
+	Account    string
+	Size       int64
+	VolumeType string
+	IOPS       *int64
+	Attached   bool
+	// DetachedSince is when the volume was first seen unattached, it's not set while the volume is attached
+	DetachedSince *time.Time
+	State         string
+	Tags          map[string]string
+	LastUpdated   time.Time
+	Metrics       map[string]*float64
 }
 
 const (
@@ -76,6 +80,9 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 				Region:      *config.Region,
 				Account:     account,
 				Size:        *data.Size,
+				VolumeType:  aws.StringValue(data.VolumeType),
+				State:       aws.StringValue(data.State),
+				Tags:        core.Tags(data.Tags),
 				LastUpdated: time.Now(),
 				Metrics:     make(map[string]*float64),
 			}
@@ -88,6 +95,10 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 				}
 			}
 
+			if !volume.Attached {
+				volume.DetachedSince = detachedSince(db, volume)
+			}
+
 			// some volumes aren't tagged with a name, try grab it from the attached instance
 			if volume.Name == "" && volume.Attached {
 				var inst auntec2.Instance
@@ -108,9 +119,30 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 			for _, name := range metrics {
 				volume.Metrics[name] = metric("AWS/EBS", dimensions, name, cw)
 			}
+			if err := core.Forecast(db, "Volume", volume.ResourceID, metricBurstBalance, volume.Metrics); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
 			if err := db.Save(volume); err != nil {
 				fmt.Printf("%+v\n", err)
 			}
+			anomalies, _, err := core.Anomalies(db, "Volume", volume.ResourceID, volume.Metrics)
+			if err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			if err := core.RecordMetrics(db, "Volume", volume.ResourceID, volume.Metrics); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			for _, alert := range anomalies {
+				alert.Message = fmt.Sprintf("%s for volume %s", alert.Message, volume.Name)
+				alert.Details["account"] = volume.Account
+				alert.Details["region"] = volume.Region
+				alert.Details["resource_id"] = volume.ResourceID
+				alert.ResourceTags = volume.Tags
+				alert.Details["attached_to"] = volume.InstanceID
+				if err := alert.Save(db); err != nil {
+					fmt.Printf("%+v\n", err)
+				}
+			}
 
 			// check metrics
 			balance := volume.Metrics[metricBurstBalance]
@@ -120,6 +152,7 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 				alert.Details["account"] = volume.Account
 				alert.Details["region"] = volume.Region
 				alert.Details["resource_id"] = volume.ResourceID
+				alert.ResourceTags = volume.Tags
 				alert.Details["iops"] = fmt.Sprintf("%d", *volume.IOPS)
 				alert.Details["size"] = fmt.Sprintf("%d", volume.Size)
 				alert.Details["attached_to"] = volume.InstanceID
@@ -127,10 +160,36 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 					fmt.Printf("%+v\n", err)
 				}
 			}
+			for _, alert := range core.ForecastAlerts(volume.ResourceID, volume.Metrics) {
+				alert.Message = fmt.Sprintf("%s for volume %s", alert.Message, volume.Name)
+				alert.Details["account"] = volume.Account
+				alert.Details["region"] = volume.Region
+				alert.Details["resource_id"] = volume.ResourceID
+				alert.ResourceTags = volume.Tags
+				alert.Details["size"] = fmt.Sprintf("%d", volume.Size)
+				alert.Details["attached_to"] = volume.InstanceID
+				if err := alert.Save(db); err != nil {
+					fmt.Printf("%+v\n", err)
+				}
+			}
 		}
 	}
 }
 
+// detachedSince returns when the volume was first seen unattached, a volume that was attached or not stored during the
+// last update is detached from now since there is no way of telling when it happened
+func detachedSince(db *storm.DB, volume *Volume) *time.Time {
+	var previous Volume
+	err := db.One("ResourceID", volume.ResourceID, &previous)
+	if err != nil && err != storm.ErrNotFound {
+		fmt.Printf("Error during volume lookup: %+v\n", err)
+	}
+	if err == nil && !previous.Attached && previous.DetachedSince != nil {
+		return previous.DetachedSince
+	}
+	return aws.Time(volume.LastUpdated)
+}
+
 func metric(namespace string, dimensions []*cloudwatch.Dimension, metricName string, cw *cloudwatch.CloudWatch) *float64 {
 	input := &cloudwatch.GetMetricStatisticsInput{
 		Namespace:  aws.String(namespace),
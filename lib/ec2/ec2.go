@@ -2,6 +2,7 @@ package ec2
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,8 +22,23 @@ type Instance struct {
 	Account      string
 	InstanceType string
 	State        string
-	LastUpdated  time.Time
-	Metrics      map[string]*float64
+	Tags         map[string]string
+	// SystemStatus is the result of the AWS system status check, e.g. ok or impaired
+	SystemStatus string
+	// InstanceStatus is the result of the instance status check, e.g. ok or impaired
+	InstanceStatus string
+	// Events are scheduled maintenance events such as reboots and retirements
+	Events      []Event
+	LastUpdated time.Time
+	Metrics     map[string]*float64
+}
+
+// Event is a scheduled event for an EC2 instance
+type Event struct {
+	Code        string
+	Description string
+	NotBefore   *time.Time
+	NotAfter    *time.Time
 }
 
 const (
@@ -35,6 +51,10 @@ const (
 	metricsCPUThreshold     float64 = 90.0
 )
 
+const (
+	statusImpaired = "impaired"
+)
+
 var metrics = []string{metricCredits, metricsCPU}
 
 // Update will update the database with Instance data
@@ -72,6 +92,17 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 			return
 		}
 
+		statuses := make(map[string]*ec2.InstanceStatus)
+		err = svc.DescribeInstanceStatusPages(&ec2.DescribeInstanceStatusInput{}, func(page *ec2.DescribeInstanceStatusOutput, lastPage bool) bool {
+			for _, status := range page.InstanceStatuses {
+				statuses[*status.InstanceId] = status
+			}
+			return true
+		})
+		if err != nil {
+			fmt.Printf("ec2.describeInstanceStatus %s %s %v\n", role, region, err)
+		}
+
 		for idx := range resp.Reservations {
 			for _, i := range resp.Reservations[idx].Instances {
 				instance := &Instance{
@@ -82,17 +113,42 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 					InstanceType: *i.InstanceType,
 					LaunchTime:   i.LaunchTime,
 					State:        *i.State.Name,
+					Tags:         core.Tags(i.Tags),
 					LastUpdated:  time.Now(),
 					Metrics:      make(map[string]*float64),
 				}
+				if status, ok := statuses[instance.ResourceID]; ok {
+					setStatus(instance, status)
+				}
+
 				dimensions := []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: i.InstanceId}}
 
 				for _, name := range metrics {
 					instance.Metrics[name] = metric("AWS/EC2", dimensions, name, cw)
 				}
+				if err := core.Forecast(db, "Instance", instance.ResourceID, metricCredits, instance.Metrics); err != nil {
+					fmt.Printf("%+v\n", err)
+				}
 				if err := db.Save(instance); err != nil {
 					fmt.Printf("%+v\n", err)
 				}
+				anomalies, _, err := core.Anomalies(db, "Instance", instance.ResourceID, instance.Metrics)
+				if err != nil {
+					fmt.Printf("%+v\n", err)
+				}
+				if err := core.RecordMetrics(db, "Instance", instance.ResourceID, instance.Metrics); err != nil {
+					fmt.Printf("%+v\n", err)
+				}
+				for _, alert := range anomalies {
+					alert.Message = fmt.Sprintf("%s for %s", alert.Message, instance.Name)
+					alert.Details["account"] = instance.Account
+					alert.Details["region"] = instance.Region
+					alert.Details["resource_id"] = instance.ResourceID
+					alert.ResourceTags = instance.Tags
+					if err := alert.Save(db); err != nil {
+						fmt.Printf("%+v\n", err)
+					}
+				}
 				// check metrics
 				credits := instance.Metrics[metricCredits]
 				if credits != nil && *credits < metricsCreditsThreshold {
@@ -101,17 +157,30 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 					alert.Details["account"] = instance.Account
 					alert.Details["region"] = instance.Region
 					alert.Details["resource_id"] = instance.ResourceID
+					alert.ResourceTags = instance.Tags
 					if err := alert.Save(db); err != nil {
 						fmt.Printf("%+v\n", err)
 					}
 				}
 				cpu := instance.Metrics[metricsCPU]
 				if cpu != nil && *cpu > metricsCPUThreshold {
-					alert := core.NewAlert(metricCredits, instance.ResourceID)
+					alert := core.NewAlert(metricsCPU, instance.ResourceID)
 					alert.Message = fmt.Sprintf("CPU Utilisation (%.1f) is above %.1f for %s", *cpu, metricsCPUThreshold, instance.Name)
 					alert.Details["account"] = instance.Account
 					alert.Details["region"] = instance.Region
 					alert.Details["resource_id"] = instance.ResourceID
+					alert.ResourceTags = instance.Tags
+					if err := alert.Save(db); err != nil {
+						fmt.Printf("%+v\n", err)
+					}
+				}
+				checkStatus(db, instance)
+				for _, alert := range core.ForecastAlerts(instance.ResourceID, instance.Metrics) {
+					alert.Message = fmt.Sprintf("%s for %s", alert.Message, instance.Name)
+					alert.Details["account"] = instance.Account
+					alert.Details["region"] = instance.Region
+					alert.Details["resource_id"] = instance.ResourceID
+					alert.ResourceTags = instance.Tags
 					if err := alert.Save(db); err != nil {
 						fmt.Printf("%+v\n", err)
 					}
@@ -121,6 +190,66 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 	}
 }
 
+func setStatus(instance *Instance, status *ec2.InstanceStatus) {
+	if status.SystemStatus != nil {
+		instance.SystemStatus = aws.StringValue(status.SystemStatus.Status)
+	}
+	if status.InstanceStatus != nil {
+		instance.InstanceStatus = aws.StringValue(status.InstanceStatus.Status)
+	}
+	for _, e := range status.Events {
+		description := aws.StringValue(e.Description)
+		// events that has already happened or been cancelled are still listed for a while
+		if strings.HasPrefix(description, "[Completed]") || strings.HasPrefix(description, "[Canceled]") {
+			continue
+		}
+		instance.Events = append(instance.Events, Event{
+			Code:        aws.StringValue(e.Code),
+			Description: description,
+			NotBefore:   e.NotBefore,
+			NotAfter:    e.NotAfter,
+		})
+	}
+}
+
+func checkStatus(db *storm.DB, instance *Instance) {
+	if instance.SystemStatus == statusImpaired || instance.InstanceStatus == statusImpaired {
+		alert := core.NewAlert("StatusCheckFailed", instance.ResourceID)
+		alert.Message = fmt.Sprintf("Status checks are failing for %s", instance.Name)
+		alert.Priority = core.P1
+		alert.Details["account"] = instance.Account
+		alert.Details["region"] = instance.Region
+		alert.Details["resource_id"] = instance.ResourceID
+		alert.ResourceTags = instance.Tags
+		alert.Details["system_status"] = instance.SystemStatus
+		alert.Details["instance_status"] = instance.InstanceStatus
+		if err := alert.Save(db); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+	}
+	for _, event := range instance.Events {
+		alert := core.NewAlert("ScheduledEvent."+event.Code, instance.ResourceID)
+		alert.Message = fmt.Sprintf("Scheduled %s for %s", event.Code, instance.Name)
+		alert.Description = event.Description
+		alert.Details["account"] = instance.Account
+		alert.Details["region"] = instance.Region
+		alert.Details["resource_id"] = instance.ResourceID
+		alert.ResourceTags = instance.Tags
+		alert.Details["event"] = event.Code
+		if event.NotBefore != nil {
+			alert.Message = fmt.Sprintf("Scheduled %s for %s after %s", event.Code, instance.Name, event.NotBefore.Local().Format(time.RFC822))
+			// the event can happen at any time after not before, so that's the deadline for dealing with it
+			alert.Details["deadline"] = event.NotBefore.Format(time.RFC3339)
+		}
+		if event.NotAfter != nil {
+			alert.Details["window_end"] = event.NotAfter.Format(time.RFC3339)
+		}
+		if err := alert.Save(db); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+	}
+}
+
 func metric(namespace string, dimensions []*cloudwatch.Dimension, metricName string, cw *cloudwatch.CloudWatch) *float64 {
 	input := &cloudwatch.GetMetricStatisticsInput{
 		Namespace:  aws.String(namespace),
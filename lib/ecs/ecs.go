@@ -0,0 +1,384 @@
+package ecs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/stojg/aunt/lib/core"
+	auntec2 "github.com/stojg/aunt/lib/ec2"
+)
+
+// Cluster is an app specific representation of an ECS cluster
+type Cluster struct {
+	Name               string
+	ResourceID         string `storm:"id"`
+	ActiveServices     int64
+	ContainerInstances int64
+	RunningTasks       int64
+	PendingTasks       int64
+	Region             string
+	Account            string
+	State              string
+	Tags               map[string]string
+	LastUpdated        time.Time
+	Metrics            map[string]*float64
+}
+
+// Service is an app specific representation of an ECS service
+type Service struct {
+	Name           string
+	ResourceID     string `storm:"id"`
+	ClusterID      string
+	TaskDefinition string
+	DesiredCount   int64
+	RunningCount   int64
+	PendingCount   int64
+	// Deployments is the number of deployments, there is more than one while a new task definition is rolled out
+	Deployments int64
+	// Rollout is either COMPLETED or IN_PROGRESS
+	Rollout string
+	// DeploymentStarted is when the current primary deployment was created
+	DeploymentStarted *time.Time
+	// BelowDesiredCycles is the number of updates in a row that the running count has been below the desired count
+	BelowDesiredCycles int
+	LaunchTime         *time.Time
+	Region             string
+	Account            string
+	State              string
+	Tags               map[string]string
+	LastUpdated        time.Time
+	Metrics            map[string]*float64
+}
+
+// ContainerInstance is an app specific representation of an EC2 instance registered in an ECS cluster
+type ContainerInstance struct {
+	Name       string
+	ResourceID string `storm:"id"`
+	ClusterID  string
+	// InstanceID links this container instance to the ec2.Instance with the same ResourceID
+	InstanceID       string
+	InstanceType     string
+	AgentConnected   bool
+	RunningTasks     int64
+	PendingTasks     int64
+	RegisteredCPU    int64
+	RemainingCPU     int64
+	RegisteredMemory int64
+	RemainingMemory  int64
+	LaunchTime       *time.Time
+	Region           string
+	Account          string
+	State            string
+	// Tags are the tags of the underlying ec2 instance
+	Tags        map[string]string
+	LastUpdated time.Time
+}
+
+const (
+	metricsCPUReservation    = "CPUReservation"
+	metricsMemoryReservation = "MemoryReservation"
+	metricsCPU               = "CPUUtilization"
+	metricsMemory            = "MemoryUtilization"
+)
+
+const (
+	rolloutCompleted  = "COMPLETED"
+	rolloutInProgress = "IN_PROGRESS"
+)
+
+const (
+	// number of updates in a row that a service can run below the desired count before alerting
+	belowDesiredCyclesThreshold = 3
+	// how long a rollout can be in progress before it's considered stuck
+	deploymentStuckThreshold = 30 * time.Minute
+)
+
+var clusterMetrics = []string{metricsCPUReservation, metricsMemoryReservation, metricsCPU, metricsMemory}
+
+var serviceMetrics = []string{metricsCPU, metricsMemory}
+
+// describe calls are limited in how many resources they can describe in one request
+const (
+	maxDescribeClusters  = 100
+	maxDescribeServices  = 10
+	maxDescribeInstances = 100
+)
+
+// Update will update the database with Cluster, Service and ContainerInstance data
+func Update(db *storm.DB, roles map[string]string, regions []string) error {
+	// update all accounts in parallel to speed this up
+	core.UpdateAccounts(roles, func(account, role string) {
+		updateForRole(db, account, role, regions)
+	})
+	return nil
+}
+
+func updateForRole(db *storm.DB, account, role string, regions []string) {
+	for _, region := range regions {
+		sess, config := core.NewCredentials(region, role)
+		svc := ecs.New(sess, config)
+		cw := cloudwatch.New(sess, config)
+
+		var arns []*string
+		err := svc.ListClustersPages(&ecs.ListClustersInput{}, func(page *ecs.ListClustersOutput, lastPage bool) bool {
+			arns = append(arns, page.ClusterArns...)
+			return true
+		})
+		if err != nil {
+			fmt.Printf("ecs.ListClusters %s %s %v\n", role, region, err)
+			continue
+		}
+
+		for _, batch := range chunk(arns, maxDescribeClusters) {
+			resp, err := svc.DescribeClusters(&ecs.DescribeClustersInput{Clusters: batch})
+			if err != nil {
+				fmt.Printf("ecs.DescribeClusters %s %s %v\n", role, region, err)
+				continue
+			}
+			for _, data := range resp.Clusters {
+				cluster := &Cluster{
+					Name:               *data.ClusterName,
+					ResourceID:         *data.ClusterArn,
+					ActiveServices:     aws.Int64Value(data.ActiveServicesCount),
+					ContainerInstances: aws.Int64Value(data.RegisteredContainerInstancesCount),
+					RunningTasks:       aws.Int64Value(data.RunningTasksCount),
+					PendingTasks:       aws.Int64Value(data.PendingTasksCount),
+					Region:             region,
+					Account:            account,
+					State:              aws.StringValue(data.Status),
+					LastUpdated:        time.Now(),
+					Metrics:            make(map[string]*float64),
+				}
+				cluster.Tags = tags(svc, cluster.ResourceID)
+				dimensions := []*cloudwatch.Dimension{{Name: aws.String("ClusterName"), Value: data.ClusterName}}
+				for _, name := range clusterMetrics {
+					cluster.Metrics[name] = core.Metric(cw, "AWS/ECS", dimensions, name, cloudwatch.StatisticAverage)
+				}
+				if err := db.Save(cluster); err != nil {
+					fmt.Printf("%+v\n", err)
+				}
+
+				if err := updateServices(db, svc, cw, cluster); err != nil {
+					fmt.Printf("ecs.DescribeServices %s %s %v\n", role, region, err)
+				}
+				if err := updateContainerInstances(db, svc, cluster); err != nil {
+					fmt.Printf("ecs.DescribeContainerInstances %s %s %v\n", role, region, err)
+				}
+			}
+		}
+	}
+}
+
+func updateServices(db *storm.DB, svc *ecs.ECS, cw *cloudwatch.CloudWatch, cluster *Cluster) error {
+	var arns []*string
+	input := &ecs.ListServicesInput{Cluster: aws.String(cluster.ResourceID)}
+	err := svc.ListServicesPages(input, func(page *ecs.ListServicesOutput, lastPage bool) bool {
+		arns = append(arns, page.ServiceArns...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range chunk(arns, maxDescribeServices) {
+		resp, err := svc.DescribeServices(&ecs.DescribeServicesInput{Cluster: aws.String(cluster.ResourceID), Services: batch})
+		if err != nil {
+			return err
+		}
+		for _, data := range resp.Services {
+			service := &Service{
+				Name:           *data.ServiceName,
+				ResourceID:     *data.ServiceArn,
+				ClusterID:      cluster.ResourceID,
+				TaskDefinition: aws.StringValue(data.TaskDefinition),
+				DesiredCount:   aws.Int64Value(data.DesiredCount),
+				RunningCount:   aws.Int64Value(data.RunningCount),
+				PendingCount:   aws.Int64Value(data.PendingCount),
+				Deployments:    int64(len(data.Deployments)),
+				Rollout:        rolloutCompleted,
+				LaunchTime:     data.CreatedAt,
+				Region:         cluster.Region,
+				Account:        cluster.Account,
+				State:          aws.StringValue(data.Status),
+				LastUpdated:    time.Now(),
+				Metrics:        make(map[string]*float64),
+			}
+			service.Tags = tags(svc, service.ResourceID)
+			for _, deployment := range data.Deployments {
+				if aws.StringValue(deployment.Status) == "PRIMARY" {
+					service.DeploymentStarted = deployment.CreatedAt
+					if aws.Int64Value(deployment.RunningCount) < aws.Int64Value(deployment.DesiredCount) {
+						service.Rollout = rolloutInProgress
+					}
+				}
+			}
+			if service.Deployments > 1 {
+				service.Rollout = rolloutInProgress
+			}
+
+			// the number of cycles below desired count is carried over from the last update
+			if service.RunningCount < service.DesiredCount {
+				var previous Service
+				err := db.One("ResourceID", service.ResourceID, &previous)
+				if err != nil && err != storm.ErrNotFound {
+					fmt.Printf("Error during service lookup: %+v\n", err)
+				}
+				service.BelowDesiredCycles = previous.BelowDesiredCycles + 1
+			}
+
+			dimensions := []*cloudwatch.Dimension{
+				{Name: aws.String("ClusterName"), Value: aws.String(cluster.Name)},
+				{Name: aws.String("ServiceName"), Value: data.ServiceName},
+			}
+			for _, name := range serviceMetrics {
+				service.Metrics[name] = core.Metric(cw, "AWS/ECS", dimensions, name, cloudwatch.StatisticAverage)
+			}
+			if err := db.Save(service); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+
+			// check state
+			if service.BelowDesiredCycles >= belowDesiredCyclesThreshold {
+				alert := newAlert("RunningBelowDesired", service)
+				alert.Message = fmt.Sprintf("ECS service %s is running %d of %d desired tasks", service.Name, service.RunningCount, service.DesiredCount)
+				alert.Details["updates_below_desired"] = fmt.Sprintf("%d", service.BelowDesiredCycles)
+				core.SaveAlert(db, alert)
+			}
+			if service.Rollout == rolloutInProgress && service.DeploymentStarted != nil && time.Since(*service.DeploymentStarted) > deploymentStuckThreshold {
+				alert := newAlert("DeploymentStuck", service)
+				alert.Message = fmt.Sprintf("ECS service %s deployment has been in progress since %s", service.Name, service.DeploymentStarted.Local().Format(time.RFC822))
+				alert.Details["deployments"] = fmt.Sprintf("%d", service.Deployments)
+				alert.Details["task_definition"] = service.TaskDefinition
+				core.SaveAlert(db, alert)
+			}
+		}
+	}
+	return nil
+}
+
+func updateContainerInstances(db *storm.DB, svc *ecs.ECS, cluster *Cluster) error {
+	var arns []*string
+	input := &ecs.ListContainerInstancesInput{Cluster: aws.String(cluster.ResourceID)}
+	err := svc.ListContainerInstancesPages(input, func(page *ecs.ListContainerInstancesOutput, lastPage bool) bool {
+		arns = append(arns, page.ContainerInstanceArns...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range chunk(arns, maxDescribeInstances) {
+		resp, err := svc.DescribeContainerInstances(&ecs.DescribeContainerInstancesInput{Cluster: aws.String(cluster.ResourceID), ContainerInstances: batch})
+		if err != nil {
+			return err
+		}
+		for _, data := range resp.ContainerInstances {
+			instance := &ContainerInstance{
+				ResourceID:       *data.ContainerInstanceArn,
+				ClusterID:        cluster.ResourceID,
+				InstanceID:       aws.StringValue(data.Ec2InstanceId),
+				AgentConnected:   aws.BoolValue(data.AgentConnected),
+				RunningTasks:     aws.Int64Value(data.RunningTasksCount),
+				PendingTasks:     aws.Int64Value(data.PendingTasksCount),
+				RegisteredCPU:    resource("CPU", data.RegisteredResources),
+				RemainingCPU:     resource("CPU", data.RemainingResources),
+				RegisteredMemory: resource("MEMORY", data.RegisteredResources),
+				RemainingMemory:  resource("MEMORY", data.RemainingResources),
+				LaunchTime:       data.RegisteredAt,
+				Region:           cluster.Region,
+				Account:          cluster.Account,
+				State:            aws.StringValue(data.Status),
+				LastUpdated:      time.Now(),
+			}
+
+			// container instances have no name or tags, so take them from the underlying ec2 instance
+			var inst auntec2.Instance
+			err := db.One("ResourceID", instance.InstanceID, &inst)
+			if err == nil {
+				instance.Name = inst.Name
+				instance.InstanceType = inst.InstanceType
+				instance.Tags = inst.Tags
+			} else if err != storm.ErrNotFound {
+				fmt.Printf("Error during instance name lookup: %+v\n", err)
+			}
+			if instance.Name == "" {
+				instance.Name = instance.InstanceID
+			}
+
+			if err := db.Save(instance); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+		}
+	}
+	return nil
+}
+
+func newAlert(name string, service *Service) *core.Alert {
+	alert := core.ResourceAlert(name, service.ResourceID, service.Account, service.Region, service.Tags)
+	alert.Details["cluster"] = service.ClusterID
+	alert.Details["desired_count"] = fmt.Sprintf("%d", service.DesiredCount)
+	alert.Details["running_count"] = fmt.Sprintf("%d", service.RunningCount)
+	alert.Details["pending_count"] = fmt.Sprintf("%d", service.PendingCount)
+	return alert
+}
+
+// listTagsForResourceInput and listTagsForResourceOutput are the ListTagsForResource operation, which is newer than the
+// vendored SDK
+type listTagsForResourceInput struct {
+	_           struct{} `type:"structure"`
+	ResourceArn *string  `locationName:"resourceArn" type:"string" required:"true"`
+}
+
+type listTagsForResourceOutput struct {
+	_    struct{}       `type:"structure"`
+	Tags []*resourceTag `locationName:"tags" type:"list"`
+}
+
+type resourceTag struct {
+	_     struct{} `type:"structure"`
+	Key   *string  `locationName:"key" type:"string"`
+	Value *string  `locationName:"value" type:"string"`
+}
+
+// tags returns the tags of a cluster or a service, services only has tags when the account uses the long ARN format
+func tags(svc *ecs.ECS, arn string) map[string]string {
+	result := make(map[string]string)
+	output := &listTagsForResourceOutput{}
+	op := &request.Operation{Name: "ListTagsForResource", HTTPMethod: "POST", HTTPPath: "/"}
+	if err := svc.NewRequest(op, &listTagsForResourceInput{ResourceArn: aws.String(arn)}, output).Send(); err != nil {
+		fmt.Printf("ecs.ListTagsForResource %s %v\n", arn, err)
+		return result
+	}
+	for _, tag := range output.Tags {
+		result[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return result
+}
+
+// resource returns the integer value of a named container instance resource, e.g. CPU or MEMORY
+func resource(name string, resources []*ecs.Resource) int64 {
+	for _, r := range resources {
+		if aws.StringValue(r.Name) == name {
+			return aws.Int64Value(r.IntegerValue)
+		}
+	}
+	return 0
+}
+
+// chunk splits a list of ARNs into batches that are no larger than size
+func chunk(arns []*string, size int) [][]*string {
+	var batches [][]*string
+	for len(arns) > size {
+		batches = append(batches, arns[:size])
+		arns = arns[size:]
+	}
+	if len(arns) > 0 {
+		batches = append(batches, arns)
+	}
+	return batches
+}
@@ -0,0 +1,263 @@
+package elasticache
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/elasticache"
+	"github.com/stojg/aunt/lib/core"
+)
+
+// CacheCluster is an app specific representation of an ElastiCache cluster, for Redis with replication every node is
+// its own cache cluster that belongs to a replication group
+type CacheCluster struct {
+	Name               string
+	ResourceID         string `storm:"id"`
+	ReplicationGroupID string
+	Role               string
+	Engine             string
+	EngineVersion      string
+	InstanceType       string
+	NumNodes           int64
+	LaunchTime         *time.Time
+	Region             string
+	Account            string
+	State              string
+	Tags               map[string]string
+	LastUpdated        time.Time
+	Metrics            map[string]*float64
+}
+
+// ReplicationGroup is an app specific representation of a Redis replication group
+type ReplicationGroup struct {
+	Name              string
+	ResourceID        string `storm:"id"`
+	Description       string
+	InstanceType      string
+	MemberClusters    []string
+	AutomaticFailover string
+	ClusterEnabled    bool
+	Region            string
+	Account           string
+	State             string
+	Tags              map[string]string
+	LastUpdated       time.Time
+}
+
+const (
+	metricCredits        = "CPUCreditBalance"
+	metricsCPU           = "CPUUtilization"
+	metricsEngineCPU     = "EngineCPUUtilization"
+	metricFreeableMemory = "FreeableMemory"
+	metricEvictions      = "Evictions"
+	metricConnections    = "CurrConnections"
+	metricReplicationLag = "ReplicationLag"
+)
+
+const (
+	metricsCreditsThreshold       float64 = 10
+	metricsCPUThreshold           float64 = 90.0
+	metricsEngineCPUThreshold     float64 = 90.0
+	metricFreeableMemoryThreshold float64 = 100 * 1024 * 1024
+	metricEvictionsThreshold      float64 = 1000
+	metricReplicationLagThreshold float64 = 30
+)
+
+const roleReplica = "replica"
+
+var metrics = map[string]string{
+	metricCredits:        cloudwatch.StatisticMinimum,
+	metricsCPU:           cloudwatch.StatisticAverage,
+	metricsEngineCPU:     cloudwatch.StatisticAverage,
+	metricFreeableMemory: cloudwatch.StatisticMinimum,
+	metricEvictions:      cloudwatch.StatisticSum,
+	metricConnections:    cloudwatch.StatisticMaximum,
+	metricReplicationLag: cloudwatch.StatisticMaximum,
+}
+
+// Update will update the database with CacheCluster and ReplicationGroup data
+func Update(db *storm.DB, roles map[string]string, regions []string) error {
+	// update all accounts in parallel to speed this up
+	core.UpdateAccounts(roles, func(account, role string) {
+		updateForRole(db, account, role, regions)
+	})
+	return nil
+}
+
+func updateForRole(db *storm.DB, account, role string, regions []string) {
+	for _, region := range regions {
+		sess, config := core.NewCredentials(region, role)
+		svc := elasticache.New(sess, config)
+		cw := cloudwatch.New(sess, config)
+		// cache clusters and replication groups are tagged by ARN, which isn't returned by the API but has the same account as the role
+		roleARN, _ := arn.Parse(role)
+
+		// the role of each node (primary or replica) is only known from the replication group
+		nodeRoles := make(map[string]string)
+		err := svc.DescribeReplicationGroupsPages(nil, func(page *elasticache.DescribeReplicationGroupsOutput, lastPage bool) bool {
+			for _, data := range page.ReplicationGroups {
+				group := &ReplicationGroup{
+					Name:              *data.ReplicationGroupId,
+					ResourceID:        *data.ReplicationGroupId,
+					Description:       aws.StringValue(data.Description),
+					InstanceType:      aws.StringValue(data.CacheNodeType),
+					AutomaticFailover: aws.StringValue(data.AutomaticFailover),
+					ClusterEnabled:    aws.BoolValue(data.ClusterEnabled),
+					Region:            region,
+					Account:           account,
+					State:             aws.StringValue(data.Status),
+					LastUpdated:       time.Now(),
+				}
+				group.Tags = tags(svc, arn.ARN{
+					Partition: roleARN.Partition,
+					Service:   "elasticache",
+					Region:    region,
+					AccountID: roleARN.AccountID,
+					Resource:  "replicationgroup:" + group.ResourceID,
+				})
+				for _, id := range data.MemberClusters {
+					group.MemberClusters = append(group.MemberClusters, *id)
+				}
+				for _, nodeGroup := range data.NodeGroups {
+					for _, member := range nodeGroup.NodeGroupMembers {
+						nodeRoles[aws.StringValue(member.CacheClusterId)] = aws.StringValue(member.CurrentRole)
+					}
+				}
+				if err := db.Save(group); err != nil {
+					fmt.Printf("%+v\n", err)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			fmt.Printf("elasticache.DescribeReplicationGroups %s %s %v\n", role, region, err)
+			continue
+		}
+
+		var clusters []*elasticache.CacheCluster
+		err = svc.DescribeCacheClustersPages(nil, func(page *elasticache.DescribeCacheClustersOutput, lastPage bool) bool {
+			clusters = append(clusters, page.CacheClusters...)
+			return true
+		})
+		if err != nil {
+			fmt.Printf("elasticache.DescribeCacheClusters %s %s %v\n", role, region, err)
+			continue
+		}
+
+		for _, i := range clusters {
+			cluster := &CacheCluster{
+				Name:               *i.CacheClusterId,
+				ResourceID:         *i.CacheClusterId,
+				ReplicationGroupID: aws.StringValue(i.ReplicationGroupId),
+				Role:               nodeRoles[*i.CacheClusterId],
+				Engine:             aws.StringValue(i.Engine),
+				EngineVersion:      aws.StringValue(i.EngineVersion),
+				InstanceType:       aws.StringValue(i.CacheNodeType),
+				NumNodes:           aws.Int64Value(i.NumCacheNodes),
+				LaunchTime:         i.CacheClusterCreateTime,
+				Region:             region,
+				Account:            account,
+				State:              aws.StringValue(i.CacheClusterStatus),
+				LastUpdated:        time.Now(),
+				Metrics:            make(map[string]*float64),
+			}
+			cluster.Tags = tags(svc, arn.ARN{
+				Partition: roleARN.Partition,
+				Service:   "elasticache",
+				Region:    region,
+				AccountID: roleARN.AccountID,
+				Resource:  "cluster:" + cluster.ResourceID,
+			})
+
+			dimensions := []*cloudwatch.Dimension{{Name: aws.String("CacheClusterId"), Value: i.CacheClusterId}}
+			for name, statistic := range metrics {
+				// there are no credits for fixed performance instances and only replicas lag
+				if name == metricCredits && !burstable(cluster.InstanceType) {
+					continue
+				}
+				if name == metricReplicationLag && cluster.Role != roleReplica {
+					continue
+				}
+				cluster.Metrics[name] = core.Metric(cw, "AWS/ElastiCache", dimensions, name, statistic)
+			}
+			if err := db.Save(cluster); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+
+			// check metrics
+			credits := cluster.Metrics[metricCredits]
+			if credits != nil && *credits < metricsCreditsThreshold {
+				alert := newAlert(metricCredits, cluster)
+				alert.Message = fmt.Sprintf("CPU credits (%.1f) is below %.1f for %s", *credits, metricsCreditsThreshold, cluster.Name)
+				core.SaveAlert(db, alert)
+			}
+			cpu := cluster.Metrics[metricsCPU]
+			if cpu != nil && *cpu > metricsCPUThreshold {
+				alert := newAlert(metricsCPU, cluster)
+				alert.Message = fmt.Sprintf("CPU Utilisation (%.1f) is above %.1f for %s", *cpu, metricsCPUThreshold, cluster.Name)
+				core.SaveAlert(db, alert)
+			}
+			engineCPU := cluster.Metrics[metricsEngineCPU]
+			if engineCPU != nil && *engineCPU > metricsEngineCPUThreshold {
+				alert := newAlert(metricsEngineCPU, cluster)
+				alert.Message = fmt.Sprintf("Engine CPU Utilisation (%.1f) is above %.1f for %s", *engineCPU, metricsEngineCPUThreshold, cluster.Name)
+				core.SaveAlert(db, alert)
+			}
+			memory := cluster.Metrics[metricFreeableMemory]
+			if memory != nil && *memory < metricFreeableMemoryThreshold {
+				alert := newAlert(metricFreeableMemory, cluster)
+				alert.Message = fmt.Sprintf("Freeable memory (%.0f MB) is below %.0f MB for %s", *memory/1024/1024, metricFreeableMemoryThreshold/1024/1024, cluster.Name)
+				core.SaveAlert(db, alert)
+			}
+			evictions := cluster.Metrics[metricEvictions]
+			if evictions != nil && *evictions > metricEvictionsThreshold {
+				alert := newAlert(metricEvictions, cluster)
+				alert.Message = fmt.Sprintf("%s evicted %.0f keys in the last 15 minutes, threshold %.0f", cluster.Name, *evictions, metricEvictionsThreshold)
+				core.SaveAlert(db, alert)
+			}
+			lag := cluster.Metrics[metricReplicationLag]
+			if lag != nil && *lag > metricReplicationLagThreshold {
+				alert := newAlert(metricReplicationLag, cluster)
+				alert.Message = fmt.Sprintf("Replication lag (%.1fs) is above %.0fs for %s", *lag, metricReplicationLagThreshold, cluster.Name)
+				core.SaveAlert(db, alert)
+			}
+		}
+	}
+}
+
+func newAlert(name string, cluster *CacheCluster) *core.Alert {
+	alert := core.ResourceAlert(name, cluster.ResourceID, cluster.Account, cluster.Region, cluster.Tags)
+	alert.Details["engine"] = fmt.Sprintf("%s %s", cluster.Engine, cluster.EngineVersion)
+	alert.Details["instance_type"] = cluster.InstanceType
+	if cluster.ReplicationGroupID != "" {
+		alert.Details["replication_group"] = cluster.ReplicationGroupID
+	}
+	return alert
+}
+
+// tags returns the tags of a cache cluster or a replication group
+func tags(svc *elasticache.ElastiCache, resource arn.ARN) map[string]string {
+	result := make(map[string]string)
+	if resource.AccountID == "" {
+		return result
+	}
+	resp, err := svc.ListTagsForResource(&elasticache.ListTagsForResourceInput{ResourceName: aws.String(resource.String())})
+	if err != nil {
+		fmt.Printf("elasticache.ListTagsForResource %s %v\n", resource, err)
+		return result
+	}
+	for _, tag := range resp.TagList {
+		result[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return result
+}
+
+// burstable returns true for node types that runs on CPU credits, e.g. cache.t2.micro
+func burstable(nodeType string) bool {
+	return strings.HasPrefix(nodeType, "cache.t2.") || strings.HasPrefix(nodeType, "cache.t3.")
+}
@@ -0,0 +1,398 @@
+package elb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/stojg/aunt/lib/core"
+)
+
+// LoadBalancer is an app specific representation of a classic, application or network load balancer
+type LoadBalancer struct {
+	Name string
+	// ResourceID is the name for classic load balancers and the ARN for application and network load balancers
+	ResourceID  string `storm:"id"`
+	Type        string
+	Scheme      string
+	DNSName     string
+	VPCID       string
+	LaunchTime  *time.Time
+	Region      string
+	Account     string
+	State       string
+	Tags        map[string]string
+	LastUpdated time.Time
+	Metrics     map[string]*float64
+}
+
+// TargetGroup is an app specific representation of an application or network load balancer target group
+type TargetGroup struct {
+	Name            string
+	ResourceID      string `storm:"id"`
+	LoadBalancerIDs []string
+	Protocol        string
+	Port            int64
+	VPCID           string
+	Region          string
+	Account         string
+	Tags            map[string]string
+	LastUpdated     time.Time
+	Metrics         map[string]*float64
+}
+
+// Config holds the thresholds for the load balancer and target group checks
+type Config struct {
+	// UnhealthyHosts is how many instances or targets that can be unhealthy before an alert is raised, an alert is
+	// raised for any unhealthy host when not set
+	UnhealthyHosts int
+}
+
+const (
+	typeClassic     = "classic"
+	typeApplication = "application"
+	typeNetwork     = "network"
+)
+
+const (
+	metricELB5XX             = "HTTPCode_ELB_5XX"
+	metricELB5XXCount        = "HTTPCode_ELB_5XX_Count"
+	metricUnhealthyHosts     = "UnHealthyHostCount"
+	metricHealthyHosts       = "HealthyHostCount"
+	metricTargetResponseTime = "TargetResponseTime"
+	metricSurgeQueueLength   = "SurgeQueueLength"
+	metricSpilloverCount     = "SpilloverCount"
+	metricActiveFlows        = "ActiveFlowCount"
+	metricNewFlows           = "NewFlowCount"
+	metricELBResets          = "TCP_ELB_Reset_Count"
+	metricTargetResets       = "TCP_Target_Reset_Count"
+)
+
+const (
+	metricELB5XXThreshold float64 = 100
+)
+
+var settings Config
+
+type metricStat struct {
+	name      string
+	statistic string
+}
+
+var classicMetrics = []metricStat{
+	{metricELB5XX, cloudwatch.StatisticSum},
+	{metricUnhealthyHosts, cloudwatch.StatisticMaximum},
+	{metricHealthyHosts, cloudwatch.StatisticMinimum},
+	{metricSurgeQueueLength, cloudwatch.StatisticMaximum},
+	{metricSpilloverCount, cloudwatch.StatisticSum},
+}
+
+var applicationMetrics = []metricStat{
+	{metricELB5XXCount, cloudwatch.StatisticSum},
+	{metricTargetResponseTime, "p99"},
+}
+
+var networkMetrics = []metricStat{
+	{metricActiveFlows, cloudwatch.StatisticAverage},
+	{metricNewFlows, cloudwatch.StatisticSum},
+	{metricELBResets, cloudwatch.StatisticSum},
+	{metricTargetResets, cloudwatch.StatisticSum},
+}
+
+// loadBalancerMetrics are the metrics for application and network load balancers by type
+var loadBalancerMetrics = map[string][]metricStat{
+	typeApplication: applicationMetrics,
+	typeNetwork:     networkMetrics,
+}
+
+var targetGroupMetrics = []metricStat{
+	{metricUnhealthyHosts, cloudwatch.StatisticMaximum},
+	{metricHealthyHosts, cloudwatch.StatisticMinimum},
+}
+
+var namespaces = map[string]string{
+	typeClassic:     "AWS/ELB",
+	typeApplication: "AWS/ApplicationELB",
+	typeNetwork:     "AWS/NetworkELB",
+}
+
+// Configure sets the thresholds for the checks
+func Configure(cfg Config) error {
+	if cfg.UnhealthyHosts < 0 {
+		return fmt.Errorf("ELB.UnhealthyHosts can't be negative")
+	}
+	settings = cfg
+	return nil
+}
+
+// Update will update the database with LoadBalancer and TargetGroup data
+func Update(db *storm.DB, roles map[string]string, regions []string) error {
+	// update all accounts in parallel to speed this up
+	core.UpdateAccounts(roles, func(account, role string) {
+		updateForRole(db, account, role, regions)
+	})
+	return nil
+}
+
+func updateForRole(db *storm.DB, account, role string, regions []string) {
+	for _, region := range regions {
+		sess, config := core.NewCredentials(region, role)
+		cw := cloudwatch.New(sess, config)
+
+		// the classic and v2 load balancers are separate APIs, an error in one of them doesn't stop the other
+		if err := updateClassic(db, elb.New(sess, config), cw, account, region); err != nil {
+			fmt.Printf("elb.DescribeLoadBalancers %s %s %v\n", role, region, err)
+		}
+		if err := updateV2(db, elbv2.New(sess, config), cw, account, region); err != nil {
+			fmt.Printf("elbv2.DescribeLoadBalancers %s %s %v\n", role, region, err)
+		}
+	}
+}
+
+func updateClassic(db *storm.DB, svc *elb.ELB, cw *cloudwatch.CloudWatch, account, region string) error {
+	var descriptions []*elb.LoadBalancerDescription
+	err := svc.DescribeLoadBalancersPages(nil, func(page *elb.DescribeLoadBalancersOutput, lastPage bool) bool {
+		descriptions = append(descriptions, page.LoadBalancerDescriptions...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var names []*string
+	for _, data := range descriptions {
+		names = append(names, data.LoadBalancerName)
+	}
+	tags := classicTags(svc, names)
+
+	for _, data := range descriptions {
+		lb := &LoadBalancer{
+			Name:        *data.LoadBalancerName,
+			ResourceID:  *data.LoadBalancerName,
+			Type:        typeClassic,
+			Scheme:      aws.StringValue(data.Scheme),
+			DNSName:     aws.StringValue(data.DNSName),
+			VPCID:       aws.StringValue(data.VPCId),
+			LaunchTime:  data.CreatedTime,
+			Region:      region,
+			Account:     account,
+			State:       "active",
+			Tags:        tags[*data.LoadBalancerName],
+			LastUpdated: time.Now(),
+			Metrics:     make(map[string]*float64),
+		}
+
+		dimensions := []*cloudwatch.Dimension{{Name: aws.String("LoadBalancerName"), Value: data.LoadBalancerName}}
+		for _, m := range classicMetrics {
+			lb.Metrics[m.name] = core.Metric(cw, namespaces[typeClassic], dimensions, m.name, m.statistic)
+		}
+		if err := db.Save(lb); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+
+		// check metrics
+		unhealthy := lb.Metrics[metricUnhealthyHosts]
+		if unhealthy != nil && *unhealthy > float64(settings.UnhealthyHosts) {
+			alert := core.ResourceAlert(metricUnhealthyHosts, lb.ResourceID, lb.Account, lb.Region, lb.Tags)
+			alert.Message = fmt.Sprintf("ELB %s has %.0f unhealthy instances", lb.Name, *unhealthy)
+			alert.Details["dns_name"] = lb.DNSName
+			if healthy := lb.Metrics[metricHealthyHosts]; healthy != nil {
+				alert.Details["healthy_hosts"] = fmt.Sprintf("%.0f", *healthy)
+			}
+			core.SaveAlert(db, alert)
+		}
+		check5XX(db, lb, metricELB5XX)
+	}
+	return nil
+}
+
+func updateV2(db *storm.DB, svc *elbv2.ELBV2, cw *cloudwatch.CloudWatch, account, region string) error {
+	var loadBalancers []*elbv2.LoadBalancer
+	err := svc.DescribeLoadBalancersPages(nil, func(page *elbv2.DescribeLoadBalancersOutput, lastPage bool) bool {
+		loadBalancers = append(loadBalancers, page.LoadBalancers...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	var arns []*string
+	for _, data := range loadBalancers {
+		arns = append(arns, data.LoadBalancerArn)
+	}
+	tags := v2Tags(svc, arns)
+
+	// the dimension value for a load balancer is the last part of the ARN, e.g. app/my-lb/50dc6c495c0c9188
+	lbDimensions := make(map[string]string)
+	lbTypes := make(map[string]string)
+
+	for _, data := range loadBalancers {
+		lb := &LoadBalancer{
+			Name:        *data.LoadBalancerName,
+			ResourceID:  *data.LoadBalancerArn,
+			Type:        aws.StringValue(data.Type),
+			Scheme:      aws.StringValue(data.Scheme),
+			DNSName:     aws.StringValue(data.DNSName),
+			VPCID:       aws.StringValue(data.VpcId),
+			LaunchTime:  data.CreatedTime,
+			Region:      region,
+			Account:     account,
+			Tags:        tags[*data.LoadBalancerArn],
+			LastUpdated: time.Now(),
+			Metrics:     make(map[string]*float64),
+		}
+		if data.State != nil {
+			lb.State = aws.StringValue(data.State.Code)
+		}
+		lbDimensions[lb.ResourceID] = arnSuffix(lb.ResourceID, ":loadbalancer/")
+		lbTypes[lb.ResourceID] = lb.Type
+
+		if metrics, ok := loadBalancerMetrics[lb.Type]; ok {
+			dimensions := []*cloudwatch.Dimension{{Name: aws.String("LoadBalancer"), Value: aws.String(lbDimensions[lb.ResourceID])}}
+			for _, m := range metrics {
+				lb.Metrics[m.name] = core.Metric(cw, namespaces[lb.Type], dimensions, m.name, m.statistic)
+			}
+		}
+		if err := db.Save(lb); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+		check5XX(db, lb, metricELB5XXCount)
+	}
+
+	var targetGroups []*elbv2.TargetGroup
+	err = svc.DescribeTargetGroupsPages(nil, func(page *elbv2.DescribeTargetGroupsOutput, lastPage bool) bool {
+		targetGroups = append(targetGroups, page.TargetGroups...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	arns = nil
+	for _, data := range targetGroups {
+		arns = append(arns, data.TargetGroupArn)
+	}
+	tags = v2Tags(svc, arns)
+
+	for _, data := range targetGroups {
+		tg := &TargetGroup{
+			Name:        *data.TargetGroupName,
+			ResourceID:  *data.TargetGroupArn,
+			Protocol:    aws.StringValue(data.Protocol),
+			Port:        aws.Int64Value(data.Port),
+			VPCID:       aws.StringValue(data.VpcId),
+			Region:      region,
+			Account:     account,
+			Tags:        tags[*data.TargetGroupArn],
+			LastUpdated: time.Now(),
+			Metrics:     make(map[string]*float64),
+		}
+		for _, arn := range data.LoadBalancerArns {
+			tg.LoadBalancerIDs = append(tg.LoadBalancerIDs, *arn)
+		}
+
+		// target group metrics are only published per load balancer, a target group that isn't used by a load balancer
+		// doesn't have any targets being health checked
+		if len(tg.LoadBalancerIDs) > 0 {
+			lbID := tg.LoadBalancerIDs[0]
+			dimensions := []*cloudwatch.Dimension{
+				{Name: aws.String("TargetGroup"), Value: aws.String(arnSuffix(tg.ResourceID, ":"))},
+				{Name: aws.String("LoadBalancer"), Value: aws.String(lbDimensions[lbID])},
+			}
+			for _, m := range targetGroupMetrics {
+				tg.Metrics[m.name] = core.Metric(cw, namespaces[lbTypes[lbID]], dimensions, m.name, m.statistic)
+			}
+		}
+		if err := db.Save(tg); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+
+		// check metrics
+		unhealthy := tg.Metrics[metricUnhealthyHosts]
+		if unhealthy != nil && *unhealthy > float64(settings.UnhealthyHosts) {
+			alert := core.ResourceAlert(metricUnhealthyHosts, tg.ResourceID, tg.Account, tg.Region, tg.Tags)
+			alert.Message = fmt.Sprintf("Target group %s has %.0f unhealthy targets", tg.Name, *unhealthy)
+			alert.Details["load_balancers"] = strings.Join(tg.LoadBalancerIDs, ", ")
+			if healthy := tg.Metrics[metricHealthyHosts]; healthy != nil {
+				alert.Details["healthy_hosts"] = fmt.Sprintf("%.0f", *healthy)
+			}
+			core.SaveAlert(db, alert)
+		}
+	}
+	return nil
+}
+
+func check5XX(db *storm.DB, lb *LoadBalancer, metricName string) {
+	errors := lb.Metrics[metricName]
+	if errors == nil || *errors <= metricELB5XXThreshold {
+		return
+	}
+	alert := core.ResourceAlert(metricELB5XX, lb.ResourceID, lb.Account, lb.Region, lb.Tags)
+	alert.Message = fmt.Sprintf("ELB %s returned %.0f 5XX errors in the last 15 minutes, threshold %.0f", lb.Name, *errors, metricELB5XXThreshold)
+	alert.Details["type"] = lb.Type
+	alert.Details["dns_name"] = lb.DNSName
+	core.SaveAlert(db, alert)
+}
+
+// maxTagResources is how many load balancers or target groups that the tags can be described for in one call
+const maxTagResources = 20
+
+// classicTags returns the tags of the classic load balancers by name
+func classicTags(svc *elb.ELB, names []*string) map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	for start := 0; start < len(names); start += maxTagResources {
+		end := start + maxTagResources
+		if end > len(names) {
+			end = len(names)
+		}
+		resp, err := svc.DescribeTags(&elb.DescribeTagsInput{LoadBalancerNames: names[start:end]})
+		if err != nil {
+			fmt.Printf("elb.DescribeTags %v\n", err)
+			continue
+		}
+		for _, description := range resp.TagDescriptions {
+			tags := make(map[string]string)
+			for _, tag := range description.Tags {
+				tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			result[aws.StringValue(description.LoadBalancerName)] = tags
+		}
+	}
+	return result
+}
+
+// v2Tags returns the tags of the application and network load balancers or target groups by ARN
+func v2Tags(svc *elbv2.ELBV2, arns []*string) map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	for start := 0; start < len(arns); start += maxTagResources {
+		end := start + maxTagResources
+		if end > len(arns) {
+			end = len(arns)
+		}
+		resp, err := svc.DescribeTags(&elbv2.DescribeTagsInput{ResourceArns: arns[start:end]})
+		if err != nil {
+			fmt.Printf("elbv2.DescribeTags %v\n", err)
+			continue
+		}
+		for _, description := range resp.TagDescriptions {
+			tags := make(map[string]string)
+			for _, tag := range description.Tags {
+				tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			result[aws.StringValue(description.ResourceArn)] = tags
+		}
+	}
+	return result
+}
+
+// arnSuffix returns everything after the last occurrence of sep in the arn
+func arnSuffix(arn, sep string) string {
+	idx := strings.LastIndex(arn, sep)
+	if idx < 0 {
+		return arn
+	}
+	return arn[idx+len(sep):]
+}
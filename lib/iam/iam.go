@@ -0,0 +1,318 @@
+package iam
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/stojg/aunt/lib/core"
+)
+
+// User is an app specific representation of an IAM user, built from the IAM credential report
+type User struct {
+	Name string
+	// ResourceID is the user ARN
+	ResourceID       string `storm:"id"`
+	PasswordEnabled  bool
+	PasswordLastUsed *time.Time
+	MFAActive        bool
+	AccessKeys       []AccessKey
+	// Findings are the names of the hygiene checks that this user currently fails, including allowed ones
+	Findings    []string
+	LaunchTime  *time.Time
+	Region      string
+	Account     string
+	Tags        map[string]string
+	LastUpdated time.Time
+}
+
+// AccessKey is one of the two access keys that an IAM user can have
+type AccessKey struct {
+	Number          int
+	Active          bool
+	LastRotated     *time.Time
+	LastUsed        *time.Time
+	LastUsedService string
+}
+
+// Config holds the thresholds and exceptions for the IAM hygiene checks
+type Config struct {
+	// KeyMaxAgeDays is how old an active access key can be before it should be rotated
+	KeyMaxAgeDays int
+	// KeyUnusedDays is how long an active access key can be unused before it should be removed
+	KeyUnusedDays int
+	// AllowList maps user names to findings that are accepted for that user, an empty list accepts all findings
+	AllowList map[string][]string
+}
+
+const (
+	findingKeyAge    = "AccessKeyAge"
+	findingKeyUnused = "AccessKeyUnused"
+	findingNoMFA     = "ConsoleWithoutMFA"
+	findingRootKey   = "RootAccessKey"
+)
+
+// rootUser is the name of the root account in the credential report
+const rootUser = "<root_account>"
+
+// iamRegion is used for IAM users since they are global and not tied to a region
+const iamRegion = "global"
+
+var settings = Config{
+	KeyMaxAgeDays: 90,
+	KeyUnusedDays: 90,
+}
+
+// Configure overrides the default thresholds and sets the allow list, zero values keep the defaults
+func Configure(cfg Config) {
+	if cfg.KeyMaxAgeDays > 0 {
+		settings.KeyMaxAgeDays = cfg.KeyMaxAgeDays
+	}
+	if cfg.KeyUnusedDays > 0 {
+		settings.KeyUnusedDays = cfg.KeyUnusedDays
+	}
+	settings.AllowList = cfg.AllowList
+}
+
+// Update will update the database with User data
+func Update(db *storm.DB, roles map[string]string, regions []string) error {
+	// update all accounts in parallel to speed this up
+	core.UpdateAccounts(roles, func(account, role string) {
+		updateForRole(db, account, role, regions)
+	})
+	return nil
+}
+
+func updateForRole(db *storm.DB, account, role string, regions []string) {
+	// IAM is global so the region is only used for the credentials
+	if len(regions) == 0 {
+		return
+	}
+	sess, config := core.NewCredentials(regions[0], role)
+	svc := iam.New(sess, config)
+
+	report, err := credentialReport(svc)
+	if err != nil {
+		fmt.Printf("iam.GetCredentialReport %s %v\n", role, err)
+		return
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(report)).ReadAll()
+	if err != nil || len(records) < 1 {
+		fmt.Printf("iam.GetCredentialReport %s unreadable report: %v\n", role, err)
+		return
+	}
+
+	header := make(map[string]int)
+	for i, name := range records[0] {
+		header[name] = i
+	}
+
+	for _, record := range records[1:] {
+		col := func(name string) string {
+			if i, ok := header[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+
+		user := &User{
+			Name:             col("user"),
+			ResourceID:       col("arn"),
+			PasswordEnabled:  col("password_enabled") == "true",
+			PasswordLastUsed: parseTime(col("password_last_used")),
+			MFAActive:        col("mfa_active") == "true",
+			LaunchTime:       parseTime(col("user_creation_time")),
+			Region:           iamRegion,
+			Account:          account,
+			LastUpdated:      time.Now(),
+		}
+		// the root account can't be tagged, so it uses the tags of the account
+		if user.Name == rootUser {
+			user.Tags = core.AccountTags(account)
+		} else {
+			user.Tags = tags(svc, user.Name)
+		}
+		for n := 1; n <= 2; n++ {
+			prefix := fmt.Sprintf("access_key_%d_", n)
+			user.AccessKeys = append(user.AccessKeys, AccessKey{
+				Number:          n,
+				Active:          col(prefix+"active") == "true",
+				LastRotated:     parseTime(col(prefix + "last_rotated")),
+				LastUsed:        parseTime(col(prefix + "last_used_date")),
+				LastUsedService: col(prefix + "last_used_service"),
+			})
+		}
+
+		alerts := check(user)
+		if err := db.Save(user); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+		for _, alert := range alerts {
+			core.SaveAlert(db, alert)
+		}
+	}
+}
+
+// check sets the findings on the user and returns an alert for every finding that isn't allowed
+func check(user *User) []*core.Alert {
+	var alerts []*core.Alert
+	add := func(finding string, key int, alert *core.Alert) {
+		user.Findings = append(user.Findings, finding)
+		if allowed(user.Name, finding) {
+			return
+		}
+		if key > 0 {
+			alert.Details["access_key"] = fmt.Sprintf("%d", key)
+		}
+		alerts = append(alerts, alert)
+	}
+
+	keyAgeLimit := time.Now().AddDate(0, 0, -settings.KeyMaxAgeDays)
+	keyUnusedLimit := time.Now().AddDate(0, 0, -settings.KeyUnusedDays)
+
+	for _, key := range user.AccessKeys {
+		if !key.Active {
+			continue
+		}
+		if user.Name == rootUser {
+			alert := newAlert(fmt.Sprintf("%s.%d", findingRootKey, key.Number), user)
+			alert.Message = fmt.Sprintf("Root account in %s has an active access key", user.Account)
+			alert.Priority = core.P1
+			if key.LastUsed != nil {
+				alert.Details["last_used"] = key.LastUsed.Format(time.RFC3339)
+				alert.Details["last_used_service"] = key.LastUsedService
+			}
+			add(findingRootKey, key.Number, alert)
+			continue
+		}
+		if key.LastRotated != nil && key.LastRotated.Before(keyAgeLimit) {
+			alert := newAlert(fmt.Sprintf("%s.%d", findingKeyAge, key.Number), user)
+			alert.Message = fmt.Sprintf("Access key %d for %s in %s is older than %d days", key.Number, user.Name, user.Account, settings.KeyMaxAgeDays)
+			alert.Priority = core.P3
+			alert.Details["last_rotated"] = key.LastRotated.Format(time.RFC3339)
+			add(findingKeyAge, key.Number, alert)
+		}
+		// a key that has never been used is measured from when it was created
+		lastUsed := key.LastUsed
+		if lastUsed == nil {
+			lastUsed = key.LastRotated
+		}
+		if lastUsed != nil && lastUsed.Before(keyUnusedLimit) {
+			alert := newAlert(fmt.Sprintf("%s.%d", findingKeyUnused, key.Number), user)
+			alert.Message = fmt.Sprintf("Access key %d for %s in %s hasn't been used in %d days", key.Number, user.Name, user.Account, settings.KeyUnusedDays)
+			alert.Priority = core.P3
+			if key.LastUsed != nil {
+				alert.Details["last_used"] = key.LastUsed.Format(time.RFC3339)
+			}
+			add(findingKeyUnused, key.Number, alert)
+		}
+	}
+
+	// the root account is covered by the AWS account MFA setup and not by this check
+	if user.Name != rootUser && user.PasswordEnabled && !user.MFAActive {
+		alert := newAlert(findingNoMFA, user)
+		alert.Message = fmt.Sprintf("Console user %s in %s doesn't have MFA enabled", user.Name, user.Account)
+		if user.PasswordLastUsed != nil {
+			alert.Details["password_last_used"] = user.PasswordLastUsed.Format(time.RFC3339)
+		}
+		add(findingNoMFA, 0, alert)
+	}
+	return alerts
+}
+
+func newAlert(name string, user *User) *core.Alert {
+	alert := core.ResourceAlert(name, user.ResourceID, user.Account, user.Region, user.Tags)
+	alert.Details["user"] = user.Name
+	return alert
+}
+
+func allowed(user, finding string) bool {
+	findings, ok := settings.AllowList[user]
+	if !ok {
+		return false
+	}
+	if len(findings) == 0 {
+		return true
+	}
+	for _, f := range findings {
+		if f == finding {
+			return true
+		}
+	}
+	return false
+}
+
+// credentialReport asks IAM to generate a credential report and waits for it to be ready
+func credentialReport(svc *iam.IAM) ([]byte, error) {
+	for i := 0; i < 10; i++ {
+		resp, err := svc.GenerateCredentialReport(&iam.GenerateCredentialReportInput{})
+		if err != nil {
+			return nil, err
+		}
+		if aws.StringValue(resp.State) == iam.ReportStateTypeComplete {
+			report, err := svc.GetCredentialReport(&iam.GetCredentialReportInput{})
+			if err != nil {
+				return nil, err
+			}
+			return report.Content, nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return nil, fmt.Errorf("credential report wasn't generated in time")
+}
+
+// listUserTagsInput and listUserTagsOutput are the ListUserTags operation, which is newer than the vendored SDK
+type listUserTagsInput struct {
+	_        struct{} `type:"structure"`
+	UserName *string  `type:"string" required:"true"`
+	Marker   *string  `type:"string"`
+}
+
+type listUserTagsOutput struct {
+	_           struct{}   `type:"structure"`
+	Tags        []*userTag `type:"list"`
+	IsTruncated *bool      `type:"boolean"`
+	Marker      *string    `type:"string"`
+}
+
+type userTag struct {
+	_     struct{} `type:"structure"`
+	Key   *string  `type:"string"`
+	Value *string  `type:"string"`
+}
+
+// tags returns the tags of an IAM user
+func tags(svc *iam.IAM, user string) map[string]string {
+	result := make(map[string]string)
+	input := &listUserTagsInput{UserName: aws.String(user)}
+	for {
+		output := &listUserTagsOutput{}
+		op := &request.Operation{Name: "ListUserTags", HTTPMethod: "POST", HTTPPath: "/"}
+		if err := svc.NewRequest(op, input, output).Send(); err != nil {
+			fmt.Printf("iam.ListUserTags %s %v\n", user, err)
+			return result
+		}
+		for _, tag := range output.Tags {
+			result[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		if !aws.BoolValue(output.IsTruncated) {
+			return result
+		}
+		input.Marker = output.Marker
+	}
+}
+
+// parseTime parses the timestamps in the credential report, which uses N/A and similar values for missing data
+func parseTime(value string) *time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
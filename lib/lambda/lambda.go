@@ -0,0 +1,167 @@
+package lambda
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/stojg/aunt/lib/core"
+)
+
+// Function is an app specific representation of a Lambda function
+type Function struct {
+	Name string
+	// ResourceID is the function ARN since function names are only unique per account and region
+	ResourceID   string `storm:"id"`
+	Runtime      string
+	MemorySize   int64
+	Timeout      int64
+	LastModified *time.Time
+	Region       string
+	Account      string
+	Tags         map[string]string
+	LastUpdated  time.Time
+	Metrics      map[string]*float64
+}
+
+const (
+	metricErrors               = "Errors"
+	metricThrottles            = "Throttles"
+	metricInvocations          = "Invocations"
+	metricDuration             = "Duration"
+	metricConcurrentExecutions = "ConcurrentExecutions"
+	// metricErrorRate is calculated from Errors and Invocations and isn't a CloudWatch metric
+	metricErrorRate = "ErrorRate"
+)
+
+const (
+	// percentage of invocations that resulted in an error
+	metricErrorRateThreshold float64 = 5
+	// don't alert on error rate for functions that is rarely invoked, a single error would be a high rate
+	metricErrorRateMinInvocations float64 = 20
+	metricThrottlesThreshold      float64 = 10
+	// percentage of the configured timeout that the p99 duration may reach
+	metricDurationTimeoutThreshold float64 = 80
+)
+
+// lastModifiedLayout is the format of the LastModified field returned by the Lambda API
+const lastModifiedLayout = "2006-01-02T15:04:05.999-0700"
+
+var metrics = map[string]string{
+	metricErrors:               cloudwatch.StatisticSum,
+	metricThrottles:            cloudwatch.StatisticSum,
+	metricInvocations:          cloudwatch.StatisticSum,
+	metricDuration:             "p99",
+	metricConcurrentExecutions: cloudwatch.StatisticMaximum,
+}
+
+// Update will update the database with Function data
+func Update(db *storm.DB, roles map[string]string, regions []string) error {
+	// update all accounts in parallel to speed this up
+	core.UpdateAccounts(roles, func(account, role string) {
+		updateForRole(db, account, role, regions)
+	})
+	return nil
+}
+
+func updateForRole(db *storm.DB, account, role string, regions []string) {
+	for _, region := range regions {
+		sess, config := core.NewCredentials(region, role)
+		svc := lambda.New(sess, config)
+		cw := cloudwatch.New(sess, config)
+
+		var functions []*lambda.FunctionConfiguration
+		err := svc.ListFunctionsPages(&lambda.ListFunctionsInput{}, func(page *lambda.ListFunctionsOutput, lastPage bool) bool {
+			functions = append(functions, page.Functions...)
+			return true
+		})
+		if err != nil {
+			fmt.Printf("lambda.ListFunctions %s %s %v\n", role, region, err)
+			continue
+		}
+
+		for _, i := range functions {
+			function := &Function{
+				Name:        *i.FunctionName,
+				ResourceID:  *i.FunctionArn,
+				Runtime:     aws.StringValue(i.Runtime),
+				MemorySize:  aws.Int64Value(i.MemorySize),
+				Timeout:     aws.Int64Value(i.Timeout),
+				Region:      region,
+				Account:     account,
+				Tags:        tags(svc, i.FunctionArn),
+				LastUpdated: time.Now(),
+				Metrics:     make(map[string]*float64),
+			}
+			if modified, err := time.Parse(lastModifiedLayout, aws.StringValue(i.LastModified)); err == nil {
+				function.LastModified = &modified
+			}
+
+			dimensions := []*cloudwatch.Dimension{{Name: aws.String("FunctionName"), Value: i.FunctionName}}
+			for name, statistic := range metrics {
+				function.Metrics[name] = core.Metric(cw, "AWS/Lambda", dimensions, name, statistic)
+			}
+
+			errors := function.Metrics[metricErrors]
+			invocations := function.Metrics[metricInvocations]
+			if errors != nil && invocations != nil && *invocations > 0 {
+				function.Metrics[metricErrorRate] = aws.Float64(*errors / *invocations * 100)
+			}
+
+			if err := db.Save(function); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+
+			// check metrics
+			errorRate := function.Metrics[metricErrorRate]
+			if errorRate != nil && *errorRate > metricErrorRateThreshold && *invocations >= metricErrorRateMinInvocations {
+				alert := newAlert(metricErrorRate, function)
+				alert.Message = fmt.Sprintf("Error rate (%.1f%%) is above %.1f%% for lambda %s", *errorRate, metricErrorRateThreshold, function.Name)
+				alert.Details["errors"] = fmt.Sprintf("%.0f", *errors)
+				alert.Details["invocations"] = fmt.Sprintf("%.0f", *invocations)
+				core.SaveAlert(db, alert)
+			}
+			throttles := function.Metrics[metricThrottles]
+			if throttles != nil && *throttles > metricThrottlesThreshold {
+				alert := newAlert(metricThrottles, function)
+				alert.Message = fmt.Sprintf("Throttled invocations (%.0f) is above %.0f for lambda %s", *throttles, metricThrottlesThreshold, function.Name)
+				if concurrent := function.Metrics[metricConcurrentExecutions]; concurrent != nil {
+					alert.Details["concurrent_executions"] = fmt.Sprintf("%.0f", *concurrent)
+				}
+				core.SaveAlert(db, alert)
+			}
+			duration := function.Metrics[metricDuration]
+			timeout := float64(function.Timeout * 1000)
+			if duration != nil && timeout > 0 && *duration > timeout*metricDurationTimeoutThreshold/100 {
+				alert := newAlert(metricDuration, function)
+				alert.Message = fmt.Sprintf("p99 duration (%.0fms) is above %.0f%% of the %ds timeout for lambda %s", *duration, metricDurationTimeoutThreshold, function.Timeout, function.Name)
+				core.SaveAlert(db, alert)
+			}
+		}
+	}
+}
+
+func newAlert(name string, function *Function) *core.Alert {
+	alert := core.ResourceAlert(name, function.ResourceID, function.Account, function.Region, function.Tags)
+	alert.Details["runtime"] = function.Runtime
+	alert.Details["memory_size"] = fmt.Sprintf("%d", function.MemorySize)
+	alert.Details["timeout"] = fmt.Sprintf("%d", function.Timeout)
+	return alert
+}
+
+// tags returns the tags of a function
+func tags(svc *lambda.Lambda, arn *string) map[string]string {
+	result := make(map[string]string)
+	resp, err := svc.ListTags(&lambda.ListTagsInput{Resource: arn})
+	if err != nil {
+		fmt.Printf("lambda.ListTags %s %v\n", aws.StringValue(arn), err)
+		return result
+	}
+	for key, value := range resp.Tags {
+		result[key] = aws.StringValue(value)
+	}
+	return result
+}
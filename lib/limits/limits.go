@@ -0,0 +1,346 @@
+package limits
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/stojg/aunt/lib/core"
+	auntdynamodb "github.com/stojg/aunt/lib/dynamodb"
+)
+
+// Limit is the current usage of an account limit in a region
+type Limit struct {
+	Name string
+	// ResourceID is the account, region and limit name, e.g. production/us-east-1/ec2.elastic-ips
+	ResourceID string `storm:"id"`
+	Service    string
+	Usage      float64
+	Max        float64
+	// Breakdown splits the usage into parts, e.g. running instances by instance family
+	Breakdown   map[string]float64
+	Region      string
+	Account     string
+	LastUpdated time.Time
+	Metrics     map[string]*float64
+}
+
+// Config holds the alert threshold and limits that can't be read from the AWS APIs
+type Config struct {
+	// ThresholdPercent is the percentage of a limit that raises an alert
+	ThresholdPercent float64
+	// Overrides sets the max value for limits, this is needed for limits that has been raised but isn't available
+	// via an API, e.g. "ec2.vpcs": 20
+	Overrides map[string]float64
+	// InstanceFamilies are the limits for running on-demand instances in each instance family, e.g. "p2": 1, they can't
+	// be read from the APIs so only the families that are set here are checked
+	InstanceFamilies map[string]float64
+}
+
+const (
+	metricUsage        = "Usage"
+	metricLimit        = "Limit"
+	metricUsagePercent = "UsagePercent"
+)
+
+// defaults for limits that aren't available via the APIs, these are the AWS defaults for a new account
+var defaults = map[string]float64{
+	"ec2.vpcs":                 5,
+	"ec2.security-groups":      2500,
+	"ebs.storage-gib.gp2":      300 * 1024,
+	"ebs.storage-gib.io1":      300 * 1024,
+	"ebs.storage-gib.st1":      300 * 1024,
+	"ebs.storage-gib.sc1":      300 * 1024,
+	"ebs.storage-gib.standard": 300 * 1024,
+	"dynamodb.tables":          256,
+}
+
+var settings = Config{
+	ThresholdPercent: 80,
+}
+
+// Configure sets the alert threshold and limit overrides, a zero threshold keeps the default
+func Configure(cfg Config) error {
+	if cfg.ThresholdPercent < 0 || cfg.ThresholdPercent > 100 {
+		return fmt.Errorf("limits threshold must be a percentage between 0 and 100, got %.1f", cfg.ThresholdPercent)
+	}
+	if cfg.ThresholdPercent > 0 {
+		settings.ThresholdPercent = cfg.ThresholdPercent
+	}
+	for family, max := range cfg.InstanceFamilies {
+		if max <= 0 {
+			return fmt.Errorf("limits instance family %s must have a limit above 0", family)
+		}
+	}
+	settings.Overrides = cfg.Overrides
+	settings.InstanceFamilies = cfg.InstanceFamilies
+	return nil
+}
+
+// Update will update the database with Limit data, it should run after the dynamodb update since it uses the stored
+// tables to calculate the provisioned capacity
+func Update(db *storm.DB, roles map[string]string, regions []string) error {
+	// update all accounts in parallel to speed this up
+	core.UpdateAccounts(roles, func(account, role string) {
+		updateForRole(db, account, role, regions)
+	})
+	return nil
+}
+
+func updateForRole(db *storm.DB, account, role string, regions []string) {
+	for _, region := range regions {
+		sess, config := core.NewCredentials(region, role)
+
+		var limits []*Limit
+		add := func(service, name string, usage, max float64) *Limit {
+			l := &Limit{
+				Name:        name,
+				ResourceID:  fmt.Sprintf("%s/%s/%s", account, region, name),
+				Service:     service,
+				Usage:       usage,
+				Max:         max,
+				Region:      region,
+				Account:     account,
+				LastUpdated: time.Now(),
+			}
+			limits = append(limits, l)
+			return l
+		}
+
+		if err := ec2Limits(sess, config, add); err != nil {
+			fmt.Printf("limits.ec2 %s %s %v\n", role, region, err)
+		}
+		if err := autoscalingLimits(sess, config, add); err != nil {
+			fmt.Printf("limits.autoscaling %s %s %v\n", role, region, err)
+		}
+		if err := rdsLimits(sess, config, add); err != nil {
+			fmt.Printf("limits.rds %s %s %v\n", role, region, err)
+		}
+		if err := dynamodbLimits(db, sess, config, account, region, add); err != nil {
+			fmt.Printf("limits.dynamodb %s %s %v\n", role, region, err)
+		}
+
+		for _, l := range limits {
+			save(db, l)
+		}
+	}
+}
+
+type addFunc func(service, name string, usage, max float64) *Limit
+
+func ec2Limits(sess *session.Session, config *aws.Config, add addFunc) error {
+	svc := ec2.New(sess, config)
+
+	attrs, err := svc.DescribeAccountAttributes(&ec2.DescribeAccountAttributesInput{})
+	if err != nil {
+		return err
+	}
+	attributes := make(map[string]float64)
+	for _, attr := range attrs.AccountAttributes {
+		for _, v := range attr.AttributeValues {
+			if f, err := strconv.ParseFloat(aws.StringValue(v.AttributeValue), 64); err == nil {
+				attributes[aws.StringValue(attr.AttributeName)] = f
+			}
+		}
+	}
+
+	// only on-demand instances count towards the running instance limits, there is a limit for all instances and one
+	// for each instance family
+	families := make(map[string]float64)
+	running := 0.0
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"running"})}},
+	}
+	err = svc.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, i := range reservation.Instances {
+				if i.InstanceLifecycle != nil {
+					continue
+				}
+				families[strings.Split(aws.StringValue(i.InstanceType), ".")[0]]++
+				running++
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	add("ec2", "ec2.on-demand-instances", running, attributes["max-instances"]).Breakdown = families
+	for family, max := range settings.InstanceFamilies {
+		add("ec2", "ec2.on-demand-instances."+family, families[family], max)
+	}
+
+	addresses, err := svc.DescribeAddresses(&ec2.DescribeAddressesInput{})
+	if err != nil {
+		return err
+	}
+	var vpcIPs, classicIPs float64
+	for _, a := range addresses.Addresses {
+		if aws.StringValue(a.Domain) == ec2.DomainTypeVpc {
+			vpcIPs++
+		} else {
+			classicIPs++
+		}
+	}
+	add("ec2", "ec2.elastic-ips", vpcIPs, attributes["vpc-max-elastic-ips"])
+	if classicIPs > 0 {
+		add("ec2", "ec2.classic-elastic-ips", classicIPs, attributes["max-elastic-ips"])
+	}
+
+	vpcs, err := svc.DescribeVpcs(&ec2.DescribeVpcsInput{})
+	if err != nil {
+		return err
+	}
+	add("ec2", "ec2.vpcs", float64(len(vpcs.Vpcs)), defaults["ec2.vpcs"])
+
+	groups, err := svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{})
+	if err != nil {
+		return err
+	}
+	add("ec2", "ec2.security-groups", float64(len(groups.SecurityGroups)), defaults["ec2.security-groups"])
+
+	storage := make(map[string]float64)
+	err = svc.DescribeVolumesPages(&ec2.DescribeVolumesInput{}, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		for _, v := range page.Volumes {
+			storage[aws.StringValue(v.VolumeType)] += float64(aws.Int64Value(v.Size))
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for volumeType, size := range storage {
+		name := "ebs.storage-gib." + volumeType
+		add("ebs", name, size, defaults[name])
+	}
+	return nil
+}
+
+func autoscalingLimits(sess *session.Session, config *aws.Config, add addFunc) error {
+	svc := autoscaling.New(sess, config)
+	resp, err := svc.DescribeAccountLimits(&autoscaling.DescribeAccountLimitsInput{})
+	if err != nil {
+		return err
+	}
+	add("autoscaling", "autoscaling.groups", float64(aws.Int64Value(resp.NumberOfAutoScalingGroups)), float64(aws.Int64Value(resp.MaxNumberOfAutoScalingGroups)))
+	add("autoscaling", "autoscaling.launch-configurations", float64(aws.Int64Value(resp.NumberOfLaunchConfigurations)), float64(aws.Int64Value(resp.MaxNumberOfLaunchConfigurations)))
+	return nil
+}
+
+func rdsLimits(sess *session.Session, config *aws.Config, add addFunc) error {
+	svc := rds.New(sess, config)
+	resp, err := svc.DescribeAccountAttributes(&rds.DescribeAccountAttributesInput{})
+	if err != nil {
+		return err
+	}
+	for _, quota := range resp.AccountQuotas {
+		add("rds", "rds."+aws.StringValue(quota.AccountQuotaName), float64(aws.Int64Value(quota.Used)), float64(aws.Int64Value(quota.Max)))
+	}
+	return nil
+}
+
+func dynamodbLimits(db *storm.DB, sess *session.Session, config *aws.Config, account, region string, add addFunc) error {
+	svc := dynamodb.New(sess, config)
+	resp, err := svc.DescribeLimits(&dynamodb.DescribeLimitsInput{})
+	if err != nil {
+		return err
+	}
+
+	current := q.Gte("LastUpdated", time.Now().Add(-core.MaxResourceAge))
+	var tables []auntdynamodb.Table
+	err = db.Select(q.Eq("Account", account), q.Eq("Region", region), current).Find(&tables)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	reads := make(map[string]float64)
+	writes := make(map[string]float64)
+	var totalReads, totalWrites, maxReads, maxWrites float64
+	for _, t := range tables {
+		reads[t.Name] = float64(t.ReadCapacity)
+		writes[t.Name] = float64(t.WriteCapacity)
+		totalReads += reads[t.Name]
+		totalWrites += writes[t.Name]
+		if reads[t.Name] > maxReads {
+			maxReads = reads[t.Name]
+		}
+		if writes[t.Name] > maxWrites {
+			maxWrites = writes[t.Name]
+		}
+	}
+
+	// the account limits includes the capacity of the global secondary indexes
+	var indexes []auntdynamodb.GlobalSecondaryIndex
+	err = db.Select(q.Eq("Account", account), q.Eq("Region", region), current).Find(&indexes)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, idx := range indexes {
+		reads[idx.Label()] = float64(idx.ReadCapacity)
+		writes[idx.Label()] = float64(idx.WriteCapacity)
+		totalReads += reads[idx.Label()]
+		totalWrites += writes[idx.Label()]
+	}
+
+	add("dynamodb", "dynamodb.tables", float64(len(tables)), defaults["dynamodb.tables"])
+	add("dynamodb", "dynamodb.account-read-capacity", totalReads, float64(aws.Int64Value(resp.AccountMaxReadCapacityUnits))).Breakdown = reads
+	add("dynamodb", "dynamodb.account-write-capacity", totalWrites, float64(aws.Int64Value(resp.AccountMaxWriteCapacityUnits))).Breakdown = writes
+	// the table limits applies to every table, so the usage is the table with the highest capacity
+	add("dynamodb", "dynamodb.table-read-capacity", maxReads, float64(aws.Int64Value(resp.TableMaxReadCapacityUnits)))
+	add("dynamodb", "dynamodb.table-write-capacity", maxWrites, float64(aws.Int64Value(resp.TableMaxWriteCapacityUnits)))
+	return nil
+}
+
+func save(db *storm.DB, l *Limit) {
+	if max, ok := settings.Overrides[l.Name]; ok {
+		l.Max = max
+	}
+	l.Metrics = map[string]*float64{
+		metricUsage: aws.Float64(l.Usage),
+		metricLimit: aws.Float64(l.Max),
+	}
+	if l.Max > 0 {
+		l.Metrics[metricUsagePercent] = aws.Float64(l.Usage / l.Max * 100)
+	}
+	if err := db.Save(l); err != nil {
+		fmt.Printf("%+v\n", err)
+	}
+
+	// check usage
+	percent := l.Metrics[metricUsagePercent]
+	if percent == nil || *percent < settings.ThresholdPercent {
+		return
+	}
+	// limits belong to the account, so they are routed by the tags of the account
+	alert := core.ResourceAlert("AccountLimit."+l.Name, l.ResourceID, l.Account, l.Region, core.AccountTags(l.Account))
+	alert.Message = fmt.Sprintf("%s in %s %s is at %.0f%% of the limit (%.0f of %.0f)", l.Name, l.Account, l.Region, *percent, l.Usage, l.Max)
+	alert.Details["usage"] = fmt.Sprintf("%.0f", l.Usage)
+	alert.Details["limit"] = fmt.Sprintf("%.0f", l.Max)
+	alert.Description = breakdown(l.Breakdown)
+	core.SaveAlert(db, alert)
+}
+
+// breakdown returns the parts of the usage, largest first, one per line
+func breakdown(parts map[string]float64) string {
+	var names []string
+	for name := range parts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return parts[names[i]] > parts[names[j]] })
+	description := ""
+	for _, name := range names {
+		description = fmt.Sprintf("%s%s: %.0f\n", description, name, parts[name])
+	}
+	return description
+}
@@ -15,31 +15,120 @@ import (
 
 // DBInstance contains app specific information about an RDS
 type DBInstance struct {
-	Name         string
-	ResourceID   string `storm:"id"`
-	LaunchTime   *time.Time
-	Region       string
-	Account      string
-	InstanceType string
-	State        string
-	LastUpdated  time.Time
-	Metrics      map[string]*float64
+	Name          string
+	ResourceID    string `storm:"id"`
+	LaunchTime    *time.Time
+	Region        string
+	Account       string
+	InstanceType  string
+	Engine        string
+	EngineVersion string
+	MultiAZ       bool
+	// AllocatedStorage is the storage size in GiB
+	AllocatedStorage int64
+	// ReplicaSource is the instance that this instance is a read replica of
+	ReplicaSource string
+	// ClusterID is set for instances in an Aurora cluster
+	ClusterID   string
+	State       string
+	Tags        map[string]string
+	LastUpdated time.Time
+	Metrics     map[string]*float64
+}
+
+// DBCluster contains app specific information about an Aurora cluster
+type DBCluster struct {
+	Name           string
+	ResourceID     string `storm:"id"`
+	LaunchTime     *time.Time
+	Region         string
+	Account        string
+	Engine         string
+	EngineVersion  string
+	MultiAZ        bool
+	Endpoint       string
+	ReaderEndpoint string
+	// Writer is the instance that is the current writer in the cluster
+	Writer      string
+	Readers     []string
+	State       string
+	Tags        map[string]string
+	LastUpdated time.Time
+	Metrics     map[string]*float64
 }
 
 const (
-	metricCredits = "CPUCreditBalance"
-	metricsCPU    = "CPUUtilization"
+	metricCredits      = "CPUCreditBalance"
+	metricsCPU         = "CPUUtilization"
+	metricFreeStorage  = "FreeStorageSpace"
+	metricReplicaLag   = "ReplicaLag"
+	metricConnections  = "DatabaseConnections"
+	metricFreeMemory   = "FreeableMemory"
+	metricReadLatency  = "ReadLatency"
+	metricWriteLatency = "WriteLatency"
+
+	// derived metrics
+	metricFreeStoragePercent = "FreeStoragePercent"
+	metricDaysUntilFull      = "DaysUntilFull"
+	metricConnectionsPercent = "DatabaseConnectionsPercent"
+
+	// cluster metrics
+	metricVolumeBytesUsed  = "VolumeBytesUsed"
+	metricAuroraReplicaLag = "AuroraReplicaLagMaximum"
 )
 
 // different threshold depednding on db instance type
 const (
 	metricsCreditsThreshold float64 = 15
 	metricsCPUThreshold     float64 = 70.0
+	// freeStorageThreshold is the free storage in percent of the allocated storage
+	freeStorageThreshold   float64 = 10
+	daysUntilFullThreshold float64 = 7
+	// replicaLagThreshold is in seconds
+	replicaLagThreshold float64 = 300
+	// connectionsThreshold is the connections in percent of the max connections for the instance class
+	connectionsThreshold float64 = 80
+	// freeMemoryThreshold is the freeable memory in percent of the memory for the instance class
+	freeMemoryThreshold float64 = 5
+	// minFreeMemory is used when the memory for the instance class isn't known
+	minFreeMemory float64 = 256 * 1024 * 1024
+	// latency thresholds are in seconds
+	readLatencyThreshold  float64 = 0.02
+	writeLatencyThreshold float64 = 0.05
+	// auroraReplicaLagThreshold is in milliseconds
+	auroraReplicaLagThreshold float64 = 1000
 )
 
+// storageForecastWindow is how much history that is used to project when the storage is full
+const storageForecastWindow = 7 * 24 * time.Hour
+
+// storageForecastMinHistory is how much history that is needed before a projection is made
+const storageForecastMinHistory = 6 * time.Hour
+
 var metrics = []string{metricCredits, metricsCPU}
 
-// Update will update the database with DBInstance data
+// statistics are the CloudWatch statistics for the metrics that aren't averages
+var statistics = map[string]string{
+	metricFreeStorage:  cloudwatch.StatisticMinimum,
+	metricReplicaLag:   cloudwatch.StatisticMaximum,
+	metricConnections:  cloudwatch.StatisticMaximum,
+	metricFreeMemory:   cloudwatch.StatisticMinimum,
+	metricReadLatency:  cloudwatch.StatisticAverage,
+	metricWriteLatency: cloudwatch.StatisticAverage,
+}
+
+// classMemoryGiB is the memory for the common instance classes, it is used for the max connections and free memory
+var classMemoryGiB = map[string]float64{
+	"db.t2.micro": 1, "db.t2.small": 2, "db.t2.medium": 4, "db.t2.large": 8, "db.t2.xlarge": 16, "db.t2.2xlarge": 32,
+	"db.t3.micro": 1, "db.t3.small": 2, "db.t3.medium": 4, "db.t3.large": 8, "db.t3.xlarge": 16, "db.t3.2xlarge": 32,
+	"db.m4.large": 8, "db.m4.xlarge": 16, "db.m4.2xlarge": 32, "db.m4.4xlarge": 64, "db.m4.10xlarge": 160, "db.m4.16xlarge": 256,
+	"db.m5.large": 8, "db.m5.xlarge": 16, "db.m5.2xlarge": 32, "db.m5.4xlarge": 64, "db.m5.12xlarge": 192, "db.m5.24xlarge": 384,
+	"db.r3.large": 15.25, "db.r3.xlarge": 30.5, "db.r3.2xlarge": 61, "db.r3.4xlarge": 122, "db.r3.8xlarge": 244,
+	"db.r4.large": 15.25, "db.r4.xlarge": 30.5, "db.r4.2xlarge": 61, "db.r4.4xlarge": 122, "db.r4.8xlarge": 244, "db.r4.16xlarge": 488,
+	"db.r5.large": 16, "db.r5.xlarge": 32, "db.r5.2xlarge": 64, "db.r5.4xlarge": 128, "db.r5.12xlarge": 384, "db.r5.24xlarge": 768,
+}
+
+// Update will update the database with DBInstance and DBCluster data
 func Update(db *storm.DB, roles map[string]string, regions []string) error {
 	var wg sync.WaitGroup
 	wg.Add(len(roles))
@@ -69,15 +158,22 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 
 		for _, i := range resp.DBInstances {
 			instance := &DBInstance{
-				Name:         strings.Replace(*i.DBInstanceIdentifier, "-", ".", -1) + ".db",
-				ResourceID:   *i.DBInstanceIdentifier,
-				Region:       *config.Region,
-				Account:      account,
-				InstanceType: *i.DBInstanceClass,
-				LaunchTime:   i.InstanceCreateTime,
-				State:        *i.DBInstanceStatus,
-				LastUpdated:  time.Now(),
-				Metrics:      make(map[string]*float64),
+				Name:             strings.Replace(*i.DBInstanceIdentifier, "-", ".", -1) + ".db",
+				ResourceID:       *i.DBInstanceIdentifier,
+				Region:           *config.Region,
+				Account:          account,
+				InstanceType:     *i.DBInstanceClass,
+				Engine:           aws.StringValue(i.Engine),
+				EngineVersion:    aws.StringValue(i.EngineVersion),
+				MultiAZ:          aws.BoolValue(i.MultiAZ),
+				AllocatedStorage: aws.Int64Value(i.AllocatedStorage),
+				ReplicaSource:    aws.StringValue(i.ReadReplicaSourceDBInstanceIdentifier),
+				ClusterID:        aws.StringValue(i.DBClusterIdentifier),
+				LaunchTime:       i.InstanceCreateTime,
+				State:            *i.DBInstanceStatus,
+				Tags:             tags(svc, i.DBInstanceArn),
+				LastUpdated:      time.Now(),
+				Metrics:          make(map[string]*float64),
 			}
 
 			dimensions := []*cloudwatch.Dimension{{Name: aws.String("DBInstanceIdentifier"), Value: i.DBInstanceIdentifier}}
@@ -85,34 +181,282 @@ func updateForRole(db *storm.DB, account, role string, regions []string) {
 			for _, name := range metrics {
 				instance.Metrics[name] = metric("AWS/RDS", dimensions, name, cw)
 			}
+			for name, statistic := range statistics {
+				instance.Metrics[name] = core.Metric(cw, "AWS/RDS", dimensions, name, statistic)
+			}
+			derive(db, instance)
 			if err := db.Save(instance); err != nil {
 				fmt.Printf("%+v\n", err)
 			}
+			anomalies, _, err := core.Anomalies(db, "DBInstance", instance.ResourceID, instance.Metrics)
+			if err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			if err := core.RecordMetrics(db, "DBInstance", instance.ResourceID, instance.Metrics); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			for _, alert := range anomalies {
+				alert.Message = fmt.Sprintf("%s for %s", alert.Message, instance.Name)
+				setDetails(alert, instance)
+				saveAlert(db, alert)
+			}
+			check(db, instance)
+		}
 
-			//check metrics
-			credits := instance.Metrics[metricCredits]
-			if credits != nil && *credits < metricsCreditsThreshold {
-				alert := core.NewAlert(metricCredits, instance.ResourceID)
-				alert.Message = fmt.Sprintf("CPU credits (%.1f) is below %.1f for %s", *credits, metricsCreditsThreshold, instance.Name)
-				alert.Details["account"] = instance.Account
-				alert.Details["region"] = instance.Region
-				alert.Details["resource_id"] = instance.ResourceID
-				if err := alert.Save(db); err != nil {
-					fmt.Printf("%+v\n", err)
-				}
+		updateClusters(db, svc, cw, account, role, region)
+	}
+}
+
+// derive calculates the metrics that are relative to the instance size or based on the metric history
+func derive(db *storm.DB, instance *DBInstance) {
+	free := instance.Metrics[metricFreeStorage]
+	// Aurora storage is a cluster volume that grows by itself, FreeStorageSpace is the local temporary storage
+	if free != nil && instance.AllocatedStorage > 0 && !isAurora(instance.Engine) {
+		allocated := float64(instance.AllocatedStorage) * 1024 * 1024 * 1024
+		instance.Metrics[metricFreeStoragePercent] = aws.Float64(*free / allocated * 100)
+
+		history, err := core.History(db, "DBInstance", instance.ResourceID, metricFreeStorage, time.Now().Add(-storageForecastWindow))
+		if err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+		if len(history) > 0 && time.Since(history[0].Time) >= storageForecastMinHistory {
+			history = append(history, core.Sample{Time: time.Now(), Value: *free})
+			if slope, ok := core.Slope(history); ok && slope < 0 {
+				instance.Metrics[metricDaysUntilFull] = aws.Float64(*free / -slope / (24 * 60 * 60))
 			}
-			cpu := instance.Metrics[metricsCPU]
-			if cpu != nil && *cpu > metricsCPUThreshold {
-				alert := core.NewAlert(metricCredits, instance.ResourceID)
-				alert.Message = fmt.Sprintf("CPU Utilisation (%.1f) is above %.1f for %s", *cpu, metricsCPUThreshold, instance.Name)
-				alert.Details["account"] = instance.Account
-				alert.Details["region"] = instance.Region
-				alert.Details["resource_id"] = instance.ResourceID
-				if err := alert.Save(db); err != nil {
-					fmt.Printf("%+v\n", err)
+		}
+	}
+
+	if err := core.Forecast(db, "DBInstance", instance.ResourceID, metricCredits, instance.Metrics); err != nil {
+		fmt.Printf("%+v\n", err)
+	}
+
+	if connections := instance.Metrics[metricConnections]; connections != nil {
+		if max := maxConnections(instance.Engine, instance.InstanceType); max > 0 {
+			instance.Metrics[metricConnectionsPercent] = aws.Float64(*connections / max * 100)
+		}
+	}
+}
+
+func check(db *storm.DB, instance *DBInstance) {
+	credits := instance.Metrics[metricCredits]
+	if credits != nil && *credits < metricsCreditsThreshold {
+		alert := newAlert(metricCredits, instance)
+		alert.Message = fmt.Sprintf("CPU credits (%.1f) is below %.1f for %s", *credits, metricsCreditsThreshold, instance.Name)
+		saveAlert(db, alert)
+	}
+	for _, alert := range core.ForecastAlerts(instance.ResourceID, instance.Metrics) {
+		alert.Message = fmt.Sprintf("%s for %s", alert.Message, instance.Name)
+		setDetails(alert, instance)
+		saveAlert(db, alert)
+	}
+	cpu := instance.Metrics[metricsCPU]
+	if cpu != nil && *cpu > metricsCPUThreshold {
+		alert := newAlert(metricsCPU, instance)
+		alert.Message = fmt.Sprintf("CPU Utilisation (%.1f) is above %.1f for %s", *cpu, metricsCPUThreshold, instance.Name)
+		saveAlert(db, alert)
+	}
+
+	freeStorage := instance.Metrics[metricFreeStoragePercent]
+	if freeStorage != nil && *freeStorage < freeStorageThreshold {
+		alert := newAlert(metricFreeStorage, instance)
+		alert.Message = fmt.Sprintf("Free storage (%.1f%%) is below %.0f%% for %s", *freeStorage, freeStorageThreshold, instance.Name)
+		alert.Details["free_bytes"] = fmt.Sprintf("%.0f", *instance.Metrics[metricFreeStorage])
+		saveAlert(db, alert)
+	}
+	daysUntilFull := instance.Metrics[metricDaysUntilFull]
+	if daysUntilFull != nil && *daysUntilFull < daysUntilFullThreshold {
+		alert := newAlert(metricDaysUntilFull, instance)
+		alert.Message = fmt.Sprintf("Storage will be full in %.1f days for %s", *daysUntilFull, instance.Name)
+		alert.Priority = core.P3
+		saveAlert(db, alert)
+	}
+
+	lag := instance.Metrics[metricReplicaLag]
+	if instance.ReplicaSource != "" && lag != nil && *lag > replicaLagThreshold {
+		alert := newAlert(metricReplicaLag, instance)
+		alert.Message = fmt.Sprintf("Replica lag (%.0fs) is above %.0fs for %s", *lag, replicaLagThreshold, instance.Name)
+		alert.Details["replica_source"] = instance.ReplicaSource
+		saveAlert(db, alert)
+	}
+
+	connections := instance.Metrics[metricConnectionsPercent]
+	if connections != nil && *connections > connectionsThreshold {
+		alert := newAlert(metricConnections, instance)
+		alert.Message = fmt.Sprintf("Database connections (%.0f) is %.0f%% of the max for %s", *instance.Metrics[metricConnections], *connections, instance.Name)
+		alert.Details["max_connections"] = fmt.Sprintf("%.0f", maxConnections(instance.Engine, instance.InstanceType))
+		saveAlert(db, alert)
+	}
+
+	if freeMemory := instance.Metrics[metricFreeMemory]; freeMemory != nil {
+		threshold := minFreeMemory
+		if memory, ok := classMemoryGiB[instance.InstanceType]; ok {
+			threshold = memory * 1024 * 1024 * 1024 * freeMemoryThreshold / 100
+		}
+		if *freeMemory < threshold {
+			alert := newAlert(metricFreeMemory, instance)
+			alert.Message = fmt.Sprintf("Freeable memory (%.0f MiB) is below %.0f MiB for %s", *freeMemory/1024/1024, threshold/1024/1024, instance.Name)
+			saveAlert(db, alert)
+		}
+	}
+
+	readLatency := instance.Metrics[metricReadLatency]
+	if readLatency != nil && *readLatency > readLatencyThreshold {
+		alert := newAlert(metricReadLatency, instance)
+		alert.Message = fmt.Sprintf("Read latency (%.0fms) is above %.0fms for %s", *readLatency*1000, readLatencyThreshold*1000, instance.Name)
+		saveAlert(db, alert)
+	}
+	writeLatency := instance.Metrics[metricWriteLatency]
+	if writeLatency != nil && *writeLatency > writeLatencyThreshold {
+		alert := newAlert(metricWriteLatency, instance)
+		alert.Message = fmt.Sprintf("Write latency (%.0fms) is above %.0fms for %s", *writeLatency*1000, writeLatencyThreshold*1000, instance.Name)
+		saveAlert(db, alert)
+	}
+}
+
+func updateClusters(db *storm.DB, svc *rds.RDS, cw *cloudwatch.CloudWatch, account, role, region string) {
+	input := &rds.DescribeDBClustersInput{}
+	for {
+		resp, err := svc.DescribeDBClusters(input)
+		if err != nil {
+			fmt.Printf("rds.DescribeDBClusters %s %s %v\n", role, region, err)
+			return
+		}
+		for _, c := range resp.DBClusters {
+			cluster := &DBCluster{
+				Name:           *c.DBClusterIdentifier,
+				ResourceID:     *c.DBClusterIdentifier,
+				LaunchTime:     c.ClusterCreateTime,
+				Region:         region,
+				Account:        account,
+				Engine:         aws.StringValue(c.Engine),
+				EngineVersion:  aws.StringValue(c.EngineVersion),
+				MultiAZ:        aws.BoolValue(c.MultiAZ),
+				Endpoint:       aws.StringValue(c.Endpoint),
+				ReaderEndpoint: aws.StringValue(c.ReaderEndpoint),
+				State:          aws.StringValue(c.Status),
+				Tags:           tags(svc, c.DBClusterArn),
+				LastUpdated:    time.Now(),
+				Metrics:        make(map[string]*float64),
+			}
+			for _, member := range c.DBClusterMembers {
+				if aws.BoolValue(member.IsClusterWriter) {
+					cluster.Writer = aws.StringValue(member.DBInstanceIdentifier)
+				} else {
+					cluster.Readers = append(cluster.Readers, aws.StringValue(member.DBInstanceIdentifier))
 				}
 			}
+
+			dimensions := []*cloudwatch.Dimension{{Name: aws.String("DBClusterIdentifier"), Value: c.DBClusterIdentifier}}
+			cluster.Metrics[metricVolumeBytesUsed] = core.Metric(cw, "AWS/RDS", dimensions, metricVolumeBytesUsed, cloudwatch.StatisticMaximum)
+			cluster.Metrics[metricAuroraReplicaLag] = core.Metric(cw, "AWS/RDS", dimensions, metricAuroraReplicaLag, cloudwatch.StatisticMaximum)
+			if err := db.Save(cluster); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			if err := core.RecordMetrics(db, "DBCluster", cluster.ResourceID, cluster.Metrics); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+			checkCluster(db, cluster)
+		}
+		if resp.Marker == nil {
+			return
+		}
+		input.Marker = resp.Marker
+	}
+}
+
+func checkCluster(db *storm.DB, cluster *DBCluster) {
+	newClusterAlert := func(name string) *core.Alert {
+		alert := core.NewAlert(name, cluster.ResourceID)
+		alert.Details["account"] = cluster.Account
+		alert.Details["region"] = cluster.Region
+		alert.Details["resource_id"] = cluster.ResourceID
+		alert.Details["engine"] = cluster.Engine
+		alert.Details["engine_version"] = cluster.EngineVersion
+		alert.Details["multi_az"] = fmt.Sprintf("%t", cluster.MultiAZ)
+		alert.Details["status"] = cluster.State
+		alert.ResourceTags = cluster.Tags
+		return alert
+	}
+	if cluster.State == "available" && cluster.Writer == "" {
+		alert := newClusterAlert("ClusterWithoutWriter")
+		alert.Message = fmt.Sprintf("Cluster %s doesn't have a writer instance", cluster.Name)
+		alert.Priority = core.P1
+		saveAlert(db, alert)
+	}
+	lag := cluster.Metrics[metricAuroraReplicaLag]
+	if len(cluster.Readers) > 0 && lag != nil && *lag > auroraReplicaLagThreshold {
+		alert := newClusterAlert(metricAuroraReplicaLag)
+		alert.Message = fmt.Sprintf("Replica lag (%.0fms) is above %.0fms for cluster %s", *lag, auroraReplicaLagThreshold, cluster.Name)
+		alert.Details["readers"] = strings.Join(cluster.Readers, ", ")
+		saveAlert(db, alert)
+	}
+}
+
+// maxConnections returns the default max_connections for the engine and instance class, it's zero when unknown
+func maxConnections(engine, instanceClass string) float64 {
+	memory, ok := classMemoryGiB[instanceClass]
+	if !ok {
+		return 0
+	}
+	bytes := memory * 1024 * 1024 * 1024
+	switch {
+	case strings.HasPrefix(engine, "mysql"), strings.HasPrefix(engine, "mariadb"), strings.HasPrefix(engine, "aurora"):
+		return float64(int64(bytes / 12582880))
+	case strings.HasPrefix(engine, "postgres"):
+		max := float64(int64(bytes / 9531392))
+		if max > 5000 {
+			max = 5000
 		}
+		return max
+	}
+	return 0
+}
+
+func isAurora(engine string) bool {
+	return strings.HasPrefix(engine, "aurora")
+}
+
+func newAlert(name string, instance *DBInstance) *core.Alert {
+	alert := core.NewAlert(name, instance.ResourceID)
+	setDetails(alert, instance)
+	return alert
+}
+
+func setDetails(alert *core.Alert, instance *DBInstance) {
+	alert.Details["account"] = instance.Account
+	alert.Details["region"] = instance.Region
+	alert.Details["resource_id"] = instance.ResourceID
+	alert.Details["engine"] = instance.Engine
+	alert.Details["engine_version"] = instance.EngineVersion
+	alert.Details["instance_class"] = instance.InstanceType
+	alert.Details["multi_az"] = fmt.Sprintf("%t", instance.MultiAZ)
+	if instance.ClusterID != "" {
+		alert.Details["cluster"] = instance.ClusterID
+	}
+	alert.ResourceTags = instance.Tags
+}
+
+// tags returns the tags of an instance or cluster, they are only listed one resource at a time
+func tags(svc *rds.RDS, arn *string) map[string]string {
+	result := make(map[string]string)
+	if arn == nil {
+		return result
+	}
+	resp, err := svc.ListTagsForResource(&rds.ListTagsForResourceInput{ResourceName: arn})
+	if err != nil {
+		fmt.Printf("rds.ListTagsForResource %s %v\n", *arn, err)
+		return result
+	}
+	for _, tag := range resp.TagList {
+		result[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return result
+}
+
+func saveAlert(db *storm.DB, alert *core.Alert) {
+	if err := alert.Save(db); err != nil {
+		fmt.Printf("%+v\n", err)
 	}
 }
 
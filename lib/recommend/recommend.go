@@ -0,0 +1,413 @@
+package recommend
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/stojg/aunt/lib/core"
+	"github.com/stojg/aunt/lib/cost"
+	"github.com/stojg/aunt/lib/dynamodb"
+	"github.com/stojg/aunt/lib/ec2"
+	"github.com/stojg/aunt/lib/rds"
+)
+
+// Recommendation is a suggested change to the size of a resource
+type Recommendation struct {
+	// ResourceID is the kind and the id of the resource, e.g. Instance/i-123456
+	ResourceID string `storm:"id"`
+	Kind       string `storm:"index"`
+	Name       string
+	Resource   string
+	Current    string
+	Suggested  string
+	Reason     string
+	// MonthlySavings is the estimated monthly saving, it is negative when the suggestion costs more and nil when the
+	// price list doesn't have a price for the current or the suggested size
+	MonthlySavings *float64
+	Region         string
+	Account        string
+	Tags           map[string]string
+	LastUpdated    time.Time `storm:"index"`
+}
+
+// Config holds the window and thresholds for the recommendations
+type Config struct {
+	// WindowDays is how many days of metric history the recommendations are based on
+	WindowDays int
+	// MinDays is how many days of history that is needed before a resource gets a recommendation
+	MinDays int
+	// LowCPUPercent is the p95 CPU utilisation under which an instance should be downsized
+	LowCPUPercent float64
+	// OutOfCreditsPercent is how much of the time a burstable instance can be out of CPU credits before it should move
+	// to a fixed performance instance
+	OutOfCreditsPercent float64
+	// LowCapacityPercent is the peak consumed capacity in percent of the provisioned capacity under which a DynamoDB
+	// table should have its capacity lowered
+	LowCapacityPercent float64
+}
+
+// Kinds of resources with recommendations, these are also the kinds used for the metric history
+const (
+	KindInstance   = "Instance"
+	KindDBInstance = "DBInstance"
+	KindTable      = "Table"
+)
+
+const (
+	metricCPU           = "CPUUtilization"
+	metricCredits       = "CPUCreditBalance"
+	metricConsumedRead  = "ConsumedReadCapacityUnits"
+	metricConsumedWrite = "ConsumedWriteCapacityUnits"
+)
+
+// outOfCredits is the credit balance where a burstable instance is considered to be out of credits
+const outOfCredits = 1.0
+
+// capacityHeadroom is how much above the peak consumed capacity a lowered capacity is set to
+const capacityHeadroom = 1.5
+
+// sizes are the instance sizes from the smallest to the largest, not every family has every size
+var sizes = []string{"nano", "micro", "small", "medium", "large", "xlarge", "2xlarge", "4xlarge", "8xlarge", "9xlarge",
+	"10xlarge", "12xlarge", "16xlarge", "18xlarge", "24xlarge", "32xlarge"}
+
+// burstableFamilies maps burstable instance families to the fixed performance family to move to
+var burstableFamilies = map[string]string{
+	"t2":  "m5",
+	"t3":  "m5",
+	"t3a": "m5a",
+}
+
+var settings = Config{
+	WindowDays:          14,
+	MinDays:             7,
+	LowCPUPercent:       10,
+	OutOfCreditsPercent: 75,
+	LowCapacityPercent:  30,
+}
+
+// Configure overrides the default window and thresholds, zero values keep the defaults
+func Configure(cfg Config) error {
+	if cfg.WindowDays > 0 {
+		settings.WindowDays = cfg.WindowDays
+	}
+	if cfg.MinDays > 0 {
+		settings.MinDays = cfg.MinDays
+	}
+	if cfg.LowCPUPercent > 0 {
+		settings.LowCPUPercent = cfg.LowCPUPercent
+	}
+	if cfg.OutOfCreditsPercent > 0 {
+		settings.OutOfCreditsPercent = cfg.OutOfCreditsPercent
+	}
+	if cfg.LowCapacityPercent > 0 {
+		settings.LowCapacityPercent = cfg.LowCapacityPercent
+	}
+	if settings.MinDays > settings.WindowDays {
+		return fmt.Errorf("recommendation MinDays (%d) is longer than WindowDays (%d)", settings.MinDays, settings.WindowDays)
+	}
+	return nil
+}
+
+// WindowDays returns how many days of metric history the recommendations need
+func WindowDays() int {
+	return settings.WindowDays
+}
+
+// Update will update the database with Recommendation data. It uses the stored resources and their metric history so
+// it should run after those has been updated.
+func Update(db *storm.DB) error {
+	prices, err := cost.LoadPrices(cost.PriceFile())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// without a price list the recommendations are still made, but without savings
+	if prices == nil {
+		prices = &cost.Prices{}
+	}
+
+	started := time.Now()
+	since := started.Add(-core.MaxResourceAge)
+	var recommendations []*Recommendation
+
+	var instances []ec2.Instance
+	if err := db.Select(q.Gte("LastUpdated", since), q.Eq("State", "running")).Find(&instances); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, i := range instances {
+		r, err := instanceRecommendation(db, KindInstance, i.ResourceID, i.InstanceType, prices.InstanceMonthly, i.Region)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			r.Name, r.Region, r.Account, r.Tags = i.Name, i.Region, i.Account, i.Tags
+			recommendations = append(recommendations, r)
+		}
+	}
+
+	var dbInstances []rds.DBInstance
+	if err := db.Select(q.Gte("LastUpdated", since), q.Eq("State", "available")).Find(&dbInstances); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, i := range dbInstances {
+		r, err := instanceRecommendation(db, KindDBInstance, i.ResourceID, i.InstanceType, prices.DBInstanceMonthly, i.Region)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			// the standby in a multi AZ deployment is resized as well
+			if r.MonthlySavings != nil && i.MultiAZ {
+				*r.MonthlySavings *= 2
+			}
+			r.Name, r.Region, r.Account, r.Tags = i.Name, i.Region, i.Account, i.Tags
+			recommendations = append(recommendations, r)
+		}
+	}
+
+	var tables []dynamodb.Table
+	if err := db.Select(q.Gte("LastUpdated", since)).Find(&tables); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, t := range tables {
+		r, err := tableRecommendation(db, t, prices)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			recommendations = append(recommendations, r)
+		}
+	}
+
+	for _, r := range recommendations {
+		r.LastUpdated = started
+		if err := db.Save(r); err != nil {
+			fmt.Printf("%+v\n", err)
+		}
+	}
+
+	// anything that didn't get a recommendation during this update has been removed or resized
+	var stale []Recommendation
+	if err := db.Select(q.Lt("LastUpdated", started)).Find(&stale); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for i := range stale {
+		if err := db.DeleteStruct(&stale[i]); err != nil {
+			fmt.Printf("recommend purge error: %v %s\n", err, stale[i].ResourceID)
+		}
+	}
+	return nil
+}
+
+// Report returns the current recommendations for resources that matches the tag filter, largest savings first
+func Report(db *storm.DB, filter core.TagFilter) ([]Recommendation, error) {
+	var all []Recommendation
+	if err := db.All(&all); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	var recommendations []Recommendation
+	for _, r := range all {
+		if filter.Matches(r.Tags) {
+			recommendations = append(recommendations, r)
+		}
+	}
+	savings := func(r Recommendation) float64 {
+		if r.MonthlySavings == nil {
+			return 0
+		}
+		return *r.MonthlySavings
+	}
+	sort.Slice(recommendations, func(i, j int) bool {
+		a, b := recommendations[i], recommendations[j]
+		if savings(a) != savings(b) {
+			return savings(a) > savings(b)
+		}
+		return a.ResourceID < b.ResourceID
+	})
+	return recommendations, nil
+}
+
+// instanceRecommendation checks EC2 and RDS instances, the RDS instance classes has a db. prefix but otherwise follow
+// the same naming as the EC2 instance types
+func instanceRecommendation(db *storm.DB, kind, resourceID, instanceType string, monthly func(region, instanceType string) (float64, bool), region string) (*Recommendation, error) {
+	prefix := ""
+	if strings.HasPrefix(instanceType, "db.") {
+		prefix = "db."
+	}
+	family, size := splitType(strings.TrimPrefix(instanceType, prefix))
+	if family == "" {
+		return nil, nil
+	}
+	window := time.Now().AddDate(0, 0, -settings.WindowDays)
+
+	newRecommendation := func(suggested, reason string) *Recommendation {
+		r := &Recommendation{
+			ResourceID: kind + "/" + resourceID,
+			Kind:       kind,
+			Resource:   resourceID,
+			Current:    instanceType,
+			Suggested:  suggested,
+			Reason:     reason,
+		}
+		current, ok := monthly(region, instanceType)
+		if !ok {
+			return r
+		}
+		if next, ok := monthly(region, suggested); ok {
+			savings := current - next
+			r.MonthlySavings = &savings
+		}
+		return r
+	}
+
+	// a burstable instance that is out of credits most of the time is throttled, it needs a fixed performance instance
+	if fixed, ok := burstableFamilies[family]; ok {
+		credits, err := core.History(db, kind, resourceID, metricCredits, window)
+		if err != nil {
+			return nil, err
+		}
+		if enoughHistory(credits) {
+			out := 0
+			for _, s := range credits {
+				if s.Value < outOfCredits {
+					out++
+				}
+			}
+			percent := float64(out) / float64(len(credits)) * 100
+			if percent >= settings.OutOfCreditsPercent {
+				// the fixed performance families starts at large
+				if sizeIndex(size) < sizeIndex("large") {
+					size = "large"
+				}
+				reason := fmt.Sprintf("Out of CPU credits %.0f%% of the time over %d days", percent, settings.WindowDays)
+				return newRecommendation(prefix+fixed+"."+size, reason), nil
+			}
+		}
+	}
+
+	cpu, err := core.History(db, kind, resourceID, metricCPU, window)
+	if err != nil {
+		return nil, err
+	}
+	if !enoughHistory(cpu) {
+		return nil, nil
+	}
+	p95 := percentile(cpu, 95)
+	if p95 >= settings.LowCPUPercent {
+		return nil, nil
+	}
+	idx := sizeIndex(size)
+	if idx < 1 {
+		return nil, nil
+	}
+	smaller := prefix + family + "." + sizes[idx-1]
+	// not every family has every size, the price list knows which ones that exists
+	if _, ok := monthly(region, instanceType); ok {
+		if _, ok := monthly(region, smaller); !ok {
+			return nil, nil
+		}
+	}
+	reason := fmt.Sprintf("p95 CPU utilisation is %.1f%% over %d days", p95, settings.WindowDays)
+	return newRecommendation(smaller, reason), nil
+}
+
+func tableRecommendation(db *storm.DB, t dynamodb.Table, prices *cost.Prices) (*Recommendation, error) {
+	// on demand tables doesn't have any provisioned capacity and auto scaled tables are already resized
+	if t.BillingMode == dynamodb.BillingModePayPerRequest || t.ReadAutoScaling != nil || t.WriteAutoScaling != nil {
+		return nil, nil
+	}
+	window := time.Now().AddDate(0, 0, -settings.WindowDays)
+	read, err := core.History(db, KindTable, t.ResourceID, metricConsumedRead, window)
+	if err != nil {
+		return nil, err
+	}
+	write, err := core.History(db, KindTable, t.ResourceID, metricConsumedWrite, window)
+	if err != nil {
+		return nil, err
+	}
+	if !enoughHistory(read) || !enoughHistory(write) {
+		return nil, nil
+	}
+
+	lower := func(provisioned int64, samples []core.Sample) (int64, float64) {
+		peak := percentile(samples, 100)
+		percent := peak / float64(provisioned) * 100
+		if provisioned <= 1 || percent >= settings.LowCapacityPercent {
+			return provisioned, percent
+		}
+		suggested := int64(math.Ceil(peak * capacityHeadroom))
+		if suggested < 1 {
+			suggested = 1
+		}
+		return suggested, percent
+	}
+	suggestedRead, readPercent := lower(t.ReadCapacity, read)
+	suggestedWrite, writePercent := lower(t.WriteCapacity, write)
+	if suggestedRead == t.ReadCapacity && suggestedWrite == t.WriteCapacity {
+		return nil, nil
+	}
+
+	r := &Recommendation{
+		ResourceID: KindTable + "/" + t.ResourceID,
+		Kind:       KindTable,
+		Name:       t.Name,
+		Resource:   t.ResourceID,
+		Current:    fmt.Sprintf("%d RCU %d WCU", t.ReadCapacity, t.WriteCapacity),
+		Suggested:  fmt.Sprintf("%d RCU %d WCU", suggestedRead, suggestedWrite),
+		Reason:     fmt.Sprintf("Peak consumed capacity is %.0f%% of reads and %.0f%% of writes over %d days", readPercent, writePercent, settings.WindowDays),
+		Region:     t.Region,
+		Account:    t.Account,
+		Tags:       t.Tags,
+	}
+	current, ok := prices.CapacityMonthly(t.Region, t.ReadCapacity, t.WriteCapacity)
+	if ok {
+		next, _ := prices.CapacityMonthly(t.Region, suggestedRead, suggestedWrite)
+		savings := current - next
+		r.MonthlySavings = &savings
+	}
+	return r, nil
+}
+
+// enoughHistory checks that the samples goes back at least MinDays, so that new resources aren't judged on a few hours
+func enoughHistory(samples []core.Sample) bool {
+	if len(samples) == 0 {
+		return false
+	}
+	return samples[0].Time.Before(time.Now().AddDate(0, 0, -settings.MinDays))
+}
+
+// percentile returns the nearest rank percentile of the sample values
+func percentile(samples []core.Sample, p float64) float64 {
+	values := make([]float64, len(samples))
+	for i, s := range samples {
+		values[i] = s.Value
+	}
+	sort.Float64s(values)
+	rank := int(math.Ceil(p/100*float64(len(values)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return values[rank]
+}
+
+// splitType splits an instance type like m4.large into the family and the size
+func splitType(instanceType string) (string, string) {
+	parts := strings.SplitN(instanceType, ".", 2)
+	if len(parts) != 2 || sizeIndex(parts[1]) < 0 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+func sizeIndex(size string) int {
+	for i, s := range sizes {
+		if s == size {
+			return i
+		}
+	}
+	return -1
+}
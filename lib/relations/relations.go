@@ -0,0 +1,85 @@
+package relations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/stojg/aunt/lib/asg"
+	"github.com/stojg/aunt/lib/core"
+	"github.com/stojg/aunt/lib/ebs"
+	"github.com/stojg/aunt/lib/rds"
+)
+
+// Update rebuilds the relations between the stored resources: auto scaling groups to their instances, instances to
+// their volumes, RDS clusters to their instances and RDS primaries to their read replicas. It uses the stored
+// resources so it should run after those has been updated.
+func Update(db *storm.DB) error {
+	started := time.Now()
+	since := started.Add(-core.MaxResourceAge)
+
+	var groups []asg.AutoScalingGroup
+	if err := db.Select(q.Gte("LastUpdated", since)).Find(&groups); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, group := range groups {
+		for _, instance := range group.Instances {
+			save(db, instance, "Instance", group.ResourceID, "AutoScalingGroup")
+		}
+	}
+
+	var volumes []ebs.Volume
+	if err := db.Select(q.Gte("LastUpdated", since)).Find(&volumes); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, volume := range volumes {
+		if volume.InstanceID != "" {
+			save(db, volume.ResourceID, "Volume", volume.InstanceID, "Instance")
+		}
+	}
+
+	var instances []rds.DBInstance
+	if err := db.Select(q.Gte("LastUpdated", since)).Find(&instances); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, instance := range instances {
+		switch {
+		case instance.ReplicaSource != "":
+			// cross region replicas has the ARN of the source, e.g. arn:aws:rds:us-east-1:123456789012:db:primary
+			source := instance.ReplicaSource
+			if i := strings.LastIndex(source, ":db:"); i >= 0 {
+				source = source[i+len(":db:"):]
+			}
+			save(db, instance.ResourceID, "DBInstance", source, "DBInstance")
+		case instance.ClusterID != "":
+			save(db, instance.ResourceID, "DBInstance", instance.ClusterID, "DBCluster")
+		}
+	}
+
+	// relations that wasn't found during this update are for resources that has been removed or moved
+	var stale []core.Relation
+	if err := db.Select(q.Lt("LastUpdated", started)).Find(&stale); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for i := range stale {
+		if err := db.DeleteStruct(&stale[i]); err != nil {
+			fmt.Printf("relation purge error: %v %s\n", err, stale[i].ResourceID)
+		}
+	}
+	return nil
+}
+
+func save(db *storm.DB, resourceID, kind, parent, parentKind string) {
+	relation := &core.Relation{
+		ResourceID:  resourceID,
+		Kind:        kind,
+		Parent:      parent,
+		ParentKind:  parentKind,
+		LastUpdated: time.Now(),
+	}
+	if err := db.Save(relation); err != nil {
+		fmt.Printf("%+v\n", err)
+	}
+}
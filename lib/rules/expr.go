@@ -0,0 +1,617 @@
+package rules
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/stojg/aunt/lib/core"
+)
+
+// Expression is a parsed rule expression, e.g. `CPUCreditBalance < 20 and CPUUtilization > 50`.
+//
+// Names are resolved against the fields of the resource first and then against its Metrics, `Tags.Team` looks up a key
+// in a map field, or a tag by its role like `Tags.team`, and `Metrics.CPUUtilization` is the same as `CPUUtilization`.
+// Time fields are the number of seconds since that time, negative when it's in the future, and `age` is the time since
+// the LaunchTime of the resource. Durations like 30s, 15m, 12h, 7d and 2w are written as seconds, so `age > 7d` works
+// as expected. Lists are their length.
+//
+// A name that isn't set, e.g. a metric that CloudWatch had no data for, makes any comparison with it false and any
+// arithmetic with it unset, the same is true for a division by zero.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse parses an expression and returns an error describing the first syntax error
+func Parse(source string) (*Expression, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the expression as it was written
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against a resource struct or a pointer to one, an unset result is false
+func (e *Expression) Eval(resource interface{}) (bool, error) {
+	v := reflect.Indirect(reflect.ValueOf(resource))
+	if v.Kind() != reflect.Struct {
+		return false, fmt.Errorf("can't evaluate %q against a %T", e.source, resource)
+	}
+	result, err := e.root.eval(v, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", e.source, err)
+	}
+	switch r := result.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return r, nil
+	}
+	return false, fmt.Errorf("%s: the result is %s, not true or false", e.source, describe(result))
+}
+
+// values are nil when unset, a float64, a string or a bool
+type value interface{}
+
+type node interface {
+	eval(resource reflect.Value, now time.Time) (value, error)
+}
+
+type literal struct {
+	value value
+}
+
+func (n *literal) eval(reflect.Value, time.Time) (value, error) {
+	return n.value, nil
+}
+
+type name struct {
+	path []string
+}
+
+func (n *name) eval(resource reflect.Value, now time.Time) (value, error) {
+	if len(n.path) == 1 && n.path[0] == "age" {
+		return convert(resource.FieldByName("LaunchTime"), now), nil
+	}
+	v := resource.FieldByName(n.path[0])
+	if !v.IsValid() {
+		// not a field, so it's a metric that may have dots in its name
+		return metric(resource, strings.Join(n.path, "."), now), nil
+	}
+	if tags, ok := v.Interface().(map[string]string); ok && n.path[0] == "Tags" && len(n.path) == 2 {
+		// tags can be looked up by their role, e.g. Tags.team, and the keys aren't case sensitive
+		if tag := core.ResourceTag(tags, n.path[1]); tag != "" {
+			return tag, nil
+		}
+		return nil, nil
+	}
+	for _, key := range n.path[1:] {
+		v = reflect.Indirect(v)
+		switch v.Kind() {
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return nil, fmt.Errorf("%s can't be looked up by name", strings.Join(n.path, "."))
+			}
+			v = v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+		case reflect.Struct:
+			v = v.FieldByName(key)
+		default:
+			v = reflect.Value{}
+		}
+		if !v.IsValid() {
+			return nil, nil
+		}
+	}
+	return convert(v, now), nil
+}
+
+func metric(resource reflect.Value, name string, now time.Time) value {
+	metrics := resource.FieldByName("Metrics")
+	if !metrics.IsValid() || metrics.Kind() != reflect.Map {
+		return nil
+	}
+	return convert(metrics.MapIndex(reflect.ValueOf(name)), now)
+}
+
+// convert turns a field into a value, anything that can't be compared is unset
+func convert(v reflect.Value, now time.Time) value {
+	if !v.IsValid() {
+		return nil
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return nil
+		}
+		return now.Sub(t).Seconds()
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Slice, reflect.Array:
+		return float64(v.Len())
+	}
+	return nil
+}
+
+type not struct {
+	operand node
+}
+
+func (n *not) eval(resource reflect.Value, now time.Time) (value, error) {
+	v, err := n.operand.eval(resource, now)
+	if err != nil {
+		return nil, err
+	}
+	b, err := truth(v)
+	return !b, err
+}
+
+type logical struct {
+	op          string
+	left, right node
+}
+
+func (n *logical) eval(resource reflect.Value, now time.Time) (value, error) {
+	l, err := n.left.eval(resource, now)
+	if err != nil {
+		return nil, err
+	}
+	left, err := truth(l)
+	if err != nil {
+		return nil, err
+	}
+	// short circuit so that the right side can rely on the left, e.g. `WriteCapacity > 0 and ...`
+	if n.op == "and" && !left || n.op == "or" && left {
+		return left, nil
+	}
+	r, err := n.right.eval(resource, now)
+	if err != nil {
+		return nil, err
+	}
+	return truth(r)
+}
+
+func truth(v value) (bool, error) {
+	switch b := v.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return b, nil
+	}
+	return false, fmt.Errorf("expected true or false, got %s", describe(v))
+}
+
+type comparison struct {
+	op          string
+	left, right node
+}
+
+func (n *comparison) eval(resource reflect.Value, now time.Time) (value, error) {
+	l, err := n.left.eval(resource, now)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(resource, now)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || r == nil {
+		return false, nil
+	}
+	switch left := l.(type) {
+	case float64:
+		if right, ok := r.(float64); ok {
+			return compare(n.op, left, right), nil
+		}
+	case string:
+		if right, ok := r.(string); ok {
+			return compare(n.op, float64(strings.Compare(left, right)), 0), nil
+		}
+	case bool:
+		if right, ok := r.(bool); ok {
+			switch n.op {
+			case "==":
+				return left == right, nil
+			case "!=":
+				return left != right, nil
+			}
+			return nil, fmt.Errorf("%s can't compare true or false", n.op)
+		}
+	}
+	return nil, fmt.Errorf("can't compare %s %s %s", describe(l), n.op, describe(r))
+}
+
+func compare(op string, l, r float64) bool {
+	switch op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	}
+	return l >= r
+}
+
+type arithmetic struct {
+	op          string
+	left, right node
+}
+
+func (n *arithmetic) eval(resource reflect.Value, now time.Time) (value, error) {
+	l, err := n.left.eval(resource, now)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(resource, now)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || r == nil {
+		return nil, nil
+	}
+	left, lok := l.(float64)
+	right, rok := r.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("can't calculate %s %s %s", describe(l), n.op, describe(r))
+	}
+	switch n.op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	}
+	if right == 0 {
+		return nil, nil
+	}
+	return left / right, nil
+}
+
+type negate struct {
+	operand node
+}
+
+func (n *negate) eval(resource reflect.Value, now time.Time) (value, error) {
+	v, err := n.operand.eval(resource, now)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("can't negate %s", describe(v))
+	}
+	return -f, nil
+}
+
+func describe(v value) string {
+	switch t := v.(type) {
+	case nil:
+		return "unset"
+	case float64:
+		return fmt.Sprintf("the number %g", t)
+	case string:
+		return fmt.Sprintf("the string %q", t)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenName
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// number is the value of number and duration tokens
+	number float64
+	pos    int
+}
+
+// durations are the duration suffixes in seconds
+var durations = map[byte]float64{
+	's': 1,
+	'm': 60,
+	'h': 3600,
+	'd': 24 * 3600,
+	'w': 7 * 24 * 3600,
+}
+
+// keywords are written as words but are operators
+var keywords = map[string]string{
+	"and": "and",
+	"or":  "or",
+	"not": "not",
+	"&&":  "and",
+	"||":  "or",
+	"!":   "not",
+}
+
+func lex(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isDigit(c) || c == '.' && i+1 < len(source) && isDigit(source[i+1]):
+			start := i
+			for i < len(source) && (isDigit(source[i]) || source[i] == '.') {
+				i++
+			}
+			number, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", source[start:i], start)
+			}
+			if i < len(source) {
+				if unit, ok := durations[source[i]]; ok && (i+1 == len(source) || !isNameChar(source[i+1])) {
+					number *= unit
+					i++
+				}
+			}
+			if i < len(source) && isNameChar(source[i]) {
+				return nil, fmt.Errorf("invalid number %q at position %d", source[start:i+1], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:i], number: number, pos: start})
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(source[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: source[i+1 : i+1+end], pos: i})
+			i += end + 2
+		case isNameChar(c):
+			start := i
+			for i < len(source) && (isNameChar(source[i]) || isDigit(source[i]) || source[i] == '.') {
+				i++
+			}
+			text := source[start:i]
+			if op, ok := keywords[text]; ok {
+				tokens = append(tokens, token{kind: tokenOperator, text: op, pos: start})
+			} else {
+				tokens = append(tokens, token{kind: tokenName, text: text, pos: start})
+			}
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "(", ")"} {
+				if strings.HasPrefix(source[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+			if keyword, ok := keywords[op]; ok {
+				tokens = append(tokens, token{kind: tokenOperator, text: keyword, pos: i})
+			} else {
+				tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+			}
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, text: "end of expression", pos: len(source)}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c < unicode.MaxASCII && unicode.IsLetter(rune(c))
+}
+
+// parser is a recursive descent parser, from the lowest precedence: or, and, not, comparisons, + and -, * and /
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.next++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("or"); !ok {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "or", left: left, right: right}
+	}
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("and"); !ok {
+			return left, nil
+		}
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "and", left: left, right: right}
+	}
+}
+
+func (p *parser) not() (node, error) {
+	if _, ok := p.accept("not"); ok {
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &not{operand: operand}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("==", "!=", "<", "<=", ">", ">="); ok {
+		return nil, fmt.Errorf("comparisons can't be chained at position %d, combine them with and", p.tokens[p.next-1].pos)
+	}
+	return &comparison{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) sum() (node, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmetic{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) product() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &arithmetic{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if _, ok := p.accept("-"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &negate{operand: operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber:
+		p.next++
+		return &literal{value: t.number}, nil
+	case tokenString:
+		p.next++
+		return &literal{value: t.text}, nil
+	case tokenName:
+		p.next++
+		switch t.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		}
+		if strings.HasPrefix(t.text, ".") || strings.HasSuffix(t.text, ".") || strings.Contains(t.text, "..") {
+			return nil, fmt.Errorf("invalid name %q at position %d", t.text, t.pos)
+		}
+		return &name{path: strings.Split(t.text, ".")}, nil
+	case tokenOperator:
+		if t.text == "(" {
+			p.next++
+			inner, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				t := p.peek()
+				return nil, fmt.Errorf("expected ) but got %q at position %d", t.text, t.pos)
+			}
+			return inner, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
@@ -0,0 +1,241 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stojg/aunt/lib/dynamodb"
+	"github.com/stojg/aunt/lib/ebs"
+	"github.com/stojg/aunt/lib/ec2"
+)
+
+// resource is a minimal resource for testing the evaluation
+type resource struct {
+	Name       string
+	Attached   bool
+	Size       int64
+	LaunchTime *time.Time
+	Tags       map[string]string
+	Events     []string
+	Metrics    map[string]*float64
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"1 < 2 < 3", "comparisons can't be chained"},
+		{"CPUUtilization > 50 == true", "comparisons can't be chained"},
+		{`Name == "web`, "unterminated string"},
+		{"Name == 'web", "unterminated string"},
+		{"age > 7dx", "invalid number"},
+		{"age > 7x", "invalid number"},
+		{"1.2.3 > 1", "invalid number"},
+		{"(1 < 2", "expected )"},
+		{"1 <", "unexpected"},
+		{"Size > 1 and", "unexpected"},
+		{"Size $ 1", "unexpected"},
+		{"Tags..Team == 'ops'", "invalid name"},
+		{"1 2", "unexpected"},
+		{"", "unexpected"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.source)
+		if err == nil {
+			t.Errorf("Parse(%q) should fail", test.source)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Parse(%q) = %q, expected an error containing %q", test.source, err, test.err)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	now := time.Now()
+	r := &resource{
+		Name:       "web",
+		Size:       100,
+		LaunchTime: aws.Time(now.Add(-10 * 24 * time.Hour)),
+		Tags:       map[string]string{"Team": "ops", "env": "prod"},
+		Events:     []string{"reboot", "retire"},
+		Metrics: map[string]*float64{
+			"CPUUtilization": aws.Float64(60),
+			"Zero":           aws.Float64(0),
+			"Unset":          nil,
+		},
+	}
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		// precedence
+		{"1 + 2 * 3 == 7", true},
+		{"(1 + 2) * 3 == 9", true},
+		{"10 - 2 - 3 == 5", true},
+		{"12 / 2 / 3 == 2", true},
+		{"-2 * 3 == -6", true},
+		{"true or false and false", true},
+		{"(true or false) and false", false},
+		{"not false and false", false},
+		{"not (false and false)", true},
+		{"not 1 > 2", true},
+		{"1 < 2 and 2 < 3 or 3 < 2", true},
+		// short circuit, the right side would be an error
+		{"false and Name", false},
+		{"true or Name", true},
+		{"Zero > 0 and 10 / Zero > 1", false},
+		// fields, metrics and tags
+		{`Name == "web"`, true},
+		{"Name != 'web'", false},
+		{`Name < "zzz"`, true},
+		{"Attached == false", true},
+		{"not Attached", true},
+		{"Size >= 100", true},
+		{"CPUUtilization > 50", true},
+		{"Metrics.CPUUtilization > 50", true},
+		{`Tags.Team == "ops"`, true},
+		{`Tags.team == "ops"`, true},
+		{`Tags.environment == "prod"`, true},
+		{"Events == 2", true},
+		// unset metrics and division by zero are false
+		{"Unset > 0", false},
+		{"Unset < 0", false},
+		{"Unset == Unset", false},
+		{"Missing > 0", false},
+		{"Missing + 1 > 0", false},
+		{"-Missing < 0", false},
+		{"CPUUtilization / Zero > 0", false},
+		{"CPUUtilization / Zero < 0", false},
+		{"CPUUtilization / Zero", false},
+		{`Tags.Owner == "ops"`, false},
+		{"Metrics.Missing > 0", false},
+		// durations and age
+		{"age > 7d", true},
+		{"age > 2w", false},
+		{"age < 11d", true},
+		{"age > 239h and age < 241h", true},
+		{"age > 14399m", true},
+		{"LaunchTime > 1w", true},
+		{"1d == 24h", true},
+		{"1w == 7d", true},
+		{"90s == 1.5m", true},
+	}
+	for _, test := range tests {
+		e, err := Parse(test.source)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.source, err)
+			continue
+		}
+		got, err := e.Eval(r)
+		if err != nil {
+			t.Errorf("Eval(%q) failed: %v", test.source, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Eval(%q) = %v, expected %v", test.source, got, test.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []string{
+		"Name > 1",
+		`Name + "x" == "webx"`,
+		"Attached < true",
+		"Size",
+		"Name and true",
+		"-Name == 1",
+	}
+	for _, source := range tests {
+		e, err := Parse(source)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", source, err)
+			continue
+		}
+		if _, err := e.Eval(&resource{Name: "web", Size: 1}); err == nil {
+			t.Errorf("Eval(%q) should fail", source)
+		}
+	}
+	e, err := Parse("Size > 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Eval("not a struct"); err == nil {
+		t.Error("Eval against a string should fail")
+	}
+}
+
+// TestReadmeExamples evaluates the rule examples from the README against the resources they are written for
+func TestReadmeExamples(t *testing.T) {
+	now := time.Now()
+	busy := &ec2.Instance{
+		Name:    "web",
+		Metrics: map[string]*float64{"CPUCreditBalance": aws.Float64(5), "CPUUtilization": aws.Float64(80)},
+	}
+	idle := &ec2.Instance{
+		Name:    "batch",
+		Metrics: map[string]*float64{"CPUCreditBalance": aws.Float64(5), "CPUUtilization": aws.Float64(10)},
+	}
+	noData := &ec2.Instance{
+		Name:    "new",
+		Metrics: map[string]*float64{"CPUCreditBalance": nil, "CPUUtilization": aws.Float64(80)},
+	}
+	throttled := &dynamodb.Table{
+		Name:          "orders",
+		WriteCapacity: 100,
+		Metrics:       map[string]*float64{"WriteThrottleEvents": aws.Float64(20)},
+	}
+	onDemand := &dynamodb.Table{
+		Name:    "sessions",
+		Metrics: map[string]*float64{"WriteThrottleEvents": aws.Float64(20)},
+	}
+	forgotten := &ebs.Volume{
+		Name:          "old",
+		LaunchTime:    aws.Time(now.AddDate(-2, 0, 0)),
+		DetachedSince: aws.Time(now.AddDate(0, 0, -8)),
+	}
+	justDetached := &ebs.Volume{
+		Name:          "old-but-recent",
+		LaunchTime:    aws.Time(now.AddDate(-2, 0, 0)),
+		DetachedSince: aws.Time(now.Add(-time.Hour)),
+	}
+	attached := &ebs.Volume{
+		Name:       "root",
+		LaunchTime: aws.Time(now.AddDate(-2, 0, 0)),
+		Attached:   true,
+	}
+
+	tests := []struct {
+		source   string
+		resource interface{}
+		want     bool
+	}{
+		{"CPUCreditBalance < 20 and CPUUtilization > 50", busy, true},
+		{"CPUCreditBalance < 20 and CPUUtilization > 50", idle, false},
+		{"CPUCreditBalance < 20 and CPUUtilization > 50", noData, false},
+		{"WriteThrottleEvents / WriteCapacity > 0.1", throttled, true},
+		{"WriteThrottleEvents / WriteCapacity > 0.1", onDemand, false},
+		{"Attached == false and DetachedSince > 7d", forgotten, true},
+		{"Attached == false and DetachedSince > 7d", justDetached, false},
+		{"Attached == false and DetachedSince > 7d", attached, false},
+	}
+	for _, test := range tests {
+		e, err := Parse(test.source)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.source, err)
+			continue
+		}
+		got, err := e.Eval(test.resource)
+		if err != nil {
+			t.Errorf("Eval(%q) against %T failed: %v", test.source, test.resource, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Eval(%q) against %+v = %v, expected %v", test.source, test.resource, got, test.want)
+		}
+	}
+}
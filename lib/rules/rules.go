@@ -0,0 +1,190 @@
+package rules
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/stojg/aunt/lib/asg"
+	"github.com/stojg/aunt/lib/certificate"
+	"github.com/stojg/aunt/lib/core"
+	"github.com/stojg/aunt/lib/dynamodb"
+	"github.com/stojg/aunt/lib/ebs"
+	"github.com/stojg/aunt/lib/ec2"
+	"github.com/stojg/aunt/lib/ecs"
+	"github.com/stojg/aunt/lib/elasticache"
+	"github.com/stojg/aunt/lib/elb"
+	"github.com/stojg/aunt/lib/iam"
+	"github.com/stojg/aunt/lib/lambda"
+	"github.com/stojg/aunt/lib/rds"
+	"github.com/stojg/aunt/lib/sqs"
+)
+
+// Rule raises an alert for every resource of a kind where the expression is true, e.g. for the kind Volume and the
+// expression `Attached == false and age > 7d`
+type Rule struct {
+	// Name of the rule, the alerts are named Rule.<Name>
+	Name string
+	// Kind is the type of resource that the rule is evaluated against, e.g. Instance, Volume or Table
+	Kind       string
+	Expression string
+	// Message of the alert, the expression is used when not set
+	Message string
+	// Priority of the alert, P3 when not set
+	Priority string
+	// Tags are sent with the alert, e.g. ["database", "capacity"]
+	Tags []string
+	// Team is the OpsGenie team that the alert is routed to, it overrides the team and owner resource tags
+	Team string
+	// Responders are other OpsGenie teams that are notified about the alert
+	Responders []string
+	// Actions are the custom OpsGenie actions that can be run on the alert, e.g. Restart
+	Actions []string
+	// ResourceTags limits the rule to resources with these tags, a key can also be a tag role like team or environment
+	ResourceTags map[string]string
+}
+
+type compiledRule struct {
+	Rule
+	expression *Expression
+}
+
+// kinds returns a pointer to an empty slice of the stored resources of each kind
+var kinds = map[string]func() interface{}{
+	"AutoScalingGroup":     func() interface{} { return &[]asg.AutoScalingGroup{} },
+	"Certificate":          func() interface{} { return &[]certificate.Certificate{} },
+	"Table":                func() interface{} { return &[]dynamodb.Table{} },
+	"GlobalSecondaryIndex": func() interface{} { return &[]dynamodb.GlobalSecondaryIndex{} },
+	"Volume":               func() interface{} { return &[]ebs.Volume{} },
+	"Instance":             func() interface{} { return &[]ec2.Instance{} },
+	"ECSCluster":           func() interface{} { return &[]ecs.Cluster{} },
+	"ECSService":           func() interface{} { return &[]ecs.Service{} },
+	"ContainerInstance":    func() interface{} { return &[]ecs.ContainerInstance{} },
+	"CacheCluster":         func() interface{} { return &[]elasticache.CacheCluster{} },
+	"ReplicationGroup":     func() interface{} { return &[]elasticache.ReplicationGroup{} },
+	"LoadBalancer":         func() interface{} { return &[]elb.LoadBalancer{} },
+	"TargetGroup":          func() interface{} { return &[]elb.TargetGroup{} },
+	"IAMUser":              func() interface{} { return &[]iam.User{} },
+	"Function":             func() interface{} { return &[]lambda.Function{} },
+	"DBInstance":           func() interface{} { return &[]rds.DBInstance{} },
+	"DBCluster":            func() interface{} { return &[]rds.DBCluster{} },
+	"Queue":                func() interface{} { return &[]sqs.Queue{} },
+}
+
+var rules []compiledRule
+
+// Configure parses the rules and returns an error for the first rule with an unknown kind or a syntax error, none of
+// the rules are used if any of them are invalid
+func Configure(cfg []Rule) error {
+	var compiled []compiledRule
+	names := make(map[string]bool)
+	for _, rule := range cfg {
+		if rule.Name == "" {
+			return fmt.Errorf("rule for %q has no name", rule.Expression)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("there is more than one rule named %s", rule.Name)
+		}
+		names[rule.Name] = true
+		if _, ok := kinds[rule.Kind]; !ok {
+			return fmt.Errorf("rule %s has an unknown kind %q, it should be one of %s", rule.Name, rule.Kind, strings.Join(Kinds(), ", "))
+		}
+		switch rule.Priority {
+		case "", core.P1, core.P2, core.P3, core.P4, core.P5:
+		default:
+			return fmt.Errorf("rule %s has an unknown priority %q", rule.Name, rule.Priority)
+		}
+		expression, err := Parse(rule.Expression)
+		if err != nil {
+			return fmt.Errorf("rule %s has an invalid expression: %v", rule.Name, err)
+		}
+		compiled = append(compiled, compiledRule{Rule: rule, expression: expression})
+	}
+	rules = compiled
+	return nil
+}
+
+// Kinds returns the kinds of resources that rules can be evaluated against
+func Kinds() []string {
+	var names []string
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Update evaluates the rules against the stored resources, it should run after the resources has been updated
+func Update(db *storm.DB) error {
+	for _, rule := range rules {
+		resources := kinds[rule.Kind]()
+		query := db.Select(q.Gte("LastUpdated", time.Now().Add(-core.MaxResourceAge)))
+		if err := query.Find(resources); err != nil && err != storm.ErrNotFound {
+			return err
+		}
+		list := reflect.ValueOf(resources).Elem()
+		for i := 0; i < list.Len(); i++ {
+			resource := list.Index(i)
+			if !core.TagFilter(rule.ResourceTags).Matches(tags(resource)) {
+				continue
+			}
+			match, err := rule.expression.Eval(resource.Interface())
+			if err != nil {
+				fmt.Printf("rule %s: %v %s\n", rule.Name, err, field(resource, "ResourceID"))
+				continue
+			}
+			if match {
+				raise(db, rule, resource)
+			}
+		}
+	}
+	return nil
+}
+
+func raise(db *storm.DB, rule compiledRule, resource reflect.Value) {
+	alert := core.NewAlert("Rule."+rule.Name, field(resource, "ResourceID"))
+	message := rule.Message
+	if message == "" {
+		message = rule.Expression
+	}
+	alert.Message = fmt.Sprintf("%s for %s %s", message, rule.Kind, field(resource, "Name"))
+	alert.Priority = core.P3
+	if rule.Priority != "" {
+		alert.Priority = rule.Priority
+	}
+	alert.Details["account"] = field(resource, "Account")
+	alert.Details["region"] = field(resource, "Region")
+	alert.Details["resource_id"] = field(resource, "ResourceID")
+	alert.Details["rule"] = rule.Name
+	alert.Details["expression"] = rule.Expression
+	alert.Tags = rule.Tags
+	alert.Team = rule.Team
+	alert.Responders = rule.Responders
+	alert.Actions = rule.Actions
+	alert.ResourceTags = tags(resource)
+	if err := alert.Save(db); err != nil {
+		fmt.Printf("%+v\n", err)
+	}
+}
+
+// tags returns the tags of the resource, it's nil for the kinds of resources that doesn't have tags
+func tags(resource reflect.Value) map[string]string {
+	v := resource.FieldByName("Tags")
+	if !v.IsValid() {
+		return nil
+	}
+	tags, _ := v.Interface().(map[string]string)
+	return tags
+}
+
+func field(resource reflect.Value, name string) string {
+	v := resource.FieldByName(name)
+	if !v.IsValid() || v.Kind() != reflect.String {
+		return ""
+	}
+	return v.String()
+}
@@ -0,0 +1,49 @@
+package schema
+
+import (
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+	"github.com/stojg/aunt/lib/core"
+)
+
+// migrations must be kept in version order, never change or remove a migration that has been released, add a new one
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: func(tx storm.Node) error {
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "index alerts by LastUpdated",
+		Up: func(tx storm.Node) error {
+			return reIndex(tx, &core.Alert{})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "key global secondary indexes by ARN",
+		Up: func(tx storm.Node) error {
+			// the indexes are stored again with the new keys on the next update
+			if err := tx.Drop("GlobalSecondaryIndex"); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			return nil
+		},
+	},
+}
+
+// reIndex rebuilds the indexes for the type of data, it's not an error if there are no records of that type yet
+func reIndex(tx storm.Node, data interface{}) error {
+	// storm panics when re-indexing a bucket that doesn't exist
+	total, err := tx.Count(data)
+	if err == storm.ErrNotFound || total == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tx.ReIndex(data)
+}
@@ -0,0 +1,112 @@
+package schema
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/asdine/storm"
+)
+
+const (
+	bucketName = "schema"
+	versionKey = "version"
+)
+
+// Migration changes the stored data from one schema version to the next
+type Migration struct {
+	// Version is the schema version the database is at after this migration has run
+	Version int
+	// Name is a short human readable description of the migration
+	Name string
+	// Up applies the migration, all changes must be made on the node since it's wrapped in a transaction
+	Up func(tx storm.Node) error
+}
+
+// Applied is a record of a migration that has been run against the database
+type Applied struct {
+	Version int `storm:"id"`
+	Name    string
+	Applied time.Time
+}
+
+// Latest returns the schema version that this version of aunt expects
+func Latest() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// Current returns the schema version stored in the database, a database that has never been migrated is at version 0
+func Current(db *storm.DB) (int, error) {
+	var version int
+	err := db.Get(bucketName, versionKey, &version)
+	if err == storm.ErrNotFound {
+		return 0, nil
+	}
+	return version, err
+}
+
+// Pending returns the migrations that hasn't been applied to the database yet
+func Pending(db *storm.DB) ([]Migration, error) {
+	current, err := Current(db)
+	if err != nil {
+		return nil, err
+	}
+	if current > Latest() {
+		return nil, fmt.Errorf("database schema version %d is newer than the latest known version %d", current, Latest())
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// History returns the record of all migrations that has been applied to the database
+func History(db *storm.DB) ([]Applied, error) {
+	var applied []Applied
+	if err := db.All(&applied); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// Migrate runs all pending migrations in order, each one in its own transaction. It stops at the first migration that
+// fails so that the database is left at the last successful version.
+func Migrate(db *storm.DB) ([]Migration, error) {
+	pending, err := Pending(db)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range pending {
+		if err := run(db, m); err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+func run(db *storm.DB, m Migration) error {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	if err := m.Up(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Save(&Applied{Version: m.Version, Name: m.Name, Applied: time.Now()}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Set(bucketName, versionKey, m.Version); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,163 @@
+package schema
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
+	"github.com/stojg/aunt/lib/core"
+)
+
+// openFixture opens a copy of a database from the testdata directory so that the migrations doesn't change the fixture
+func openFixture(t *testing.T, name string) *storm.DB {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "aunt-schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	src, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	path := filepath.Join(dir, name)
+	dst, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := storm.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestMigrateV1 migrates a database written at schema version 1, before the alerts were indexed by LastUpdated
+func TestMigrateV1(t *testing.T) {
+	db := openFixture(t, "v1.db")
+
+	current, err := Current(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != 1 {
+		t.Fatalf("fixture should be at version 1, got %d", current)
+	}
+
+	done, err := Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != Latest()-1 || done[0].Version != 2 {
+		t.Errorf("expected the migrations from version 2 to %d to run, got %+v", Latest(), done)
+	}
+	current, err = Current(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != Latest() {
+		t.Errorf("expected version %d after migrating, got %d", Latest(), current)
+	}
+	history, err := History(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != Latest() {
+		t.Errorf("expected %d applied migrations, got %d", Latest(), len(history))
+	}
+
+	err = db.Bolt.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("Alert"))
+		if bucket == nil || bucket.Bucket([]byte("__storm_index_LastUpdated")) == nil {
+			t.Fatal("the LastUpdated index wasn't rebuilt")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var all []core.Alert
+	if err := db.AllByIndex("LastUpdated", &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].ID != "aunt.CPUCreditBalance.i-1" {
+		t.Errorf("expected all 3 alerts in the LastUpdated index oldest first, got %+v", all)
+	}
+
+	// the range only finds alerts that are in the index
+	var alerts []core.Alert
+	from := time.Date(2017, 8, 20, 10, 30, 0, 0, time.UTC)
+	if err := db.Range("LastUpdated", from, from.Add(24*time.Hour), &alerts); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 2 || alerts[0].ID != "aunt.BurstBalance.vol-1" || alerts[1].ID != "aunt.NumScalingEvents.web" {
+		t.Errorf("expected the two latest alerts in order, got %+v", alerts)
+	}
+
+	done, err = Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 0 {
+		t.Errorf("expected no migrations on a second run, got %+v", done)
+	}
+}
+
+func TestMigrateEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aunt-schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := storm.Open(filepath.Join(dir, "empty.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	done, err := Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != Latest() {
+		t.Errorf("expected all %d migrations to run, got %d", Latest(), len(done))
+	}
+	if current, _ := Current(db); current != Latest() {
+		t.Errorf("expected version %d, got %d", Latest(), current)
+	}
+}
+
+func TestPendingNewerDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aunt-schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := storm.Open(filepath.Join(dir, "newer.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Set(bucketName, versionKey, Latest()+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(db); err == nil {
+		t.Error("expected an error when the database is newer than the latest migration")
+	}
+}
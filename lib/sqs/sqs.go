@@ -0,0 +1,239 @@
+package sqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stojg/aunt/lib/core"
+)
+
+// Queue is an app specific representation of a SQS queue
+type Queue struct {
+	Name string
+	// ResourceID is the queue ARN
+	ResourceID        string `storm:"id"`
+	URL               string
+	VisibilityTimeout int64
+	// DeadLetterTargetARN is the queue that messages are moved to after MaxReceiveCount failed receives
+	DeadLetterTargetARN string
+	MaxReceiveCount     int64
+	// IsDeadLetterQueue is true when the redrive policy of another queue targets this queue
+	IsDeadLetterQueue bool
+	// SourceQueues are the queues that moves failed messages into this queue
+	SourceQueues []string
+	Messages     int64
+	LaunchTime   *time.Time
+	Region       string
+	Account      string
+	Tags         map[string]string
+	LastUpdated  time.Time
+	Metrics      map[string]*float64
+}
+
+type redrivePolicy struct {
+	DeadLetterTargetARN string      `json:"deadLetterTargetArn"`
+	MaxReceiveCount     interface{} `json:"maxReceiveCount"`
+}
+
+const (
+	metricMessagesVisible = "ApproximateNumberOfMessagesVisible"
+	metricOldestMessage   = "ApproximateAgeOfOldestMessage"
+)
+
+const (
+	// seconds that the oldest message has been waiting in the queue
+	metricOldestMessageThreshold float64 = 3600
+)
+
+var metrics = []string{metricMessagesVisible, metricOldestMessage}
+
+// Update will update the database with Queue data
+func Update(db *storm.DB, roles map[string]string, regions []string) error {
+	// update all accounts in parallel to speed this up
+	core.UpdateAccounts(roles, func(account, role string) {
+		updateForRole(db, account, role, regions)
+	})
+	return nil
+}
+
+func updateForRole(db *storm.DB, account, role string, regions []string) {
+	for _, region := range regions {
+		sess, config := core.NewCredentials(region, role)
+		svc := sqs.New(sess, config)
+		cw := cloudwatch.New(sess, config)
+
+		urls, err := queueURLs(svc)
+		if err != nil {
+			fmt.Printf("sqs.ListQueues %s %s %v\n", role, region, err)
+			continue
+		}
+
+		var queues []*Queue
+		for _, url := range urls {
+			attrs, err := svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+				QueueUrl:       url,
+				AttributeNames: []*string{aws.String(sqs.QueueAttributeNameAll)},
+			})
+			if err != nil {
+				fmt.Printf("sqs.GetQueueAttributes %s %s %v\n", role, region, err)
+				continue
+			}
+			queue := newQueue(*url, attrs.Attributes, account, region)
+			queue.Tags = tags(svc, *url)
+			queues = append(queues, queue)
+		}
+
+		// a queue only knows which queue it sends failed messages to, not if it's a dead letter queue itself
+		sources := make(map[string][]string)
+		for _, queue := range queues {
+			if queue.DeadLetterTargetARN != "" {
+				sources[queue.DeadLetterTargetARN] = append(sources[queue.DeadLetterTargetARN], queue.Name)
+			}
+		}
+
+		for _, queue := range queues {
+			queue.SourceQueues = sources[queue.ResourceID]
+			queue.IsDeadLetterQueue = len(queue.SourceQueues) > 0
+
+			dimensions := []*cloudwatch.Dimension{{Name: aws.String("QueueName"), Value: aws.String(queue.Name)}}
+			for _, name := range metrics {
+				queue.Metrics[name] = core.Metric(cw, "AWS/SQS", dimensions, name, cloudwatch.StatisticMaximum)
+			}
+			if err := db.Save(queue); err != nil {
+				fmt.Printf("%+v\n", err)
+			}
+
+			// check metrics
+			if queue.IsDeadLetterQueue {
+				if queue.Messages > 0 {
+					alert := newAlert("DeadLetterQueue", queue)
+					alert.Message = fmt.Sprintf("Dead letter queue %s has %d messages", queue.Name, queue.Messages)
+					alert.Details["source_queues"] = strings.Join(queue.SourceQueues, ", ")
+					core.SaveAlert(db, alert)
+				}
+				// messages in a dead letter queue are expected to be old, so don't alert on the backlog age
+				continue
+			}
+			age := queue.Metrics[metricOldestMessage]
+			if age != nil && *age > metricOldestMessageThreshold {
+				alert := newAlert(metricOldestMessage, queue)
+				alert.Message = fmt.Sprintf("Oldest message (%s) is older than %s in queue %s", time.Duration(*age)*time.Second, time.Duration(metricOldestMessageThreshold)*time.Second, queue.Name)
+				if visible := queue.Metrics[metricMessagesVisible]; visible != nil {
+					alert.Details["messages_visible"] = fmt.Sprintf("%.0f", *visible)
+				}
+				core.SaveAlert(db, alert)
+			}
+		}
+	}
+}
+
+func newQueue(url string, attrs map[string]*string, account, region string) *Queue {
+	queue := &Queue{
+		Name:              url[strings.LastIndex(url, "/")+1:],
+		ResourceID:        aws.StringValue(attrs[sqs.QueueAttributeNameQueueArn]),
+		URL:               url,
+		VisibilityTimeout: atoi(attrs[sqs.QueueAttributeNameVisibilityTimeout]),
+		Messages:          atoi(attrs[sqs.QueueAttributeNameApproximateNumberOfMessages]),
+		Region:            region,
+		Account:           account,
+		LastUpdated:       time.Now(),
+		Metrics:           make(map[string]*float64),
+	}
+	if created := atoi(attrs[sqs.QueueAttributeNameCreatedTimestamp]); created > 0 {
+		queue.LaunchTime = aws.Time(time.Unix(created, 0))
+	}
+	if policy, ok := attrs[sqs.QueueAttributeNameRedrivePolicy]; ok && policy != nil {
+		var redrive redrivePolicy
+		if err := json.Unmarshal([]byte(*policy), &redrive); err != nil {
+			fmt.Printf("sqs.RedrivePolicy %s %v\n", queue.Name, err)
+		} else {
+			queue.DeadLetterTargetARN = redrive.DeadLetterTargetARN
+			// the max receive count is sometimes a number and sometimes a string
+			queue.MaxReceiveCount = atoi(aws.String(fmt.Sprintf("%v", redrive.MaxReceiveCount)))
+		}
+	}
+	return queue
+}
+
+func newAlert(name string, queue *Queue) *core.Alert {
+	alert := core.ResourceAlert(name, queue.ResourceID, queue.Account, queue.Region, queue.Tags)
+	alert.Details["url"] = queue.URL
+	return alert
+}
+
+// maxListQueues is the most queues that ListQueues returns in one page
+const maxListQueues = 1000
+
+// listQueuesInput and listQueuesOutput are the paginated ListQueues operation, the vendored SDK only returns the first
+// 1000 queues
+type listQueuesInput struct {
+	_          struct{} `type:"structure"`
+	MaxResults *int64   `type:"integer"`
+	NextToken  *string  `type:"string"`
+}
+
+type listQueuesOutput struct {
+	_         struct{}  `type:"structure"`
+	QueueUrls []*string `locationNameList:"QueueUrl" type:"list" flattened:"true"`
+	NextToken *string   `type:"string"`
+}
+
+// queueURLs returns the URLs of all queues in the region
+func queueURLs(svc *sqs.SQS) ([]*string, error) {
+	var urls []*string
+	input := &listQueuesInput{MaxResults: aws.Int64(maxListQueues)}
+	for {
+		output := &listQueuesOutput{}
+		op := &request.Operation{Name: "ListQueues", HTTPMethod: "POST", HTTPPath: "/"}
+		if err := svc.NewRequest(op, input, output).Send(); err != nil {
+			return nil, err
+		}
+		urls = append(urls, output.QueueUrls...)
+		if aws.StringValue(output.NextToken) == "" {
+			return urls, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listQueueTagsInput and listQueueTagsOutput are the ListQueueTags operation, which is newer than the vendored SDK
+type listQueueTagsInput struct {
+	_        struct{} `type:"structure"`
+	QueueUrl *string  `type:"string" required:"true"`
+}
+
+type listQueueTagsOutput struct {
+	_    struct{}           `type:"structure"`
+	Tags map[string]*string `locationName:"Tag" locationNameKey:"Key" locationNameValue:"Value" type:"map" flattened:"true"`
+}
+
+// tags returns the tags of a queue
+func tags(svc *sqs.SQS, url string) map[string]string {
+	result := make(map[string]string)
+	output := &listQueueTagsOutput{}
+	op := &request.Operation{Name: "ListQueueTags", HTTPMethod: "POST", HTTPPath: "/"}
+	if err := svc.NewRequest(op, &listQueueTagsInput{QueueUrl: aws.String(url)}, output).Send(); err != nil {
+		fmt.Printf("sqs.ListQueueTags %s %v\n", url, err)
+		return result
+	}
+	for key, value := range output.Tags {
+		result[key] = aws.StringValue(value)
+	}
+	return result
+}
+
+func atoi(s *string) int64 {
+	i, err := strconv.ParseInt(aws.StringValue(s), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return i
+}
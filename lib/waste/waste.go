@@ -0,0 +1,353 @@
+package waste
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stojg/aunt/lib/core"
+	"github.com/stojg/aunt/lib/ebs"
+	auntec2 "github.com/stojg/aunt/lib/ec2"
+)
+
+// Finding is a resource that costs money without being used
+type Finding struct {
+	// ResourceID is the kind and the id of the wasted resource, e.g. UnattachedVolume/vol-123456
+	ResourceID string `storm:"id"`
+	Kind       string `storm:"index"`
+	Name       string
+	Resource   string
+	Reason     string
+	// Since is when the resource started being wasted, e.g. when a volume was created or an instance was stopped
+	Since       *time.Time
+	Region      string
+	Account     string
+	Tags        map[string]string
+	LastUpdated time.Time `storm:"index"`
+}
+
+// Config holds the ages and thresholds for when a resource is considered wasted
+type Config struct {
+	UnattachedVolumeDays  int
+	StoppedInstanceDays   int
+	SnapshotRetentionDays int
+	ImageRetentionDays    int
+	// LowCPUPercent is the daily max CPU utilisation that an instance stays below for LowCPUDays to be idle
+	LowCPUPercent float64
+	LowCPUDays    int
+	// Alerts raises a low priority alert for every finding
+	Alerts bool
+}
+
+// Kinds of waste
+const (
+	KindUnattachedVolume = "UnattachedVolume"
+	KindUnassociatedIP   = "UnassociatedElasticIP"
+	KindStoppedInstance  = "StoppedInstance"
+	KindOldSnapshot      = "OldSnapshot"
+	KindOldImage         = "OldImage"
+	KindIdleInstance     = "IdleInstance"
+)
+
+const (
+	imageCreationDateLayout = "2006-01-02T15:04:05.000Z"
+	stateTransitionLayout   = "2006-01-02 15:04:05 MST"
+)
+
+const metricsCPU = "CPUUtilization"
+
+var settings = Config{
+	UnattachedVolumeDays:  7,
+	StoppedInstanceDays:   14,
+	SnapshotRetentionDays: 90,
+	ImageRetentionDays:    180,
+	LowCPUPercent:         5,
+	LowCPUDays:            14,
+}
+
+// stoppedAt finds the time in a state transition reason like "User initiated (2017-08-21 10:52:23 GMT)"
+var stoppedAt = regexp.MustCompile(`\((\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2} [A-Z]+)\)`)
+
+// Configure overrides the default thresholds, zero values keep the defaults
+func Configure(cfg Config) {
+	if cfg.UnattachedVolumeDays > 0 {
+		settings.UnattachedVolumeDays = cfg.UnattachedVolumeDays
+	}
+	if cfg.StoppedInstanceDays > 0 {
+		settings.StoppedInstanceDays = cfg.StoppedInstanceDays
+	}
+	if cfg.SnapshotRetentionDays > 0 {
+		settings.SnapshotRetentionDays = cfg.SnapshotRetentionDays
+	}
+	if cfg.ImageRetentionDays > 0 {
+		settings.ImageRetentionDays = cfg.ImageRetentionDays
+	}
+	if cfg.LowCPUPercent > 0 {
+		settings.LowCPUPercent = cfg.LowCPUPercent
+	}
+	if cfg.LowCPUDays > 0 {
+		settings.LowCPUDays = cfg.LowCPUDays
+	}
+	settings.Alerts = cfg.Alerts
+}
+
+// Update will update the database with Finding data, it uses the stored ec2 instances and ebs volumes so it should run
+// after those has been updated
+func Update(db *storm.DB, roles map[string]string, regions []string) error {
+	started := time.Now()
+	// update all accounts in parallel to speed this up
+	core.UpdateAccounts(roles, func(account, role string) {
+		updateForRole(db, account, role, regions)
+	})
+
+	// anything that wasn't found during this update has been removed or is in use again
+	var stale []Finding
+	if err := db.Range("LastUpdated", time.Time{}, started, &stale); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for i := range stale {
+		if err := db.DeleteStruct(&stale[i]); err != nil {
+			fmt.Printf("waste purge error: %v %s\n", err, stale[i].ResourceID)
+		}
+	}
+	return nil
+}
+
+// Report returns the current findings for resources that matches the tag filter, sorted by account, region, kind and
+// name
+func Report(db *storm.DB, filter core.TagFilter) ([]Finding, error) {
+	var all []Finding
+	if err := db.All(&all); err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	var findings []Finding
+	for _, f := range all {
+		if filter.Matches(f.Tags) {
+			findings = append(findings, f)
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return findings, nil
+}
+
+func updateForRole(db *storm.DB, account, role string, regions []string) {
+	for _, region := range regions {
+		sess, config := core.NewCredentials(region, role)
+		svc := ec2.New(sess, config)
+		cw := cloudwatch.New(sess, config)
+
+		add := func(kind, resource, name, reason string, since *time.Time, tags map[string]string) {
+			save(db, &Finding{
+				ResourceID:  fmt.Sprintf("%s/%s", kind, resource),
+				Kind:        kind,
+				Name:        name,
+				Resource:    resource,
+				Reason:      reason,
+				Since:       since,
+				Region:      region,
+				Account:     account,
+				Tags:        tags,
+				LastUpdated: time.Now(),
+			})
+		}
+
+		if err := volumes(db, account, region, add); err != nil {
+			fmt.Printf("waste.volumes %s %s %v\n", role, region, err)
+		}
+		if err := addresses(svc, add); err != nil {
+			fmt.Printf("waste.addresses %s %s %v\n", role, region, err)
+		}
+		if err := stoppedInstances(svc, add); err != nil {
+			fmt.Printf("waste.stoppedInstances %s %s %v\n", role, region, err)
+		}
+		if err := imagesAndSnapshots(svc, add); err != nil {
+			fmt.Printf("waste.imagesAndSnapshots %s %s %v\n", role, region, err)
+		}
+		if err := idleInstances(db, cw, account, region, add); err != nil {
+			fmt.Printf("waste.idleInstances %s %s %v\n", role, region, err)
+		}
+	}
+}
+
+type addFunc func(kind, resource, name, reason string, since *time.Time, tags map[string]string)
+
+func volumes(db *storm.DB, account, region string, add addFunc) error {
+	var vols []ebs.Volume
+	query := db.Select(q.Eq("Account", account), q.Eq("Region", region), q.Eq("Attached", false), q.Gte("LastUpdated", time.Now().Add(-core.MaxResourceAge)))
+	if err := query.Find(&vols); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	limit := time.Now().AddDate(0, 0, -settings.UnattachedVolumeDays)
+	for _, v := range vols {
+		if v.DetachedSince != nil && v.DetachedSince.Before(limit) {
+			add(KindUnattachedVolume, v.ResourceID, v.Name, fmt.Sprintf("%d GiB volume has been unattached for more than %d days", v.Size, settings.UnattachedVolumeDays), v.DetachedSince, v.Tags)
+		}
+	}
+	return nil
+}
+
+func addresses(svc *ec2.EC2, add addFunc) error {
+	resp, err := svc.DescribeAddresses(&ec2.DescribeAddressesInput{})
+	if err != nil {
+		return err
+	}
+	for _, a := range resp.Addresses {
+		if a.AssociationId != nil || a.InstanceId != nil {
+			continue
+		}
+		id := aws.StringValue(a.AllocationId)
+		if id == "" {
+			id = aws.StringValue(a.PublicIp)
+		}
+		add(KindUnassociatedIP, id, aws.StringValue(a.PublicIp), "Elastic IP isn't associated with an instance or network interface", nil, nil)
+	}
+	return nil
+}
+
+func stoppedInstances(svc *ec2.EC2, add addFunc) error {
+	limit := time.Now().AddDate(0, 0, -settings.StoppedInstanceDays)
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"stopped"})}},
+	}
+	return svc.DescribeInstancesPages(input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				match := stoppedAt.FindStringSubmatch(aws.StringValue(i.StateTransitionReason))
+				if match == nil {
+					continue
+				}
+				since, err := time.Parse(stateTransitionLayout, match[1])
+				if err != nil || since.After(limit) {
+					continue
+				}
+				reason := fmt.Sprintf("%s instance has been stopped for more than %d days, its volumes are still billed", aws.StringValue(i.InstanceType), settings.StoppedInstanceDays)
+				add(KindStoppedInstance, *i.InstanceId, core.TagValue("Name", i.Tags), reason, &since, core.Tags(i.Tags))
+			}
+		}
+		return true
+	})
+}
+
+func imagesAndSnapshots(svc *ec2.EC2, add addFunc) error {
+	images, err := svc.DescribeImages(&ec2.DescribeImagesInput{Owners: aws.StringSlice([]string{"self"})})
+	if err != nil {
+		return err
+	}
+	imageLimit := time.Now().AddDate(0, 0, -settings.ImageRetentionDays)
+	// snapshots that back an image can't be deleted until the image is deregistered
+	imageSnapshots := make(map[string]bool)
+	for _, i := range images.Images {
+		for _, m := range i.BlockDeviceMappings {
+			if m.Ebs != nil && m.Ebs.SnapshotId != nil {
+				imageSnapshots[*m.Ebs.SnapshotId] = true
+			}
+		}
+		created, err := time.Parse(imageCreationDateLayout, aws.StringValue(i.CreationDate))
+		if err != nil || created.After(imageLimit) {
+			continue
+		}
+		add(KindOldImage, *i.ImageId, aws.StringValue(i.Name), fmt.Sprintf("Image is older than %d days", settings.ImageRetentionDays), &created, core.Tags(i.Tags))
+	}
+
+	snapshotLimit := time.Now().AddDate(0, 0, -settings.SnapshotRetentionDays)
+	input := &ec2.DescribeSnapshotsInput{OwnerIds: aws.StringSlice([]string{"self"})}
+	return svc.DescribeSnapshotsPages(input, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+		for _, s := range page.Snapshots {
+			if imageSnapshots[*s.SnapshotId] || s.StartTime == nil || s.StartTime.After(snapshotLimit) {
+				continue
+			}
+			name := core.TagValue("Name", s.Tags)
+			if name == "" {
+				name = aws.StringValue(s.Description)
+			}
+			reason := fmt.Sprintf("%d GiB snapshot is older than %d days", aws.Int64Value(s.VolumeSize), settings.SnapshotRetentionDays)
+			add(KindOldSnapshot, *s.SnapshotId, name, reason, s.StartTime, core.Tags(s.Tags))
+		}
+		return true
+	})
+}
+
+func idleInstances(db *storm.DB, cw *cloudwatch.CloudWatch, account, region string, add addFunc) error {
+	var instances []auntec2.Instance
+	query := db.Select(q.Eq("Account", account), q.Eq("Region", region), q.Gte("LastUpdated", time.Now().Add(-core.MaxResourceAge)))
+	if err := query.Find(&instances); err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	since := time.Now().AddDate(0, 0, -settings.LowCPUDays)
+	for _, i := range instances {
+		// instances that haven't been running for the whole period can't be judged yet
+		if i.LaunchTime == nil || i.LaunchTime.After(since) {
+			continue
+		}
+		max, ok := dailyMaxCPU(cw, i.ResourceID, since)
+		if !ok || max >= settings.LowCPUPercent {
+			continue
+		}
+		reason := fmt.Sprintf("%s instance CPU utilisation has stayed below %.1f%% (max %.1f%%) for %d days", i.InstanceType, settings.LowCPUPercent, max, settings.LowCPUDays)
+		add(KindIdleInstance, i.ResourceID, i.Name, reason, &since, i.Tags)
+	}
+	return nil
+}
+
+// dailyMaxCPU returns the highest daily max CPU utilisation since the given time, it returns false if there isn't a
+// datapoint for every day in the period
+func dailyMaxCPU(cw *cloudwatch.CloudWatch, instanceID string, since time.Time) (float64, bool) {
+	result, err := cw.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String("AWS/EC2"),
+		MetricName: aws.String(metricsCPU),
+		Dimensions: []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instanceID)}},
+		StartTime:  aws.Time(since),
+		EndTime:    aws.Time(time.Now()),
+		Period:     aws.Int64(86400),
+		Statistics: aws.StringSlice([]string{cloudwatch.StatisticMaximum}),
+	})
+	if err != nil {
+		fmt.Printf("waste.dailyMaxCPU %v\n", err)
+		return 0, false
+	}
+	if len(result.Datapoints) < settings.LowCPUDays {
+		return 0, false
+	}
+	max := 0.0
+	for _, dp := range result.Datapoints {
+		if aws.Float64Value(dp.Maximum) > max {
+			max = aws.Float64Value(dp.Maximum)
+		}
+	}
+	return max, true
+}
+
+func save(db *storm.DB, finding *Finding) {
+	if err := db.Save(finding); err != nil {
+		fmt.Printf("%+v\n", err)
+	}
+	if !settings.Alerts {
+		return
+	}
+	alert := core.ResourceAlert("Waste."+finding.Kind, finding.Resource, finding.Account, finding.Region, finding.Tags)
+	alert.Message = fmt.Sprintf("%s %s in %s %s", finding.Kind, finding.Name, finding.Account, finding.Region)
+	alert.Description = finding.Reason
+	alert.Priority = core.P5
+	if finding.Since != nil {
+		alert.Details["since"] = finding.Since.Format(time.RFC3339)
+	}
+	core.SaveAlert(db, alert)
+}
@@ -4,16 +4,39 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/asdine/storm"
+	"github.com/boltdb/bolt"
 	"github.com/stojg/aunt/lib/asg"
+	"github.com/stojg/aunt/lib/certificate"
+	"github.com/stojg/aunt/lib/compliance"
 	"github.com/stojg/aunt/lib/core"
+	"github.com/stojg/aunt/lib/cost"
+	"github.com/stojg/aunt/lib/database"
+	"github.com/stojg/aunt/lib/digest"
 	"github.com/stojg/aunt/lib/dynamodb"
 	"github.com/stojg/aunt/lib/ebs"
 	"github.com/stojg/aunt/lib/ec2"
+	"github.com/stojg/aunt/lib/ecs"
+	"github.com/stojg/aunt/lib/elasticache"
+	"github.com/stojg/aunt/lib/elb"
+	"github.com/stojg/aunt/lib/iam"
+	"github.com/stojg/aunt/lib/lambda"
+	"github.com/stojg/aunt/lib/limits"
 	"github.com/stojg/aunt/lib/rds"
+	"github.com/stojg/aunt/lib/recommend"
+	"github.com/stojg/aunt/lib/relations"
+	"github.com/stojg/aunt/lib/rules"
+	"github.com/stojg/aunt/lib/schema"
+	"github.com/stojg/aunt/lib/sqs"
+	"github.com/stojg/aunt/lib/waste"
 	"github.com/urfave/cli"
 )
 
@@ -24,17 +47,59 @@ var (
 	Compiled string
 )
 
+// dbPath is the location of the database file
+const dbPath = "aunt.db"
+
 var regions = []string{}
 
 var roles = map[string]string{}
 
+// tagFlag limits a report to resources with a tag, a key can also be a tag role like team or environment
+var tagFlag = cli.StringSliceFlag{Name: "tag", Usage: "only resources with the tag as key=value, can be repeated"}
+
 // Config holds configuration data, typically loaded from a file
 type Config struct {
 	Roles    map[string]string
 	Regions  []string
 	Opsgenie struct {
 		APIKey string
+		// Source is sent as the source of every alert, aunt when not set
+		Source string
+		// Tags are sent with every alert, SSP when not set
+		Tags []string
+	}
+	Certificates struct {
+		// ExpiryDays are the days before expiry when an alert is raised, e.g. [30, 14, 3]
+		ExpiryDays []int
 	}
+	ELB       elb.Config
+	IAM       iam.Config
+	Limits    limits.Config
+	Waste     waste.Config
+	Cost      cost.Config
+	Recommend recommend.Config
+	Forecast  core.ForecastConfig
+	Anomaly   core.AnomalyConfig
+	Rules     []rules.Rule
+	History   struct {
+		// RetentionDays is how long metric history is kept, it's never shorter than the recommendation or anomaly
+		// detection windows
+		RetentionDays int
+	}
+	// MaintenanceWindows are recurring silences, e.g. every Sunday from 02:00 for 4 hours
+	MaintenanceWindows []core.MaintenanceWindow
+	// Correlation are the rules for suppressing or grouping alerts on resources whose parent resource is alerting
+	Correlation []core.CorrelationRule
+	// Grouping sends alerts that are raised at the same time and share keys, e.g. account and name, as one alert
+	Grouping core.GroupingConfig
+	// Digest sends the low priority alerts, waste and expiring certificates once a day by email or to Slack
+	Digest digest.Config
+	// TagKeys are the tag keys that name the owner, team, environment and cost center of a resource
+	TagKeys core.TagKeysConfig
+	// AccountTags are the tags of each account in Roles, used for routing alerts on the account itself like limits
+	AccountTags map[string]map[string]string
+	// Compliance are the tags that every resource must have and the values that they are allowed to have
+	Compliance compliance.Config
 }
 
 func main() {
@@ -58,35 +123,22 @@ SUPPORT:  http://github.com/stojg/aunt
 
 	app.Before = func(c *cli.Context) error {
 		cfg, err := LoadConfig(c.GlobalString("config"))
-		if err == nil {
-			roles = cfg.Roles
-			regions = cfg.Regions
-			if cfg.Opsgenie.APIKey == "" {
-				return nil
-			}
-			return core.SetOpsGenieToken(cfg.Opsgenie.APIKey)
+		if err != nil {
+			return fmt.Errorf("error during config file read: %v", err)
 		}
-		return fmt.Errorf("error during config file read: %v", err)
+		return configure(cfg)
 	}
 
-	db, err := storm.Open("aunt.db")
-	if err != nil {
-		fmt.Printf("Could not open database file: %v\n", err)
-		os.Exit(1)
-	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			fmt.Printf("error during closing of database file: %v\n", err)
-		}
-	}()
-
 	app.Commands = []cli.Command{
 		{
 			Name:  "update",
 			Usage: "update a metrics",
-			Action: func(c *cli.Context) error {
+			Action: withDB(func(db *storm.DB, c *cli.Context) error {
+				if err := migrate(db); err != nil {
+					return err
+				}
 				return update(db)
-			},
+			}),
 		},
 		{
 			Name:  "serve",
@@ -94,8 +146,199 @@ SUPPORT:  http://github.com/stojg/aunt
 			Flags: []cli.Flag{
 				cli.IntFlag{Name: "port", Value: 8080},
 			},
-			Action: func(c *cli.Context) error {
-				return serve(db)
+			Action: withDB(func(db *storm.DB, c *cli.Context) error {
+				if err := migrate(db); err != nil {
+					return err
+				}
+				return serve(db, c.Int("port"))
+			}),
+		},
+		{
+			Name:  "waste",
+			Usage: "list resources that costs money without being used",
+			Flags: []cli.Flag{tagFlag},
+			Action: withDB(func(db *storm.DB, c *cli.Context) error {
+				return wasteReport(db, c.StringSlice("tag"))
+			}),
+		},
+		{
+			Name:  "recommend",
+			Usage: "list right-sizing recommendations with estimated savings",
+			Flags: []cli.Flag{tagFlag},
+			Action: withDB(func(db *storm.DB, c *cli.Context) error {
+				return recommendReport(db, c.StringSlice("tag"))
+			}),
+		},
+		{
+			Name:  "cost",
+			Usage: "list the estimated monthly cost of resources",
+			Flags: []cli.Flag{tagFlag},
+			Action: withDB(func(db *storm.DB, c *cli.Context) error {
+				return costReport(db, c.StringSlice("tag"))
+			}),
+			Subcommands: []cli.Command{
+				{
+					Name:  "refresh",
+					Usage: "download the current prices for the configured regions to the price list",
+					Action: func(c *cli.Context) error {
+						prices, err := cost.Refresh(regions)
+						if err != nil {
+							return fmt.Errorf("error during price refresh: %v", err)
+						}
+						if err := cost.SavePrices(prices, cost.PriceFile()); err != nil {
+							return fmt.Errorf("error during price refresh: %v", err)
+						}
+						fmt.Printf("Wrote prices for %d regions to %s\n", len(prices.Regions), cost.PriceFile())
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "compliance",
+			Usage: "list the resources that doesn't follow the tag policy and the compliance of each account",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "account", Usage: "only list this account"},
+			},
+			Action: withDB(func(db *storm.DB, c *cli.Context) error {
+				return complianceReport(db, c.String("account"))
+			}),
+		},
+		{
+			Name:  "digest",
+			Usage: "show the digest of low priority alerts, waste and expiring certificates",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "send", Usage: "send the digest by email and to Slack"},
+			},
+			Action: withDB(func(db *storm.DB, c *cli.Context) error {
+				if c.Bool("send") {
+					return digest.Send(db)
+				}
+				d, err := digest.Build(db)
+				if err != nil {
+					return err
+				}
+				fmt.Print(d)
+				return nil
+			}),
+		},
+		{
+			Name:  "silence",
+			Usage: "stop matching alerts from being notified, they are still recorded",
+			Subcommands: []cli.Command{
+				{
+					Name:  "add",
+					Usage: "add a silence, it must match on at least one of account, region, resource, alert or tag",
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "account", Usage: "account id"},
+						cli.StringFlag{Name: "region", Usage: "region, e.g. us-east-1"},
+						cli.StringFlag{Name: "resource", Usage: "resource id, e.g. i-123456"},
+						cli.StringFlag{Name: "alert", Usage: "alert name, a trailing * matches all alerts with that prefix"},
+						cli.StringSliceFlag{Name: "tag", Usage: "resource tag as key=value, can be repeated"},
+						cli.StringFlag{Name: "start", Usage: "start time as RFC3339, default now"},
+						cli.StringFlag{Name: "end", Usage: "end time as RFC3339, overrides the duration"},
+						cli.DurationFlag{Name: "duration", Value: time.Hour, Usage: "how long the silence lasts"},
+						cli.StringFlag{Name: "by", Value: os.Getenv("USER"), Usage: "who created the silence"},
+						cli.StringFlag{Name: "comment", Usage: "why the alerts are silenced"},
+						serverFlag,
+					},
+					Action: withSilences(func(store silenceStore, c *cli.Context) error {
+						return silenceAdd(store, c)
+					}),
+				},
+				{
+					Name:  "list",
+					Usage: "list active and upcoming silences",
+					Flags: []cli.Flag{
+						cli.BoolFlag{Name: "all", Usage: "include expired silences"},
+						serverFlag,
+					},
+					Action: withSilences(func(store silenceStore, c *cli.Context) error {
+						return silenceList(store, c.Bool("all"))
+					}),
+				},
+				{
+					Name:      "expire",
+					Usage:     "end a silence now",
+					ArgsUsage: "<id>",
+					Flags:     []cli.Flag{serverFlag},
+					Action: withSilences(func(store silenceStore, c *cli.Context) error {
+						id, err := strconv.Atoi(c.Args().First())
+						if err != nil {
+							return fmt.Errorf("expire needs the id of a silence")
+						}
+						if err := store.Expire(id); err != nil {
+							return err
+						}
+						fmt.Printf("Expired silence %d\n", id)
+						return nil
+					}),
+				},
+			},
+		},
+		{
+			Name:  "db",
+			Usage: "database maintenance",
+			Subcommands: []cli.Command{
+				{
+					Name:  "migrate",
+					Usage: "run all pending schema migrations",
+					Action: withDB(func(db *storm.DB, c *cli.Context) error {
+						return migrate(db)
+					}),
+				},
+				{
+					Name:  "status",
+					Usage: "show the schema version and the migrations that has been applied",
+					Action: withDB(func(db *storm.DB, c *cli.Context) error {
+						return dbStatus(db)
+					}),
+				},
+				{
+					Name:      "backup",
+					Usage:     "write a consistent snapshot of the database to a file",
+					ArgsUsage: "<file>",
+					Action: withDB(func(db *storm.DB, c *cli.Context) error {
+						if c.NArg() != 1 {
+							return fmt.Errorf("backup needs the path to the backup file")
+						}
+						written, err := database.BackupFile(db, c.Args().First())
+						if err != nil {
+							return fmt.Errorf("error during backup: %v", err)
+						}
+						fmt.Printf("Wrote %d bytes to %s\n", written, c.Args().First())
+						return nil
+					}),
+				},
+				{
+					Name:  "compact",
+					Usage: "rewrite the database file to reclaim unused space",
+					Action: withDB(func(db *storm.DB, c *cli.Context) error {
+						return dbCompact(db)
+					}),
+				},
+				{
+					Name:      "export",
+					Usage:     "export buckets as JSON lines to stdout or a file",
+					ArgsUsage: "[bucket...]",
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "file", Usage: "write to this file instead of stdout"},
+					},
+					Action: withDB(func(db *storm.DB, c *cli.Context) error {
+						return dbExport(db, c.String("file"), c.Args())
+					}),
+				},
+				{
+					Name:      "import",
+					Usage:     "import JSON lines from a previous export, existing keys are overwritten",
+					ArgsUsage: "<file>",
+					Action: withDB(func(db *storm.DB, c *cli.Context) error {
+						if c.NArg() != 1 {
+							return fmt.Errorf("import needs the path to an export file, use - for stdin")
+						}
+						return dbImport(db, c.Args().First())
+					}),
+				},
 			},
 		},
 	}
@@ -105,7 +348,29 @@ SUPPORT:  http://github.com/stojg/aunt
 	}
 }
 
+// withDB opens the database for the duration of a command. Bolt holds an exclusive lock on the file, so commands
+// that doesn't need the database can run while aunt serve is running.
+func withDB(action func(db *storm.DB, c *cli.Context) error) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		// don't wait forever if aunt is already running
+		db, err := storm.Open(dbPath, storm.BoltOptions(0600, &bolt.Options{Timeout: 2 * time.Second}))
+		if err != nil {
+			if err == bolt.ErrTimeout {
+				fmt.Println("Is aunt serve running? Use http://localhost:8080/db/backup to get a backup from a running server.")
+			}
+			return fmt.Errorf("could not open database file: %v", err)
+		}
+		defer func() {
+			if err := db.Close(); err != nil {
+				fmt.Printf("error during closing of database file: %v\n", err)
+			}
+		}()
+		return action(db, c)
+	}
+}
+
 func update(db *storm.DB) error {
+	started := time.Now()
 	if err := asg.Update(db, roles, regions); err != nil {
 		return fmt.Errorf("error during update: %v", err)
 	}
@@ -121,20 +386,387 @@ func update(db *storm.DB) error {
 	if err := dynamodb.Update(db, roles, regions); err != nil {
 		return fmt.Errorf("error during update: %v", err)
 	}
-	if err := core.Purge(db, 15*time.Minute); err != nil {
+	if err := elb.Update(db, roles, regions); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := elasticache.Update(db, roles, regions); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := lambda.Update(db, roles, regions); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := sqs.Update(db, roles, regions); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := ecs.Update(db, roles, regions); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := certificate.Update(db, roles, regions); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := iam.Update(db, roles, regions); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := limits.Update(db, roles, regions); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := relations.Update(db); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := waste.Update(db, roles, regions); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := rules.Update(db); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := compliance.Update(db); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := cost.Update(db); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := recommend.Update(db); err != nil {
+		return fmt.Errorf("error during update: %v", err)
+	}
+	if err := core.Notify(db, started); err != nil {
+		return fmt.Errorf("error during alert notification: %v", err)
+	}
+	// the digest is sent by email and Slack, which can be down without affecting the rest of the update
+	if err := digest.Update(db); err != nil {
+		fmt.Printf("error during digest: %v\n", err)
+	}
+	if err := core.Purge(db, core.AlertExpiry); err != nil {
 		return fmt.Errorf("error during alert purge: %v", err)
 	}
+	if err := core.PurgeHistory(db); err != nil {
+		return fmt.Errorf("error during history purge: %v", err)
+	}
 	return nil
 }
 
-func serve(db *storm.DB) error {
+func wasteReport(db *storm.DB, tags []string) error {
+	filter, err := core.ParseTagFilter(tags)
+	if err != nil {
+		return err
+	}
+	findings, err := waste.Report(db, filter)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tREGION\tKIND\tRESOURCE\tNAME\tREASON")
+	for _, f := range findings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", f.Account, f.Region, f.Kind, f.Resource, f.Name, f.Reason)
+	}
+	return w.Flush()
+}
+
+func costReport(db *storm.DB, tags []string) error {
+	filter, err := core.ParseTagFilter(tags)
+	if err != nil {
+		return err
+	}
+	estimates, summary, err := cost.Report(db, filter)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tREGION\tKIND\tRESOURCE\tNAME\tBASIS\tMONTHLY")
+	for _, e := range estimates {
+		monthly := "no price"
+		if e.Priced {
+			monthly = fmt.Sprintf("%.2f", e.Monthly)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Account, e.Region, e.Kind, e.Resource, e.Name, e.Basis, monthly)
+	}
+	fmt.Fprintf(w, "\t\t\t\t\tTOTAL\t%.2f\n", summary.Total)
+	return w.Flush()
+}
+
+func recommendReport(db *storm.DB, tags []string) error {
+	filter, err := core.ParseTagFilter(tags)
+	if err != nil {
+		return err
+	}
+	recommendations, err := recommend.Report(db, filter)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tREGION\tRESOURCE\tNAME\tCURRENT\tSUGGESTED\tSAVINGS\tREASON")
+	for _, r := range recommendations {
+		savings := "unknown"
+		if r.MonthlySavings != nil {
+			savings = fmt.Sprintf("%.2f", *r.MonthlySavings)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Account, r.Region, r.Resource, r.Name, r.Current, r.Suggested, savings, r.Reason)
+	}
+	return w.Flush()
+}
+
+func complianceReport(db *storm.DB, account string) error {
+	scores, violations, err := compliance.Report(db, account)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCOUNT\tRESOURCES\tCOMPLIANT\tPERCENT")
+	for _, s := range scores {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\n", s.Account, s.Resources, s.Compliant, s.Percent())
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "ACCOUNT\tREGION\tKIND\tRESOURCE\tNAME\tPROBLEMS")
+	for _, v := range violations {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Account, v.Region, v.Kind, v.Resource, v.Name, v.Problems())
+	}
+	return w.Flush()
+}
+
+func silenceAdd(store silenceStore, c *cli.Context) error {
+	silence := &core.Silence{
+		Matcher: core.Matcher{
+			Account:    c.String("account"),
+			Region:     c.String("region"),
+			ResourceID: c.String("resource"),
+			AlertName:  c.String("alert"),
+		},
+		CreatedBy: c.String("by"),
+		Comment:   c.String("comment"),
+	}
+	for _, tag := range c.StringSlice("tag") {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("tag %q should be key=value", tag)
+		}
+		if silence.Tags == nil {
+			silence.Tags = make(map[string]string)
+		}
+		silence.Tags[parts[0]] = parts[1]
+	}
+	silence.Start = time.Now()
+	if start := c.String("start"); start != "" {
+		t, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return fmt.Errorf("start should be like %s: %v", time.RFC3339, err)
+		}
+		silence.Start = t
+	}
+	silence.End = silence.Start.Add(c.Duration("duration"))
+	if end := c.String("end"); end != "" {
+		t, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			return fmt.Errorf("end should be like %s: %v", time.RFC3339, err)
+		}
+		silence.End = t
+	}
+	if err := store.Add(silence); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s\n", silence)
+	return nil
+}
+
+func silenceList(store silenceStore, all bool) error {
+	silences, err := store.List(all)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTART\tEND\tACCOUNT\tREGION\tRESOURCE\tALERT\tTAGS\tBY\tCOMMENT")
+	for _, s := range silences {
+		var tags []string
+		for key, value := range s.Tags {
+			tags = append(tags, key+"="+value)
+		}
+		sort.Strings(tags)
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.Start.Format(time.RFC3339), s.End.Format(time.RFC3339),
+			s.Account, s.Region, s.ResourceID, s.AlertName, strings.Join(tags, ","), s.CreatedBy, s.Comment)
+	}
+	return w.Flush()
+}
+
+func migrate(db *storm.DB) error {
+	done, err := schema.Migrate(db)
+	for _, m := range done {
+		fmt.Printf("Migrated database to version %d: %s\n", m.Version, m.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("error during database migration: %v", err)
+	}
+	return nil
+}
+
+func dbStatus(db *storm.DB) error {
+	current, err := schema.Current(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema version: %d (latest %d)\n", current, schema.Latest())
+	applied, err := schema.History(db)
+	if err != nil {
+		return err
+	}
+	for _, a := range applied {
+		fmt.Printf(" %3d applied %s - %s\n", a.Version, a.Applied.Format(time.RFC3339), a.Name)
+	}
+	pending, err := schema.Pending(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		fmt.Printf(" %3d pending - %s\n", m.Version, m.Name)
+	}
+	return nil
+}
+
+func dbCompact(db *storm.DB) error {
+	before, err := os.Stat(dbPath)
+	if err != nil {
+		return err
+	}
+	tmp := dbPath + ".compact"
+	if err := database.Compact(db, tmp); err != nil {
+		return fmt.Errorf("error during compaction: %v", err)
+	}
+	after, err := os.Stat(tmp)
+	if err != nil {
+		return err
+	}
+	// the open database still points to the old file which is removed when it's closed
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return err
+	}
+	fmt.Printf("Compacted %s from %d to %d bytes\n", dbPath, before.Size(), after.Size())
+	return nil
+}
+
+func dbExport(db *storm.DB, file string, buckets []string) error {
+	out := os.Stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				fmt.Printf("error during closing of export file: %v\n", err)
+			}
+		}()
+		out = f
+	}
+	count, err := database.Export(db, out, buckets...)
+	if err != nil {
+		return fmt.Errorf("error during export: %v", err)
+	}
+	if file != "" {
+		fmt.Printf("Exported %d records to %s\n", count, file)
+	}
+	return nil
+}
+
+func dbImport(db *storm.DB, file string) error {
+	in := os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				fmt.Printf("error during closing of import file: %v\n", err)
+			}
+		}()
+		in = f
+	}
+	count, err := database.Import(db, in)
+	if err != nil {
+		return fmt.Errorf("error during import after %d records: %v", count, err)
+	}
+	fmt.Printf("Imported %d records\n", count)
+	return nil
+}
+
+func serve(db *storm.DB, port int) error {
+	httpErr := make(chan error, 1)
+	go func() {
+		httpErr <- http.ListenAndServe(fmt.Sprintf(":%d", port), newHandler(db))
+	}()
+
 	resourceTicker := time.NewTicker(10 * time.Minute)
 	for {
 		if err := update(db); err != nil {
 			return fmt.Errorf("error during update: %v", err)
 		}
-		<-resourceTicker.C
+		select {
+		case err := <-httpErr:
+			return fmt.Errorf("error during http serve: %v", err)
+		case <-resourceTicker.C:
+		}
+	}
+}
+
+// configure sets up the packages with the values from the config
+func configure(cfg *Config) error {
+	roles = cfg.Roles
+	regions = cfg.Regions
+	if len(cfg.Certificates.ExpiryDays) > 0 {
+		if err := certificate.SetExpiryStages(cfg.Certificates.ExpiryDays); err != nil {
+			return fmt.Errorf("error in config file: %v", err)
+		}
+	}
+	core.ConfigureTagKeys(cfg.TagKeys)
+	core.ConfigureAccountTags(cfg.AccountTags)
+	if err := elb.Configure(cfg.ELB); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
+	}
+	iam.Configure(cfg.IAM)
+	if err := limits.Configure(cfg.Limits); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
+	}
+	waste.Configure(cfg.Waste)
+	cost.Configure(cfg.Cost)
+	if err := recommend.Configure(cfg.Recommend); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
+	}
+	if err := core.ConfigureAnomalies(cfg.Anomaly); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
+	}
+	if err := rules.Configure(cfg.Rules); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
 	}
+	if err := core.ConfigureMaintenanceWindows(cfg.MaintenanceWindows); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
+	}
+	if err := core.ConfigureCorrelation(cfg.Correlation); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
+	}
+	if err := core.ConfigureGrouping(cfg.Grouping); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
+	}
+	if err := digest.Configure(cfg.Digest); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
+	}
+	if err := compliance.Configure(cfg.Compliance); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
+	}
+	retention := cfg.History.RetentionDays
+	if min := recommend.WindowDays() + 1; retention < min {
+		retention = min
+	}
+	if min := core.AnomalyWindowDays() + 1; core.AnomalyDetection() && retention < min {
+		retention = min
+	}
+	if err := core.SetHistoryRetention(retention); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
+	}
+	if err := core.ConfigureForecast(cfg.Forecast); err != nil {
+		return fmt.Errorf("error in config file: %v", err)
+	}
+	core.SetAlertDefaults(cfg.Opsgenie.Source, cfg.Opsgenie.Tags)
+	if cfg.Opsgenie.APIKey != "" {
+		return core.SetOpsGenieToken(cfg.Opsgenie.APIKey)
+	}
+	return nil
 }
 
 // LoadConfig loads and json configuration file into Config struct
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/stojg/aunt/lib/core"
+	"github.com/urfave/cli"
+)
+
+// serverFlag is the address of a running aunt server, the silence commands use its API since the server keeps the
+// database locked
+var serverFlag = cli.StringFlag{Name: "server", Value: "http://localhost:8080", Usage: "address of a running aunt serve"}
+
+// silenceStore adds, lists and expires silences, either in the database or through the API of a running server
+type silenceStore interface {
+	Add(silence *core.Silence) error
+	List(all bool) ([]core.Silence, error)
+	Expire(id int) error
+}
+
+// withSilences runs the action against the running server, or against the database when no server is running
+func withSilences(action func(store silenceStore, c *cli.Context) error) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		server := &silenceServer{
+			url:    strings.TrimSuffix(c.String("server"), "/"),
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+		if server.running() {
+			return action(server, c)
+		}
+		return withDB(func(db *storm.DB, c *cli.Context) error {
+			return action(&silenceDB{db: db}, c)
+		})(c)
+	}
+}
+
+type silenceDB struct {
+	db *storm.DB
+}
+
+func (s *silenceDB) Add(silence *core.Silence) error {
+	return core.AddSilence(s.db, silence)
+}
+
+func (s *silenceDB) List(all bool) ([]core.Silence, error) {
+	return core.Silences(s.db, all)
+}
+
+func (s *silenceDB) Expire(id int) error {
+	return core.ExpireSilence(s.db, id)
+}
+
+type silenceServer struct {
+	url    string
+	client *http.Client
+}
+
+// running returns true if the silences API answers
+func (s *silenceServer) running() bool {
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(s.url + "/silences")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func (s *silenceServer) Add(silence *core.Silence) error {
+	body, err := json.Marshal(silence)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url+"/silences", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(silence)
+}
+
+func (s *silenceServer) List(all bool) ([]core.Silence, error) {
+	resp, err := s.client.Get(fmt.Sprintf("%s/silences?all=%t", s.url, all))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var silences []core.Silence
+	if err := json.NewDecoder(resp.Body).Decode(&silences); err != nil {
+		return nil, err
+	}
+	return silences, nil
+}
+
+func (s *silenceServer) Expire(id int) error {
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/silences/%d", s.url, id), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// responseError returns the error message from the server, or the status when there is no message
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	if message := strings.TrimSpace(string(body)); message != "" {
+		return fmt.Errorf("%s", message)
+	}
+	return fmt.Errorf("server responded with %s", resp.Status)
+}